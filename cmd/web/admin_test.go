@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"dvhthomas/snippetbox/pkg/models"
+	"dvhthomas/snippetbox/pkg/models/memory"
+)
+
+func TestRunAdmin(t *testing.T) {
+	// The mock users can't change, so use the memory backend to see that the
+	// commands really did something.
+	db := memory.New()
+	users := &memory.UserModel{DB: db}
+	if err := users.Insert("Alice", "alice@example.com", "validPa$$word"); err != nil {
+		t.Fatal(err)
+	}
+	sessions := &memory.SessionModel{DB: db}
+	token, err := sessions.Insert(1, "192.0.2.1", "Firefox on Linux", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit := &memory.AuditModel{DB: db}
+	app := &application{users: users, userSessions: sessions, auditEvents: audit}
+
+	// Each step runs against what the previous step left behind
+	tests := []struct {
+		name    string
+		args    []string
+		stdin   string
+		wantOut string
+		wantErr string
+		check   func(*models.User) bool
+	}{
+		{"List", []string{"users"}, "", "alice@example.com", "", nil},
+		{"Verify", []string{"verify", "alice@example.com"}, "", "Done", "",
+			func(u *models.User) bool { return u.Verified }},
+		{"Deactivate", []string{"deactivate", "alice@example.com"}, "", "Done", "",
+			func(u *models.User) bool { return !u.Active }},
+		{"Reactivate", []string{"reactivate", "alice@example.com"}, "", "Done", "",
+			func(u *models.User) bool { return u.Active }},
+		{"Promote", []string{"promote", "alice@example.com"}, "", "Done", "",
+			func(u *models.User) bool { return u.Role == models.RoleAdmin }},
+		{"Listed as admin", []string{"users"}, "", "admin", "", nil},
+		{"Demote", []string{"demote", "alice@example.com"}, "", "Done", "",
+			func(u *models.User) bool { return u.Role == models.RoleUser }},
+		{"Set password", []string{"set-password", "alice@example.com"}, "newPa$$word123\n", "Done", "", nil},
+		{"Short password", []string{"set-password", "alice@example.com"}, "short\n", "", "at least 10 characters", nil},
+		{"Unknown user", []string{"deactivate", "nobody@example.com"}, "", "", "no user", nil},
+		{"Unknown command", []string{"delete", "alice@example.com"}, "", "", "unknown admin command", nil},
+		{"Missing email", []string{"promote"}, "", "", "usage", nil},
+		{"No command", []string{}, "", "", "usage", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := app.runAdmin(tt.args, strings.NewReader(tt.stdin), &out)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("want an error containing %q; got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(out.String(), tt.wantOut) {
+				t.Errorf("want output to contain %q; got %q", tt.wantOut, out.String())
+			}
+			if tt.check != nil {
+				u, err := users.GetByEmail("alice@example.com")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !tt.check(u) {
+					t.Errorf("the user wasn't changed; got %+v", u)
+				}
+			}
+		})
+	}
+
+	// Only the new password works now
+	if _, err := users.Authenticate("alice@example.com", "newPa$$word123"); err != nil {
+		t.Errorf("want the new password to work; got %v", err)
+	}
+	// Every change is in the audit log, newest first, as coming from the
+	// command line
+	events, err := audit.List(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"user.password_set", "user.demote", "user.promote", "user.reactivate", "user.deactivate", "user.verify"}
+	if len(events) != len(want) {
+		t.Fatalf("want %d events; got %d", len(want), len(events))
+	}
+	for i, e := range events {
+		if e.Action != want[i] || e.UserID != 0 || e.Target != "user:1" || e.IP != "cli" {
+			t.Errorf("want %s of user:1 from cli; got %+v", want[i], e)
+		}
+	}
+
+	// And wherever she was logged in with the old one, she isn't any more
+	if _, err := sessions.Authenticate(token, "192.0.2.1"); !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("want %v; got %v", models.ErrInvalidCredentials, err)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"dvhthomas/snippetbox/pkg/forms"
+	"dvhthomas/snippetbox/pkg/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strconv"
+	"time"
+)
+
+// The JSON API speaks in these types rather than the models directly so that
+// what we send over the wire doesn't change every time a model does.
+type apiAuthor struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type apiSnippet struct {
+	ID         int       `json:"id"`
+	Title      string    `json:"title"`
+	Content    string    `json:"content"`
+	Language   string    `json:"language"`
+	Visibility string    `json:"visibility"`
+	URL        string    `json:"url"`
+	Created    time.Time `json:"created"`
+	Expires    time.Time `json:"expires"`
+	// Snippets created before we recorded authors don't have one
+	Author *apiAuthor `json:"author,omitempty"`
+}
+
+func newAPISnippet(s *models.Snippet) *apiSnippet {
+	as := &apiSnippet{
+		ID:         s.ID,
+		Title:      s.Title,
+		Content:    s.Content,
+		Language:   s.Language,
+		Visibility: s.Visibility,
+		URL:        snippetURL(s),
+		Created:    s.Created,
+		Expires:    s.Expires,
+	}
+	if s.AuthorID != 0 {
+		as.Author = &apiAuthor{ID: s.AuthorID, Name: s.AuthorName}
+	}
+	return as
+}
+
+// A page of the archive, with cursors for the neighbouring pages if there are any
+type apiSnippetList struct {
+	Snippets []*apiSnippet `json:"snippets"`
+	Prev     string        `json:"prev,omitempty"`
+	Next     string        `json:"next,omitempty"`
+}
+
+// What clients send to create or update a snippet. Expires is a number of
+// days and is ignored by updates. Leaving out the language means it's
+// detected from the content, and leaving out the visibility means public for
+// new snippets and unchanged for existing ones.
+type apiSnippetInput struct {
+	Title      string `json:"title"`
+	Content    string `json:"content"`
+	Language   string `json:"language"`
+	Visibility string `json:"visibility"`
+	Expires    int    `json:"expires"`
+}
+
+// Turn the input into form values so that the API is validated by exactly the
+// same rules as the HTML forms.
+func (in *apiSnippetInput) form() *forms.Form {
+	data := url.Values{
+		"title":      []string{in.Title},
+		"content":    []string{in.Content},
+		"language":   []string{in.Language},
+		"visibility": []string{in.Visibility},
+	}
+	// A missing expiry should be reported as blank rather than invalid
+	if in.Expires != 0 {
+		data.Set("expires", strconv.Itoa(in.Expires))
+	}
+	return forms.New(data)
+}
+
+// Every error from the API has the same shape. Fields is only used for
+// validation errors and holds the messages for each invalid field.
+type apiErrorBody struct {
+	Error  string              `json:"error"`
+	Fields map[string][]string `json:"fields,omitempty"`
+}
+
+func (app *application) apiListSnippets(w http.ResponseWriter, r *http.Request) {
+	cursor, err := cursorFromQuery(r)
+	if err != nil {
+		app.apiError(w, http.StatusBadRequest, "malformed cursor")
+		return
+	}
+
+	s, prev, next, err := app.snippetPage(cursor)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	list := &apiSnippetList{
+		Snippets: []*apiSnippet{},
+		Prev:     prev,
+		Next:     next,
+	}
+	for _, snippet := range s {
+		list.Snippets = append(list.Snippets, newAPISnippet(snippet))
+	}
+	app.writeJSON(w, http.StatusOK, list)
+}
+
+func (app *application) apiShowSnippet(w http.ResponseWriter, r *http.Request) {
+	s, err := app.snippetFromURL(r)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiError(w, http.StatusNotFound, "snippet not found")
+		} else {
+			app.apiServerError(w, err)
+		}
+		return
+	}
+
+	// The API only looks snippets up by ID, so unlisted snippets are as
+	// hidden here as they are in the HTML pages.
+	if !app.canViewByID(r, s) {
+		app.apiError(w, http.StatusNotFound, "snippet not found")
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, newAPISnippet(s))
+}
+
+func (app *application) apiCreateSnippet(w http.ResponseWriter, r *http.Request) {
+	var in apiSnippetInput
+	if !app.readJSON(w, r, &in) {
+		return
+	}
+
+	if in.Visibility == "" {
+		in.Visibility = models.VisibilityPublic
+	}
+	form := in.form()
+	validateNewSnippet(form)
+	if !form.Valid() {
+		app.apiValidationError(w, form)
+		return
+	}
+
+	id, err := app.snippets.Insert(
+		app.authenticatedUserID(r),
+		form.Get("title"),
+		form.Get("content"),
+		snippetLanguage(form),
+		form.Get("visibility"),
+		form.Get("expires"),
+	)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+	app.audit(r, app.authenticatedUserID(r), "snippet.create", fmt.Sprintf("snippet:%d", id))
+
+	// Read it back so the client gets the timestamps and author too
+	s, err := app.snippets.Get(id)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/snippets/%d", id))
+	app.writeJSON(w, http.StatusCreated, newAPISnippet(s))
+}
+
+func (app *application) apiUpdateSnippet(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.apiOwnedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	var in apiSnippetInput
+	if !app.readJSON(w, r, &in) {
+		return
+	}
+
+	if in.Visibility == "" {
+		in.Visibility = s.Visibility
+	}
+	form := in.form()
+	validateSnippet(form)
+	if !form.Valid() {
+		app.apiValidationError(w, form)
+		return
+	}
+
+	err := app.snippets.Update(s.ID, form.Get("title"), form.Get("content"),
+		snippetLanguage(form), form.Get("visibility"))
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	// Send back what was stored. The slug, and so the URL, never changes.
+	s.Title, s.Content = form.Get("title"), form.Get("content")
+	s.Language, s.Visibility = snippetLanguage(form), form.Get("visibility")
+	app.writeJSON(w, http.StatusOK, newAPISnippet(s))
+}
+
+func (app *application) apiDeleteSnippet(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.apiOwnedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.snippets.Delete(s.ID)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// The API equivalent of ownedSnippet, which reports problems as JSON
+func (app *application) apiOwnedSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	s, err := app.snippetFromURL(r)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiError(w, http.StatusNotFound, "snippet not found")
+		} else {
+			app.apiServerError(w, err)
+		}
+		return nil, false
+	}
+
+	if !app.isAuthor(r, s) {
+		if s.Visibility != models.VisibilityPublic {
+			app.apiError(w, http.StatusNotFound, "snippet not found")
+		} else {
+			app.apiError(w, http.StatusForbidden, "only the author can change a snippet")
+		}
+		return nil, false
+	}
+	return s, true
+}
+
+func (app *application) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	// Encode to a buffer first, just like render, so that a failure doesn't
+	// leave a half-written response behind.
+	js, err := json.Marshal(v)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(js, '\n'))
+}
+
+// Decode a JSON request body into dst. If that fails a 400 has already been
+// sent and false is returned.
+func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	// Snippets are text, so a megabyte is plenty
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		app.apiError(w, http.StatusBadRequest, fmt.Sprintf("malformed JSON: %s", err))
+		return false
+	}
+	return true
+}
+
+func (app *application) apiError(w http.ResponseWriter, status int, message string) {
+	app.writeJSON(w, status, &apiErrorBody{Error: message})
+}
+
+func (app *application) apiValidationError(w http.ResponseWriter, form *forms.Form) {
+	app.writeJSON(w, http.StatusUnprocessableEntity, &apiErrorBody{
+		Error:  "validation failed",
+		Fields: form.Errors,
+	})
+}
+
+// Like serverError, the details go to the log and not to the client
+func (app *application) apiServerError(w http.ResponseWriter, err error) {
+	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
+	app.errorLog.Output(2, trace)
+	app.apiError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAPIShowSnippet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name      string
+		urlPath   string
+		wantCode  int
+		wantTitle string
+		wantError string
+	}{
+		{"Valid ID", "/api/v1/snippets/1", http.StatusOK, "An old silent pond", ""},
+		{"Non-existent ID", "/api/v1/snippets/2", http.StatusNotFound, "", "snippet not found"},
+		{"String ID", "/api/v1/snippets/foo", http.StatusNotFound, "", "snippet not found"},
+		{"Unlisted ID", "/api/v1/snippets/4", http.StatusNotFound, "", "snippet not found"},
+		{"Private ID", "/api/v1/snippets/5", http.StatusNotFound, "", "snippet not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, body := ts.get(t, tt.urlPath)
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if ct := header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("want Content-Type %q; got %q", "application/json", ct)
+			}
+
+			var got struct {
+				Title string `json:"title"`
+				Error string `json:"error"`
+			}
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Title != tt.wantTitle {
+				t.Errorf("want title %q; got %q", tt.wantTitle, got.Title)
+			}
+			if got.Error != tt.wantError {
+				t.Errorf("want error %q; got %q", tt.wantError, got.Error)
+			}
+		})
+	}
+}
+
+func TestAPIListSnippets(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, body := ts.get(t, "/api/v1/snippets")
+	if code != http.StatusOK {
+		t.Errorf("want %d; got %d", http.StatusOK, code)
+	}
+
+	var got struct {
+		Snippets []struct {
+			ID int `json:"id"`
+		} `json:"snippets"`
+	}
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Snippets) != 2 {
+		t.Errorf("want 2 snippets; got %d", len(got.Snippets))
+	}
+
+	code, _, _ = ts.get(t, "/api/v1/snippets?after=bogus")
+	if code != http.StatusBadRequest {
+		t.Errorf("want %d; got %d", http.StatusBadRequest, code)
+	}
+}
+
+func TestAPIChangeSnippets(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Get a CSRF token but don't log in
+	_, _, body := ts.get(t, "/user/login")
+	header := http.Header{}
+	header.Set("X-CSRF-Token", extractCSRFToken(t, body))
+
+	code, _, body := ts.do(t, http.MethodPost, "/api/v1/snippets", strings.NewReader(`{}`), header)
+	if code != http.StatusUnauthorized {
+		t.Errorf("want %d; got %d", http.StatusUnauthorized, code)
+	}
+	if !bytes.Contains(body, []byte(`"error":"authentication required"`)) {
+		t.Errorf("want a JSON error; got %s", body)
+	}
+
+	csrfToken := ts.login(t)
+	header.Set("Content-Type", "application/json")
+	header.Set("X-CSRF-Token", csrfToken)
+
+	tests := []struct {
+		name         string
+		method       string
+		urlPath      string
+		body         string
+		header       http.Header
+		wantCode     int
+		wantLocation string
+		wantBody     []byte
+	}{
+		{"Create", http.MethodPost, "/api/v1/snippets", `{"title":"T","content":"C","expires":7}`, header, http.StatusCreated, "/api/v1/snippets/2", []byte(`"visibility":"public"`)},
+		{"Create unlisted", http.MethodPost, "/api/v1/snippets", `{"title":"T","content":"C","visibility":"unlisted","expires":7}`, header, http.StatusCreated, "/api/v1/snippets/2", []byte(`"url":"/s/n3wSn1pp3tSl"`)},
+		{"Create with bad visibility", http.MethodPost, "/api/v1/snippets", `{"title":"T","content":"C","visibility":"secret","expires":7}`, header, http.StatusUnprocessableEntity, "", []byte(`"visibility":["The field is invalid"]`)},
+		{"Create without expiry", http.MethodPost, "/api/v1/snippets", `{"title":"T","content":"C"}`, header, http.StatusUnprocessableEntity, "", []byte(`"expires":["This field cannot be blank"]`)},
+		{"Create with bad expiry", http.MethodPost, "/api/v1/snippets", `{"title":"T","content":"C","expires":30}`, header, http.StatusUnprocessableEntity, "", []byte(`"expires":["The field is invalid"]`)},
+		{"Create with unknown field", http.MethodPost, "/api/v1/snippets", `{"title":"T","colour":"red"}`, header, http.StatusBadRequest, "", []byte("malformed JSON")},
+		{"Create without CSRF token", http.MethodPost, "/api/v1/snippets", `{}`, nil, http.StatusBadRequest, "", []byte(`"error"`)},
+		{"Update own snippet", http.MethodPut, "/api/v1/snippets/1", `{"title":"New","content":"C"}`, header, http.StatusOK, "", []byte(`"title":"New"`)},
+		{"Update with blank title", http.MethodPut, "/api/v1/snippets/1", `{"title":"","content":"C"}`, header, http.StatusUnprocessableEntity, "", []byte(`"title":["This field cannot be blank"]`)},
+		{"Update someone else's snippet", http.MethodPut, "/api/v1/snippets/3", `{"title":"New","content":"C"}`, header, http.StatusForbidden, "", nil},
+		{"Update someone else's private snippet", http.MethodPut, "/api/v1/snippets/5", `{"title":"New","content":"C"}`, header, http.StatusNotFound, "", nil},
+		{"Update keeps visibility", http.MethodPut, "/api/v1/snippets/6", `{"title":"New","content":"C"}`, header, http.StatusOK, "", []byte(`"visibility":"private"`)},
+		{"Delete non-existent snippet", http.MethodDelete, "/api/v1/snippets/99", ``, header, http.StatusNotFound, "", nil},
+		{"Delete someone else's snippet", http.MethodDelete, "/api/v1/snippets/3", ``, header, http.StatusForbidden, "", nil},
+		{"Delete own snippet", http.MethodDelete, "/api/v1/snippets/1", ``, header, http.StatusNoContent, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, body := ts.do(t, tt.method, tt.urlPath, strings.NewReader(tt.body), tt.header)
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if loc := header.Get("Location"); loc != tt.wantLocation {
+				t.Errorf("want Location %q; got %q", tt.wantLocation, loc)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body %s to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAPITokenAuthentication(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	body := `{"title":"T","content":"C","expires":7}`
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantCode      int
+		wantBody      []byte
+	}{
+		// No CSRF token is needed when using an API token
+		{"Valid token", "Bearer valid-token", http.StatusCreated, []byte(`"author":{"id":1`)},
+		{"Invalid token", "Bearer wrong-token", http.StatusUnauthorized, []byte("invalid API token")},
+		{"Not a bearer token", "Basic YWxpY2U6cGFzc3dvcmQ=", http.StatusUnauthorized, []byte("malformed Authorization header")},
+		{"Empty bearer token", "Bearer ", http.StatusUnauthorized, []byte("malformed Authorization header")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set("Content-Type", "application/json")
+			header.Set("Authorization", tt.authorization)
+
+			code, _, resBody := ts.do(t, http.MethodPost, "/api/v1/snippets", strings.NewReader(body), header)
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(resBody, tt.wantBody) {
+				t.Errorf("want body %s to contain %q", resBody, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"dvhthomas/snippetbox/pkg/models"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The admin console. Every route in here sits behind requireRole, so the
+// handlers can assume that the user is an admin.
+
+func (app *application) adminUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := app.users.List()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "adminusers.page.tmpl", &templateData{
+		Users: users,
+	})
+}
+
+func (app *application) adminSnippets(w http.ResponseWriter, r *http.Request) {
+	page, err := pageFromQuery(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	s, err := app.snippets.All(page)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	td := &templateData{Snippets: s}
+	if page > 1 {
+		td.PrevPage = page - 1
+	}
+	// The same guess as the search results
+	if len(s) == models.AdminSnippetsPerPage {
+		td.NextPage = page + 1
+	}
+
+	app.render(w, r, "adminsnippets.page.tmpl", td)
+}
+
+func (app *application) adminDeactivateUser(w http.ResponseWriter, r *http.Request) {
+	app.adminSetActive(w, r, false)
+}
+
+func (app *application) adminReactivateUser(w http.ResponseWriter, r *http.Request) {
+	app.adminSetActive(w, r, true)
+}
+
+// Deactivated users are logged out by the authenticate middleware on their
+// next request, and their API tokens stop working.
+func (app *application) adminSetActive(w http.ResponseWriter, r *http.Request, active bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	u, err := app.users.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// Otherwise an admin could lock themselves out with one click
+	if u.ID == app.authenticatedUserID(r) {
+		app.session.Put(r, "flash", "You can't deactivate yourself.")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	err = app.users.SetActive(u.ID, active)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	action, done := "user.deactivate", "deactivated"
+	if active {
+		action, done = "user.reactivate", "reactivated"
+	}
+	app.audit(r, app.authenticatedUserID(r), action, fmt.Sprintf("user:%d", u.ID))
+
+	app.session.Put(r, "flash", fmt.Sprintf("%s has been %s.", u.Email, done))
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// Delete any snippet at all, including hidden and expired ones
+func (app *application) adminDeleteSnippet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	err = app.snippets.Delete(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+	app.audit(r, app.authenticatedUserID(r), "snippet.delete", fmt.Sprintf("snippet:%d", id))
+
+	app.session.Put(r, "flash", fmt.Sprintf("Snippet #%d has been deleted.", id))
+	http.Redirect(w, r, "/admin/snippets", http.StatusSeeOther)
+}
+
+func (app *application) adminAudit(w http.ResponseWriter, r *http.Request) {
+	page, err := pageFromQuery(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	events, err := app.auditEvents.List(page)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	td := &templateData{AuditEvents: events}
+	if page > 1 {
+		td.PrevPage = page - 1
+	}
+	if len(events) == models.AuditEventsPerPage {
+		td.NextPage = page + 1
+	}
+
+	app.render(w, r, "adminaudit.page.tmpl", td)
+}
+
+// Download the whole audit log as CSV, newest first
+func (app *application) adminAuditCSV(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+
+	// Once the first row is written the status has been sent, so a
+	// failure after that can only be logged.
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "created", "user_id", "action", "target", "ip", "user_agent"})
+	// Walk back by ID rather than by page, because events keep being added
+	// while this runs and every one would shift the pages along by a row
+	for before := 0; ; {
+		events, err := app.auditEvents.Before(before)
+		if err != nil {
+			app.errorLog.Print(err)
+			return
+		}
+		for _, e := range events {
+			cw.Write([]string{
+				strconv.Itoa(e.ID),
+				e.Created.UTC().Format(time.RFC3339),
+				strconv.Itoa(e.UserID),
+				csvSafe(e.Action),
+				csvSafe(e.Target),
+				csvSafe(e.IP),
+				csvSafe(e.UserAgent),
+			})
+		}
+		if len(events) < models.AuditEventsPerPage {
+			break
+		}
+		before = events[len(events)-1].ID
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		app.errorLog.Print(err)
+	}
+}
+
+// Spreadsheets treat a cell that starts with one of these as a formula, and
+// things like the user agent are chosen by whoever made the request. A
+// leading quote makes the spreadsheet show it as text instead.
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"dvhthomas/snippetbox/pkg/models/mock"
+	"encoding/csv"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// The action and target of each audited event, oldest first
+func auditedActions(audit *mock.AuditModel) []string {
+	actions := []string{}
+	for _, e := range audit.Events {
+		actions = append(actions, e.Action+" "+e.Target)
+	}
+	return actions
+}
+
+func TestAdminAccess(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Anybody who isn't logged in is sent to do so
+	code, headers, _ := ts.get(t, "/admin/users")
+	if code != http.StatusSeeOther || headers.Get("Location") != "/user/login" {
+		t.Errorf("want %d to /user/login; got %d to %q", http.StatusSeeOther, code, headers.Get("Location"))
+	}
+
+	// Plain users aren't allowed in
+	ts.login(t)
+	for _, urlPath := range []string{"/admin/users", "/admin/snippets"} {
+		code, _, _ = ts.get(t, urlPath)
+		if code != http.StatusForbidden {
+			t.Errorf("%s: want %d; got %d", urlPath, http.StatusForbidden, code)
+		}
+	}
+	_, _, body := ts.get(t, "/")
+	if bytes.Contains(body, []byte("/admin/users")) {
+		t.Error("want no link to the admin console for a plain user")
+	}
+}
+
+func TestAdminPages(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.loginAs(t, "carol@example.com")
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Users", "/admin/users", http.StatusOK, []byte("alice@example.com")},
+		{"Snippets", "/admin/snippets", http.StatusOK, []byte("First autumn morning")},
+		{"Private snippets", "/admin/snippets", http.StatusOK, []byte("Bob&#39;s secret")},
+		{"Empty page", "/admin/snippets?page=2", http.StatusOK, []byte("nothing to see here")},
+		{"Bad page", "/admin/snippets?page=0", http.StatusBadRequest, nil},
+		{"Home links to the console", "/", http.StatusOK, []byte("href='/admin/users'")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAdminActions(t *testing.T) {
+	app := newTestApplication(t)
+	audit := app.auditEvents.(*mock.AuditModel)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.loginAs(t, "carol@example.com")
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		csrfToken    string
+		wantCode     int
+		wantLocation string
+		wantAudit    string
+	}{
+		{"Deactivate", "/admin/users/1/deactivate", csrfToken, http.StatusSeeOther, "/admin/users", "user.deactivate user:1"},
+		{"Reactivate", "/admin/users/1/reactivate", csrfToken, http.StatusSeeOther, "/admin/users", "user.reactivate user:1"},
+		{"Deactivate yourself", "/admin/users/3/deactivate", csrfToken, http.StatusSeeOther, "/admin/users", ""},
+		{"Non-existent user", "/admin/users/99/deactivate", csrfToken, http.StatusNotFound, "", ""},
+		{"Invalid user ID", "/admin/users/foo/deactivate", csrfToken, http.StatusNotFound, "", ""},
+		{"Delete someone's private snippet", "/admin/snippets/5/delete", csrfToken, http.StatusSeeOther, "/admin/snippets", "snippet.delete snippet:5"},
+		{"Non-existent snippet", "/admin/snippets/2/delete", csrfToken, http.StatusNotFound, "", ""},
+		{"Invalid CSRF token", "/admin/snippets/1/delete", "wrongToken", http.StatusBadRequest, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audit.Events = nil
+
+			form := url.Values{}
+			form.Add("csrf_token", tt.csrfToken)
+			code, headers, _ := ts.postForm(t, tt.urlPath, form)
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if loc := headers.Get("Location"); loc != tt.wantLocation {
+				t.Errorf("want Location %q; got %q", tt.wantLocation, loc)
+			}
+			// Only the things that actually happened are audited, and
+			// always as the admin who did them
+			got := auditedActions(audit)
+			if tt.wantAudit == "" && len(got) > 0 {
+				t.Errorf("want nothing audited; got %q", got)
+			}
+			if tt.wantAudit != "" && (len(got) != 1 || got[0] != tt.wantAudit || audit.Events[0].UserID != 3) {
+				t.Errorf("want %q audited for user 3; got %q", tt.wantAudit, got)
+			}
+		})
+	}
+}
+
+func TestAdminAudit(t *testing.T) {
+	app := newTestApplication(t)
+	audit := app.auditEvents.(*mock.AuditModel)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Logging in is audited, as well as this attempt to sneak a formula
+	// into the CSV
+	audit.Insert(0, "user.login_failed", "=HYPERLINK(\"http://evil\")", "192.0.2.1", "-curl")
+	ts.loginAs(t, "carol@example.com")
+
+	code, _, body := ts.get(t, "/admin/audit")
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	if !bytes.Contains(body, []byte("user.login_failed")) || !bytes.Contains(body, []byte("user:3")) {
+		t.Errorf("want both events listed; got %s", body)
+	}
+
+	code, headers, body := ts.get(t, "/admin/audit.csv")
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	if ct := headers.Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("want a CSV content type; got %q", ct)
+	}
+	records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("want a header and 2 events; got %q", records)
+	}
+	// Newest first
+	if records[1][3] != "user.login" || records[1][2] != "3" {
+		t.Errorf("want the login first; got %q", records[1])
+	}
+	// Nothing that a spreadsheet would run as a formula
+	if records[2][4] != `'=HYPERLINK("http://evil")` {
+		t.Errorf("want the target defused; got %q", records[2][4])
+	}
+	if records[2][6] != "'-curl" {
+		t.Errorf("want the user agent defused; got %q", records[2][6])
+	}
+
+	code, _, _ = ts.get(t, "/admin/audit?page=0")
+	if code != http.StatusBadRequest {
+		t.Errorf("want %d; got %d", http.StatusBadRequest, code)
+	}
+}
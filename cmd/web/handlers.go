@@ -5,9 +5,12 @@ import (
 	"dvhthomas/snippetbox/pkg/models"
 	"errors"
 	"fmt"
-
+	"math"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 )
 
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
@@ -25,14 +28,86 @@ func (app *application) home(w http.ResponseWriter, r *http.Request) {
 
 }
 
+func (app *application) listSnippets(w http.ResponseWriter, r *http.Request) {
+	cursor, err := cursorFromQuery(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	s, prev, next, err := app.snippetPage(cursor)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "snippets.page.tmpl", &templateData{
+		Snippets:   s,
+		PrevCursor: prev,
+		NextCursor: next,
+	})
+}
+
+func (app *application) searchSnippets(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	page, err := pageFromQuery(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// No query just shows the search form
+	td := &templateData{Query: query}
+	if query == "" {
+		app.render(w, r, "search.page.tmpl", td)
+		return
+	}
+
+	s, err := app.snippets.Search(query, page)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	td.Snippets = s
+	if page > 1 {
+		td.PrevPage = page - 1
+	}
+	// A full page suggests there are more. It might be wrong if the results
+	// happen to end exactly on a page boundary, but that's harmless.
+	if len(s) == models.SearchResultsPerPage {
+		td.NextPage = page + 1
+	}
+
+	app.render(w, r, "search.page.tmpl", td)
+}
+
 func (app *application) showSnippet(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
-	if err != nil || id < 1 {
+	s, err := app.snippetFromURL(r)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// A 404 rather than a 403 for hidden snippets, so that nobody can
+	// find out which IDs belong to them.
+	if !app.canViewByID(r, s) {
 		app.notFound(w)
 		return
 	}
 
-	s, err := app.snippets.Get(id)
+	// Snippets live at their slug now, but links to the old numeric
+	// addresses are out there so send them on.
+	http.Redirect(w, r, snippetURL(s), http.StatusMovedPermanently)
+}
+
+func (app *application) showSnippetBySlug(w http.ResponseWriter, r *http.Request) {
+	s, err := app.snippets.GetBySlug(r.URL.Query().Get(":slug"))
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			app.notFound(w)
@@ -42,12 +117,16 @@ func (app *application) showSnippet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !app.canViewBySlug(r, s) {
+		app.notFound(w)
+		return
+	}
+
 	// If the flash data exists this will get the value and remove it,
 	// or return an empty string.
 	app.render(w, r, "show.page.tmpl", &templateData{
 		Snippet: s,
 	})
-
 }
 
 // Create a snippet page with a form. This could have pre-existing form
@@ -67,9 +146,7 @@ func (app *application) createSnippet(w http.ResponseWriter, r *http.Request) {
 	}
 
 	form := forms.New(r.PostForm)
-	form.Required("title", "content", "expires")
-	form.MaxLength("title", 100)
-	form.PermittedValues("expires", "365", "7", "1")
+	validateNewSnippet(form)
 
 	if !form.Valid() {
 		app.render(w, r, "create.page.tmpl", &templateData{
@@ -82,21 +159,131 @@ func (app *application) createSnippet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The requireAuthentication middleware guarantees there's a logged in
+	// user, so they become the author of the snippet.
 	id, err := app.snippets.Insert(
+		app.authenticatedUserID(r),
 		form.Get("title"),
 		form.Get("content"),
+		snippetLanguage(form),
+		form.Get("visibility"),
 		form.Get("expires"),
 	)
 
 	if err != nil {
 		app.serverError(w, err)
+		return
+	}
+	app.audit(r, app.authenticatedUserID(r), "snippet.create", fmt.Sprintf("snippet:%d", id))
+
+	// Read it back for the slug to redirect to, which the model has just
+	// made up. Every snippet is shown at its slug.
+	s, err := app.snippets.Get(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
 	}
 
 	// If there's no existing session for the user, the middleware will create
 	// the session cookie automatically and *then* put the data in there.
 	app.session.Put(r, "flash", "Snippet successfully created!")
 
-	http.Redirect(w, r, fmt.Sprintf("/snippet/%d", id), http.StatusSeeOther)
+	http.Redirect(w, r, snippetURL(s), http.StatusSeeOther)
+}
+
+// Fetch the snippet named by the :id in the URL, making sure that it belongs
+// to the current user. If it doesn't exist or isn't theirs then the
+// appropriate error has already been sent and ok will be false.
+func (app *application) ownedSnippet(w http.ResponseWriter, r *http.Request) (s *models.Snippet, ok bool) {
+	s, err := app.snippetFromURL(r)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return nil, false
+	}
+
+	if !app.isAuthor(r, s) {
+		// Don't even admit that a hidden snippet exists to anyone else
+		if s.Visibility != models.VisibilityPublic {
+			app.notFound(w)
+		} else {
+			app.clientError(w, http.StatusForbidden)
+		}
+		return nil, false
+	}
+	return s, true
+}
+
+func (app *application) editSnippetForm(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	// Pre-fill the form with the current values so there's something to edit
+	app.render(w, r, "edit.page.tmpl", &templateData{
+		Snippet: s,
+		Form: forms.New(url.Values{
+			"title":      []string{s.Title},
+			"content":    []string{s.Content},
+			"language":   []string{s.Language},
+			"visibility": []string{s.Visibility},
+		}),
+	})
+}
+
+func (app *application) editSnippet(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	validateSnippet(form)
+
+	if !form.Valid() {
+		app.render(w, r, "edit.page.tmpl", &templateData{
+			Snippet: s,
+			Form:    form,
+		})
+		return
+	}
+
+	err = app.snippets.Update(s.ID, form.Get("title"), form.Get("content"),
+		snippetLanguage(form), form.Get("visibility"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// The slug never changes, so it's still where it was
+	app.session.Put(r, "flash", "Snippet successfully updated!")
+	http.Redirect(w, r, snippetURL(s), http.StatusSeeOther)
+}
+
+func (app *application) deleteSnippet(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.snippets.Delete(s.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Snippet successfully deleted!")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
 func (app *application) signupUserForm(w http.ResponseWriter, r *http.Request) {
@@ -142,9 +329,22 @@ func (app *application) signupUser(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	// We don't know the new user's ID, but the email address is unique
+	app.audit(r, 0, "user.signup", "email:"+form.Get("email"))
+
+	// New users can't log in until they've shown that the address is theirs.
+	// If the email doesn't make it they can ask for another one.
+	u, err := app.users.GetByEmail(form.Get("email"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if err = app.sendVerification(u); err != nil {
+		app.errorLog.Printf("verification for user:%d: %s", u.ID, err)
+	}
 
 	// Otherwise we successfully created the user.
-	app.session.Put(r, "flash", "Your signup was successful Please log in.")
+	app.session.Put(r, "flash", "Your signup was successful. Please follow the link we've emailed you to verify your address, and then log in.")
 
 	// So redirect to login.
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
@@ -163,41 +363,180 @@ func (app *application) loginUser(w http.ResponseWriter, r *http.Request) {
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
+	form := forms.New(r.PostForm)
+	email := form.Get("email")
+	ip := app.clientIP(r)
+
+	// Don't even look at the password if this client or this email address
+	// has failed too often lately, otherwise guessing could carry on.
+	wait := app.loginThrottle.wait(ip)
+	if wait == 0 {
+		wait, err = app.accountLockedFor(email)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+	if wait > 0 {
+		app.renderLockedOut(w, r, "login.page.tmpl", form, wait)
+		return
+	}
 
 	// Check whether login credentials are valid. If not we'll send a generic
 	// error so a malicious user cannot learn much about the system.
-	form := forms.New(r.PostForm)
-	id, err := app.users.Authenticate(form.Get("email"), form.Get("password"))
+	id, err := app.users.Authenticate(email, form.Get("password"))
+	if errors.Is(err, models.ErrUnverified) {
+		// The password was right, so there's no harm in saying what's wrong
+		form.Errors.Add("unverified", "Please verify your email address before logging in.")
+		app.render(w, r, "login.page.tmpl", &templateData{
+			Form: form,
+		})
+		return
+	}
 	if err != nil {
-		if errors.Is(err, models.ErrInvalidCredentials) {
-			form.Errors.Add("generic", "Email or password is incorrect")
-			app.render(w, r, "login.page.tmpl", &templateData{
-				Form: form,
-			})
-		} else {
+		if !errors.Is(err, models.ErrInvalidCredentials) {
+			app.serverError(w, err)
+			return
+		}
+
+		app.audit(r, 0, "user.login_failed", "email:"+email)
+		app.loginThrottle.fail(ip)
+		locked, err := app.recordLoginFailure(email)
+		if err != nil {
 			app.serverError(w, err)
+			return
+		}
+		if locked {
+			app.audit(r, 0, "user.lockout", "email:"+email)
+			wait, err = app.accountLockedFor(email)
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+			app.renderLockedOut(w, r, "login.page.tmpl", form, wait)
+			return
 		}
+
+		form.Errors.Add("generic", "Email or password is incorrect")
+		app.render(w, r, "login.page.tmpl", &templateData{
+			Form: form,
+		})
+		return
+	}
+
+	u, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, err)
 		return
 	}
 
-	// Add the ID of the current user to the session, so that they are now
-	// 'logged in'
-	app.session.Put(r, "authenticatedUserID", id)
+	// Users with two-factor authentication still have to type in a code.
+	// Their failures aren't forgotten yet, so that somebody who knows the
+	// password can't get round the lockout for guessing codes.
+	if u.TOTPSecret != nil {
+		app.startTwoFactorLogin(w, r, u)
+		return
+	}
+	app.finishLogin(w, r, u)
+}
 
-	// Redirect the user to the create snippet page.
-	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+// Tell somebody that they've failed to log in too often, on whichever step of
+// logging in they were. It looks the same whether or not the email address
+// belongs to anybody.
+func (app *application) renderLockedOut(w http.ResponseWriter, r *http.Request, name string, form *forms.Form, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	app.renderStatus(w, r, http.StatusTooManyRequests, name, &templateData{
+		Form:       form,
+		RetryAfter: wait,
+	})
 }
 
 func (app *application) logoutUser(w http.ResponseWriter, r *http.Request) {
-	// Remove the authenticatedUserID from the session data so the
-	// user is 'logged out'.
-	app.session.Remove(r, "authenticatedUserID")
+	id := app.authenticatedUserID(r)
+	app.audit(r, id, "user.logout", fmt.Sprintf("user:%d", id))
+
+	// End the server-side session and forget its token so the user is
+	// 'logged out'. It might already have been ended from another browser.
+	err := app.userSessions.Delete(id, app.sessionID(r))
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, err)
+		return
+	}
+	app.session.Remove(r, "sessionToken")
 
 	// Flash a message to confirm to the user that they've been logged out
 	app.session.Put(r, "flash", "You've been logged out successfully!")
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+func (app *application) listTokens(w http.ResponseWriter, r *http.Request) {
+	app.renderTokens(w, r, forms.New(nil))
+}
+
+// The token list and the form for adding a new one share a page, so both
+// the GET and a failed POST render it this way.
+func (app *application) renderTokens(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	tokens, err := app.tokens.List(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "tokens.page.tmpl", &templateData{
+		APITokens: tokens,
+		Form:      form,
+		// Put there by createToken, and gone for good once it's been shown
+		NewAPIToken: app.session.PopString(r, "newAPIToken"),
+	})
+}
+
+func (app *application) createToken(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name")
+	form.MaxLength("name", 100)
+
+	if !form.Valid() {
+		app.renderTokens(w, r, form)
+		return
+	}
+
+	token, err := app.tokens.Insert(app.authenticatedUserID(r), form.Get("name"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "newAPIToken", token)
+	http.Redirect(w, r, "/user/tokens", http.StatusSeeOther)
+}
+
+func (app *application) revokeToken(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	err = app.tokens.Delete(app.authenticatedUserID(r), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.session.Put(r, "flash", "API token revoked.")
+	http.Redirect(w, r, "/user/tokens", http.StatusSeeOther)
+}
+
 func ping(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
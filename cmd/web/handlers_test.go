@@ -2,9 +2,13 @@ package main
 
 import (
 	"bytes"
+	"dvhthomas/snippetbox/pkg/models"
+	"dvhthomas/snippetbox/pkg/models/mock"
 	"net/http"
 	"net/url"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestPing(t *testing.T) {
@@ -36,32 +40,69 @@ func TestShowSnippet(t *testing.T) {
 	defer ts.Close()
 
 	tests := []struct {
-		name     string
-		urlPath  string
-		wantCode int
-		wantBody []byte
+		name         string
+		urlPath      string
+		wantCode     int
+		wantLocation string
+		wantBody     []byte
 	}{
-		{"Valid ID", "/snippet/1", http.StatusOK, []byte("And old silent pond...")},
-		{"Non-existent ID", "/snippet/2", http.StatusNotFound, nil},
-		{"Negative ID", "/snippet/-1", http.StatusNotFound, nil},
-		{"Decimal ID", "/snippet/1.23", http.StatusNotFound, nil},
-		{"String ID", "/snippet/foo", http.StatusNotFound, nil},
-		{"Empty ID", "/snippet/", http.StatusNotFound, nil},
-		{"Trailing slash", "/snippet/1/", http.StatusNotFound, nil},
+		{"Valid slug", "/s/s1LentP0ndAa", http.StatusOK, "", []byte("And old silent pond...")},
+		{"Author name", "/s/s1LentP0ndAa", http.StatusOK, "", []byte("By: Alice")},
+		{"Language", "/s/w1ntryF0rest", http.StatusOK, "", []byte("Language: Go")},
+		{"Unlisted", "/s/uNl1sTeDsLuG", http.StatusOK, "", []byte("First autumn morning...")},
+		{"Private", "/s/b0bsS3cretXx", http.StatusNotFound, "", nil},
+		{"Unknown slug", "/s/nOtArEaLsLuG", http.StatusNotFound, "", nil},
+		{"Empty slug", "/s/", http.StatusNotFound, "", nil},
+		{"Old ID link", "/snippet/1", http.StatusMovedPermanently, "/s/s1LentP0ndAa", nil},
+		{"Non-existent ID", "/snippet/2", http.StatusNotFound, "", nil},
+		{"Negative ID", "/snippet/-1", http.StatusNotFound, "", nil},
+		{"Decimal ID", "/snippet/1.23", http.StatusNotFound, "", nil},
+		{"String ID", "/snippet/foo", http.StatusNotFound, "", nil},
+		{"Empty ID", "/snippet/", http.StatusNotFound, "", nil},
+		{"Trailing slash", "/snippet/1/", http.StatusNotFound, "", nil},
+		{"Unlisted by ID", "/snippet/4", http.StatusNotFound, "", nil},
+		{"Private by ID", "/snippet/5", http.StatusNotFound, "", nil},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			code, _, body := ts.get(t, tt.urlPath)
+			code, headers, body := ts.get(t, tt.urlPath)
 
 			if code != tt.wantCode {
 				t.Errorf("want %d; got %d", tt.wantCode, code)
 			}
+			if loc := headers.Get("Location"); loc != tt.wantLocation {
+				t.Errorf("want Location %q; got %q", tt.wantLocation, loc)
+			}
 			if !bytes.Contains(body, tt.wantBody) {
 				t.Errorf("want body to contain %q", tt.wantBody)
 			}
 		})
 	}
+
+	// Private snippets are only for their author, and authors can see their
+	// own hidden snippets by ID as well.
+	ts.login(t)
+	authorTests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+	}{
+		{"Own private snippet", "/s/al1cesS3cret", http.StatusOK},
+		{"Own private snippet by ID", "/snippet/6", http.StatusMovedPermanently},
+		{"Someone else's private snippet", "/s/b0bsS3cretXx", http.StatusNotFound},
+		{"Someone else's private snippet by ID", "/snippet/5", http.StatusNotFound},
+		{"Someone else's unlisted snippet by ID", "/snippet/4", http.StatusNotFound},
+	}
+
+	for _, tt := range authorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, _ := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+		})
+	}
 }
 
 func TestSignupUser(t *testing.T) {
@@ -114,3 +155,348 @@ func TestSignupUser(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateSnippet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		code, headers, _ := ts.get(t, "/snippet/create")
+		if code != http.StatusSeeOther {
+			t.Errorf("want %d; got %d", http.StatusSeeOther, code)
+		}
+		if loc := headers.Get("Location"); loc != "/user/login" {
+			t.Errorf("want Location %q; got %q", "/user/login", loc)
+		}
+	})
+
+	csrfToken := ts.login(t)
+
+	tests := []struct {
+		name         string
+		title        string
+		content      string
+		language     string
+		visibility   string
+		expires      string
+		wantCode     int
+		wantLocation string
+		wantBody     []byte
+	}{
+		{"Valid submission", "Title", "Content", "go", "public", "7", http.StatusSeeOther, "/s/n3wSn1pp3tSl", nil},
+		{"Auto-detected language", "Title", "Content", "", "public", "7", http.StatusSeeOther, "/s/n3wSn1pp3tSl", nil},
+		{"Unlisted", "Title", "Content", "", "unlisted", "7", http.StatusSeeOther, "/s/n3wSn1pp3tSl", nil},
+		{"Private", "Title", "Content", "", "private", "7", http.StatusSeeOther, "/s/n3wSn1pp3tSl", nil},
+		{"Empty title", "", "Content", "", "public", "7", http.StatusOK, "", []byte("This field cannot be blank")},
+		{"Invalid expiry", "Title", "Content", "", "public", "30", http.StatusOK, "", []byte("The field is invalid")},
+		{"Invalid language", "Title", "Content", "klingon", "public", "7", http.StatusOK, "", []byte("The field is invalid")},
+		{"Invalid visibility", "Title", "Content", "", "secret", "7", http.StatusOK, "", []byte("The field is invalid")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", tt.title)
+			form.Add("content", tt.content)
+			form.Add("language", tt.language)
+			form.Add("visibility", tt.visibility)
+			form.Add("expires", tt.expires)
+			form.Add("csrf_token", csrfToken)
+
+			code, headers, body := ts.postForm(t, "/snippet/create", form)
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if loc := headers.Get("Location"); loc != tt.wantLocation {
+				t.Errorf("want Location %q; got %q", tt.wantLocation, loc)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body %s to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestEditSnippet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t)
+
+	t.Run("Form is pre-filled", func(t *testing.T) {
+		code, _, body := ts.get(t, "/snippet/1/edit")
+		if code != http.StatusOK {
+			t.Errorf("want %d; got %d", http.StatusOK, code)
+		}
+		want := []byte("An old silent pond")
+		if !bytes.Contains(body, want) {
+			t.Errorf("want body to contain %q", want)
+		}
+	})
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		title    string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Own snippet", "/snippet/1/edit", "New title", http.StatusSeeOther, nil},
+		{"Someone else's snippet", "/snippet/3/edit", "New title", http.StatusForbidden, nil},
+		{"Someone else's private snippet", "/snippet/5/edit", "New title", http.StatusNotFound, nil},
+		{"Non-existent snippet", "/snippet/2/edit", "New title", http.StatusNotFound, nil},
+		{"Empty title", "/snippet/1/edit", "", http.StatusOK, []byte("This field cannot be blank")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", tt.title)
+			form.Add("content", "Content")
+			form.Add("visibility", "public")
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, tt.urlPath, form)
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body %s to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestDeleteSnippet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t)
+
+	tests := []struct {
+		name      string
+		urlPath   string
+		csrfToken string
+		wantCode  int
+	}{
+		{"Own snippet", "/snippet/1/delete", csrfToken, http.StatusSeeOther},
+		{"Someone else's snippet", "/snippet/3/delete", csrfToken, http.StatusForbidden},
+		{"Non-existent snippet", "/snippet/2/delete", csrfToken, http.StatusNotFound},
+		{"Invalid CSRF token", "/snippet/1/delete", "wrongToken", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", tt.csrfToken)
+
+			code, _, _ := ts.postForm(t, tt.urlPath, form)
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+		})
+	}
+}
+
+func TestListSnippets(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The mock snippets were created now and an hour ago, so a cursor
+	// between the two splits them across pages.
+	between := encodeCursor(&models.Snippet{ID: 2, Created: time.Now().Add(-30 * time.Minute)})
+
+	tests := []struct {
+		name        string
+		urlPath     string
+		wantCode    int
+		wantBody    []byte
+		wantNotBody []byte
+	}{
+		{"First page", "/snippets", http.StatusOK, []byte("Over the wintry forest"), nil},
+		{"After a cursor", "/snippets?after=" + between, http.StatusOK, []byte("Over the wintry forest"), []byte("An old silent pond")},
+		{"Before a cursor", "/snippets?before=" + between, http.StatusOK, []byte("An old silent pond"), []byte("Over the wintry forest")},
+		{"Malformed cursor", "/snippets?after=bogus", http.StatusBadRequest, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+			if tt.wantNotBody != nil && bytes.Contains(body, tt.wantNotBody) {
+				t.Errorf("want body not to contain %q", tt.wantNotBody)
+			}
+		})
+	}
+}
+
+func TestSearchSnippets(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name        string
+		urlPath     string
+		wantCode    int
+		wantBody    []byte
+		wantNotBody []byte
+	}{
+		{"No query", "/search", http.StatusOK, []byte("Search snippets"), []byte("No snippets match")},
+		{"Matching query", "/search?q=POND", http.StatusOK, []byte("silent <mark>pond</mark>"), []byte("Over the wintry forest")},
+		{"No matches", "/search?q=nginx", http.StatusOK, []byte("No snippets match"), nil},
+		{"Past the last page", "/search?q=pond&page=2", http.StatusOK, []byte("No snippets match"), nil},
+		{"Invalid page", "/search?q=pond&page=-1", http.StatusBadRequest, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+			if tt.wantNotBody != nil && bytes.Contains(body, tt.wantNotBody) {
+				t.Errorf("want body not to contain %q", tt.wantNotBody)
+			}
+		})
+	}
+}
+
+func TestTokens(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, _ := ts.get(t, "/user/tokens")
+	if code != http.StatusSeeOther {
+		t.Errorf("want %d for an anonymous user; got %d", http.StatusSeeOther, code)
+	}
+
+	csrfToken := ts.login(t)
+
+	code, _, body := ts.get(t, "/user/tokens")
+	if code != http.StatusOK {
+		t.Errorf("want %d; got %d", http.StatusOK, code)
+	}
+	if !bytes.Contains(body, []byte("Deploy script")) {
+		t.Errorf("want body to list the existing token")
+	}
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		token    string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Create without a name", "/user/tokens", "", http.StatusOK, []byte("This field cannot be blank")},
+		{"Create", "/user/tokens", "CI", http.StatusSeeOther, nil},
+		{"Revoke", "/user/tokens/1/revoke", "", http.StatusSeeOther, nil},
+		{"Revoke unknown token", "/user/tokens/2/revoke", "", http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("name", tt.token)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, tt.urlPath, form)
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body %s to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+
+	// The token created above is shown exactly once
+	_, _, body = ts.get(t, "/user/tokens")
+	if !bytes.Contains(body, []byte("new-token")) {
+		t.Errorf("want body to show the new token")
+	}
+	_, _, body = ts.get(t, "/user/tokens")
+	if bytes.Contains(body, []byte("new-token")) {
+		t.Errorf("want the new token to be shown only once")
+	}
+}
+
+func TestAuditedEvents(t *testing.T) {
+	app := newTestApplication(t)
+	audit := app.auditEvents.(*mock.AuditModel)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/signup")
+	csrfToken := extractCSRFToken(t, body)
+
+	form := url.Values{}
+	form.Add("name", "Bob")
+	form.Add("email", "bob@example.com")
+	form.Add("password", "validPa$$word")
+	form.Add("csrf_token", csrfToken)
+	ts.postForm(t, "/user/signup", form)
+
+	// The mock doesn't know Mallory, so this login fails
+	form = url.Values{}
+	form.Add("email", "mallory@example.com")
+	form.Add("password", "validPa$$word")
+	form.Add("csrf_token", csrfToken)
+	ts.postForm(t, "/user/login", form)
+
+	ts.login(t)
+
+	form = url.Values{}
+	form.Add("title", "Title")
+	form.Add("content", "Content")
+	form.Add("visibility", "public")
+	form.Add("expires", "7")
+	form.Add("csrf_token", csrfToken)
+	ts.postForm(t, "/snippet/create", form)
+
+	form = url.Values{}
+	form.Add("csrf_token", csrfToken)
+	ts.postForm(t, "/user/logout", form)
+
+	want := []string{
+		"user.signup email:bob@example.com",
+		"user.login_failed email:mallory@example.com",
+		"user.login user:1",
+		"snippet.create snippet:2",
+		"user.logout user:1",
+	}
+	got := auditedActions(audit)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %q; got %q", want, got)
+	}
+
+	// Who did it is only known once they've logged in
+	wantUsers := []int{0, 0, 1, 1, 1}
+	for i, e := range audit.Events {
+		if e.UserID != wantUsers[i] {
+			t.Errorf("%s: want user %d; got %d", e.Action, wantUsers[i], e.UserID)
+		}
+		if e.IP != "127.0.0.1" || e.UserAgent == "" {
+			t.Errorf("%s: want the client's IP and user agent; got %q and %q", e.Action, e.IP, e.UserAgent)
+		}
+	}
+}
@@ -2,10 +2,18 @@ package main
 
 import (
 	"bytes"
+	"dvhthomas/snippetbox/pkg/forms"
+	"dvhthomas/snippetbox/pkg/models"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"runtime/debug"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/justinas/nosurf"
 )
@@ -31,7 +39,62 @@ func (app *application) notFound(w http.ResponseWriter) {
 	app.clientError(w, http.StatusNotFound)
 }
 
+// Record in the audit log that userID did action to target, like
+// "user.deactivate" to "user:7". userID is zero if nobody is logged in. The
+// request has already happened by now, so a failure to record it is only
+// logged rather than reported to the user.
+func (app *application) audit(r *http.Request, userID int, action, target string) {
+	err := app.auditEvents.Insert(userID, action, truncate(target, 255), app.clientIP(r), truncate(r.UserAgent(), 255))
+	if err != nil {
+		app.errorLog.Printf("audit %s %s by user:%d: %s", action, target, userID, err)
+	}
+}
+
+// The address of the client without the port. When the request came through
+// one of our trusted proxies, it's the address the proxies say they got it
+// from instead.
+func (app *application) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !app.isTrustedProxy(ip) {
+		return ip
+	}
+
+	// Every proxy adds the address it got the request from to the end of
+	// X-Forwarded-For, so working back from the end, the first address that
+	// isn't one of ours is the client. Anything before that came from the
+	// client and could say anything.
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !app.isTrustedProxy(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// Cut s down to at most n characters so that it fits in its column
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
 func (app *application) render(w http.ResponseWriter, r *http.Request, name string, td *templateData) {
+	app.renderStatus(w, r, http.StatusOK, name, td)
+}
+
+// Render a page with a status other than 200 OK, such as when it explains
+// what went wrong
+func (app *application) renderStatus(w http.ResponseWriter, r *http.Request, status int, name string, td *templateData) {
 	// Retrieve the appropriate template set from the cache based on the page name
 	// (like 'home.page.tmpl'). If no entry exists in the cache with the provided
 	// name, call the serverError helper.
@@ -51,6 +114,7 @@ func (app *application) render(w http.ResponseWriter, r *http.Request, name stri
 		return
 	}
 
+	w.WriteHeader(status)
 	buf.WriteTo(w)
 }
 
@@ -66,6 +130,11 @@ func (app *application) addDefaultData(td *templateData, r *http.Request) *templ
 
 	// Add authenticated status to the template data
 	td.IsAuthenticated = app.isAuthenticated(r)
+	td.AuthenticatedUserID = app.authenticatedUserID(r)
+	td.IsAdmin = app.authenticatedUserRole(r) == models.RoleAdmin
+	if app.oidc != nil {
+		td.OIDCName = app.oidcName
+	}
 	return td
 }
 
@@ -82,3 +151,190 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 	}
 	return isAuthenticated
 }
+
+// Return the ID of the user making the current request, or zero if there
+// isn't an authenticated user. This works the same whether they logged in
+// with a session or an API token.
+func (app *application) authenticatedUserID(r *http.Request) int {
+	id, ok := r.Context().Value(contextKeyAuthenticatedUserID).(int)
+	if !ok {
+		return 0
+	}
+	return id
+}
+
+// Return the role of the user making the current request, or an empty string
+// if there isn't a user who logged in with a session
+func (app *application) authenticatedUserRole(r *http.Request) string {
+	role, _ := r.Context().Value(contextKeyAuthenticatedUserRole).(string)
+	return role
+}
+
+// Return true if the current request was authenticated with an API token
+func isTokenAuthenticated(r *http.Request) bool {
+	ok, _ := r.Context().Value(contextKeyIsTokenAuthenticated).(bool)
+	return ok
+}
+
+// Return the ID of the server-side session the current request belongs to,
+// or zero if it didn't come from a logged in browser
+func (app *application) sessionID(r *http.Request) int {
+	id, _ := r.Context().Value(contextKeySessionID).(int)
+	return id
+}
+
+// Read the page number from the query string, which defaults to the first
+// page. Anything that isn't a positive number is an error.
+func pageFromQuery(r *http.Request) (int, error) {
+	p := r.URL.Query().Get("page")
+	if p == "" {
+		return 1, nil
+	}
+	page, err := strconv.Atoi(p)
+	if err != nil || page < 1 {
+		return 0, fmt.Errorf("invalid page %q", p)
+	}
+	return page, nil
+}
+
+// Cursors end up in URLs, so we encode the creation time and ID of a snippet
+// into an opaque URL-safe string rather than exposing the raw values.
+func encodeCursor(s *models.Snippet) string {
+	raw := fmt.Sprintf("%d.%d", s.Created.UnixNano(), s.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// The reverse of encodeCursor. Anything that doesn't decode cleanly is an
+// error because it was either mangled or made up.
+func decodeCursor(token string, before bool) (*models.Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(string(raw), ".")
+	if len(parts) != 2 {
+		return nil, errors.New("malformed cursor")
+	}
+
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil || id < 1 {
+		return nil, errors.New("malformed cursor")
+	}
+
+	return &models.Cursor{
+		Created: time.Unix(0, nanos).UTC(),
+		ID:      id,
+		Before:  before,
+	}, nil
+}
+
+// How many snippets to show on each page of the archive
+const snippetsPerPage = 10
+
+// Read the archive position from the query string. At most one of 'after' or
+// 'before' is set, and no cursor at all means we start from the newest snippet.
+func cursorFromQuery(r *http.Request) (*models.Cursor, error) {
+	if token := r.URL.Query().Get("after"); token != "" {
+		return decodeCursor(token, false)
+	}
+	if token := r.URL.Query().Get("before"); token != "" {
+		return decodeCursor(token, true)
+	}
+	return nil, nil
+}
+
+// Fetch a page of the archive from the position marked by cursor, along with
+// the cursors for the neighbouring pages. Those are empty if there's no page
+// in that direction.
+func (app *application) snippetPage(cursor *models.Cursor) (s []*models.Snippet, prev, next string, err error) {
+	// Ask for one more than we need. If we get it then we know there's
+	// another page in the direction we're heading.
+	s, err = app.snippets.List(cursor, snippetsPerPage+1)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	more := len(s) > snippetsPerPage
+	backwards := cursor != nil && cursor.Before
+	if more {
+		// The extra snippet is always the one furthest from the cursor
+		if backwards {
+			s = s[1:]
+		} else {
+			s = s[:snippetsPerPage]
+		}
+	}
+
+	if len(s) > 0 {
+		// Going forwards there's a previous page unless we started at the top,
+		// and going backwards we must have come from a next page.
+		if (backwards && more) || (!backwards && cursor != nil) {
+			prev = encodeCursor(s[0])
+		}
+		if (!backwards && more) || backwards {
+			next = encodeCursor(s[len(s)-1])
+		}
+	}
+	return s, prev, next, nil
+}
+
+// Fetch the snippet named by the :id in the URL. An ID that isn't a positive
+// number can't match anything, so that's models.ErrNoRecord too.
+func (app *application) snippetFromURL(r *http.Request) (*models.Snippet, error) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		return nil, models.ErrNoRecord
+	}
+	return app.snippets.Get(id)
+}
+
+// Report whether the current user may see a snippet they asked for by its
+// numeric ID. Unlisted snippets have to be asked for by slug and private ones
+// are only for their author, although authors can always see their own.
+func (app *application) canViewByID(r *http.Request, s *models.Snippet) bool {
+	return s.Visibility == models.VisibilityPublic || app.isAuthor(r, s)
+}
+
+// Report whether the current user may see a snippet they asked for by slug.
+// Knowing the slug is enough for anything that isn't private.
+func (app *application) canViewBySlug(r *http.Request, s *models.Snippet) bool {
+	return s.Visibility != models.VisibilityPrivate || app.isAuthor(r, s)
+}
+
+// The address of a snippet. We always link by slug so that snippets can't
+// be found by counting up from 1.
+func snippetURL(s *models.Snippet) string {
+	return "/s/" + s.Slug
+}
+
+// Report whether the current user wrote the snippet. Snippets created before
+// we recorded authors have an AuthorID of zero, and that never matches a real
+// user, so nobody can change those.
+func (app *application) isAuthor(r *http.Request, s *models.Snippet) bool {
+	id := app.authenticatedUserID(r)
+	return id != 0 && s.AuthorID == id
+}
+
+// The rules for the title, content, language and visibility of a snippet are
+// shared by the HTML forms and the JSON API. An empty language means
+// auto-detect.
+func validateSnippet(form *forms.Form) {
+	form.Required("title", "content")
+	form.MaxLength("title", 100)
+	form.PermittedValues("language", languageValues()...)
+	form.Required("visibility")
+	form.PermittedValues("visibility",
+		models.VisibilityPublic, models.VisibilityUnlisted, models.VisibilityPrivate)
+}
+
+// New snippets also need to say when they expire
+func validateNewSnippet(form *forms.Form) {
+	validateSnippet(form)
+	form.Required("expires")
+	form.PermittedValues("expires", "365", "7", "1")
+}
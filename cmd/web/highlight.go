@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"dvhthomas/snippetbox/pkg/forms"
+	"html/template"
+	"strings"
+
+	"github.com/alecthomas/chroma"
+	"github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+)
+
+// A language offered in the picker on the create and edit forms. The values
+// are the names chroma knows its lexers by.
+type language struct {
+	Value string
+	Label string
+}
+
+var languages = []language{
+	{"bash", "Bash"},
+	{"c", "C"},
+	{"css", "CSS"},
+	{"docker", "Dockerfile"},
+	{"go", "Go"},
+	{"html", "HTML"},
+	{"java", "Java"},
+	{"javascript", "JavaScript"},
+	{"json", "JSON"},
+	{"markdown", "Markdown"},
+	{"nginx", "Nginx"},
+	{"python", "Python"},
+	{"ruby", "Ruby"},
+	{"rust", "Rust"},
+	{"sql", "SQL"},
+	{"typescript", "TypeScript"},
+	{"yaml", "YAML"},
+	{"plaintext", "Plain text"},
+}
+
+// The values above as a list, for use with forms.PermittedValues
+func languageValues() []string {
+	values := make([]string, len(languages))
+	for i, l := range languages {
+		values[i] = l.Value
+	}
+	return values
+}
+
+// Guess the language of some code when the author didn't pick one. chroma
+// only has analysers for some languages so this often comes up empty, which
+// means plain text.
+func detectLanguage(content string) string {
+	lexer := lexers.Analyse(content)
+	if lexer == nil {
+		return ""
+	}
+
+	// The first alias is the short name we use in the picker, like 'go'
+	config := lexer.Config()
+	if len(config.Aliases) > 0 {
+		return config.Aliases[0]
+	}
+	return strings.ToLower(config.Name)
+}
+
+// The language picked on a snippet form, or our best guess if it was left on
+// auto-detect
+func snippetLanguage(form *forms.Form) string {
+	if language := form.Get("language"); language != "" {
+		return language
+	}
+	return detectLanguage(form.Get("content"))
+}
+
+// The display name of a language, like 'JavaScript' for 'javascript'
+func languageName(language string) string {
+	if language == "" {
+		return "Plain text"
+	}
+	for _, l := range languages {
+		if l.Value == language {
+			return l.Label
+		}
+	}
+	// It was detected rather than picked, so ask chroma
+	if lexer := lexers.Get(language); lexer != nil {
+		return lexer.Config().Name
+	}
+	return language
+}
+
+// Inline styles rather than CSS classes so that there's no stylesheet to keep
+// in sync, and nothing is fetched from a CDN. Our own <pre><code> wraps the
+// output so chroma mustn't add another.
+var codeFormatter = html.New(html.PreventSurroundingPre(true), html.TabWidth(4))
+var codeStyle = styles.Get("github")
+
+// Highlight code in the given language on the server. If anything goes wrong
+// we fall back to the plain, escaped code since that's still perfectly
+// readable.
+func highlightCode(content, language string) template.HTML {
+	lexer := lexers.Get(language)
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	// Merge runs of tokens of the same type so there are fewer <span>s
+	lexer = chroma.Coalesce(lexer)
+
+	plain := template.HTML(template.HTMLEscapeString(content))
+
+	iterator, err := lexer.Tokenise(nil, content)
+	if err != nil {
+		return plain
+	}
+
+	var buf bytes.Buffer
+	if err := codeFormatter.Format(&buf, codeStyle, iterator); err != nil {
+		return plain
+	}
+	return template.HTML(buf.String())
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"Shebang", "#!/bin/bash\necho hello", "bash"},
+		{"Go", "package main\n\nfunc main() {}\n", "go"},
+		{"Prose", "An old silent pond...", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := detectLanguage(tt.content)
+			if got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHighlightCode(t *testing.T) {
+	t.Run("Known language", func(t *testing.T) {
+		got := string(highlightCode("package main", "go"))
+		if !strings.Contains(got, "<span") {
+			t.Errorf("want highlighted spans; got %q", got)
+		}
+		if strings.Contains(got, "<pre") {
+			t.Errorf("want no surrounding <pre>; got %q", got)
+		}
+	})
+
+	t.Run("Escapes plain text", func(t *testing.T) {
+		got := string(highlightCode("<script>alert(1)</script>", ""))
+		if strings.Contains(got, "<script>") {
+			t.Errorf("want content escaped; got %q", got)
+		}
+		if !strings.Contains(got, "&lt;script&gt;") {
+			t.Errorf("want escaped content; got %q", got)
+		}
+	})
+}
+
+func TestLanguageName(t *testing.T) {
+	tests := []struct {
+		language string
+		want     string
+	}{
+		{"", "Plain text"},
+		{"javascript", "JavaScript"},
+		// Detected rather than picked, so chroma names it
+		{"php", "PHP"},
+		{"klingon", "klingon"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.language, func(t *testing.T) {
+			got := languageName(tt.language)
+			if got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Guessing passwords is slowed down in two ways. Each email address gets a
+// few free failures and is then locked out for longer and longer, which is
+// recorded in the database so that it survives a restart. Each client IP
+// address gets a few more free failures, since offices share addresses, and
+// is then made to wait longer and longer between attempts, which is only
+// kept in memory.
+const (
+	// Failed logins allowed for an email address before it's locked out
+	accountFreeFailures = 5
+	// How long the first lockout lasts. Each failure after that doubles it.
+	accountLockout    = 15 * time.Minute
+	maxAccountLockout = 24 * time.Hour
+	// Failures older than this are forgotten
+	failureWindow = 24 * time.Hour
+
+	// Failed logins allowed from an IP address before it has to wait
+	ipFreeFailures = 20
+	// How long the first wait is. Each failure after that doubles it.
+	ipBackoff    = time.Second
+	maxIPBackoff = 15 * time.Minute
+)
+
+// How long to wait after the failures'th failure, given that the first free
+// failures don't count. It's zero until then, and then base doubling every
+// time up to max.
+func backoff(failures, free int, base, max time.Duration) time.Duration {
+	if failures < free {
+		return 0
+	}
+	d := base
+	for i := free; i < failures && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// Email addresses are compared without regard to case by every backend, so
+// without this an attacker could get a fresh set of free failures from
+// ALICE@ and Alice@.
+func failureKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Report how much longer logging in as email is locked out for, which is
+// zero if it isn't. Failures are recorded for addresses that don't belong to
+// anybody too, so this doesn't give away who has an account.
+func (app *application) accountLockedFor(email string) (time.Duration, error) {
+	failures, last, err := app.users.LoginFailures(failureKey(email))
+	if err != nil {
+		return 0, err
+	}
+	if time.Since(last) > failureWindow {
+		return 0, nil
+	}
+	until := last.Add(backoff(failures, accountFreeFailures, accountLockout, maxAccountLockout))
+	if d := time.Until(until); d > 0 {
+		return d, nil
+	}
+	return 0, nil
+}
+
+// Count a failed login as email and report whether that locked it out
+func (app *application) recordLoginFailure(email string) (locked bool, err error) {
+	key := failureKey(email)
+	_, last, err := app.users.LoginFailures(key)
+	if err != nil {
+		return false, err
+	}
+	// Start counting again if it's been a while
+	if !last.IsZero() && time.Since(last) > failureWindow {
+		if err = app.users.ResetLoginFailures(key); err != nil {
+			return false, err
+		}
+	}
+	if err = app.users.RecordLoginFailure(key); err != nil {
+		return false, err
+	}
+
+	wait, err := app.accountLockedFor(email)
+	return wait > 0, err
+}
+
+// throttle makes IP addresses that keep failing to log in wait longer and
+// longer between attempts. It only remembers addresses for failureWindow, so
+// it can't grow without limit.
+type throttle struct {
+	mu       sync.Mutex
+	failures map[string]*ipFailures
+	// The time now, which the tests can change
+	now func() time.Time
+	// When the old entries were last thrown away
+	swept time.Time
+}
+
+type ipFailures struct {
+	count int
+	last  time.Time
+}
+
+func newThrottle() *throttle {
+	return &throttle{failures: map[string]*ipFailures{}, now: time.Now}
+}
+
+// How much longer ip has to wait before trying again, which is zero if it
+// doesn't
+func (t *throttle) wait(ip string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f, ok := t.failures[ip]
+	if !ok {
+		return 0
+	}
+	until := f.last.Add(backoff(f.count, ipFreeFailures, ipBackoff, maxIPBackoff))
+	if d := until.Sub(t.now()); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// Count another failed login from ip
+func (t *throttle) fail(ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	t.sweep(now)
+
+	f, ok := t.failures[ip]
+	if !ok || now.Sub(f.last) > failureWindow {
+		f = &ipFailures{}
+		t.failures[ip] = f
+	}
+	f.count++
+	f.last = now
+}
+
+// Throw away the addresses that haven't failed for a while, at most once a
+// minute. The caller must hold the lock.
+func (t *throttle) sweep(now time.Time) {
+	if now.Sub(t.swept) < time.Minute {
+		return
+	}
+	for ip, f := range t.failures {
+		if now.Sub(f.last) > failureWindow {
+			delete(t.failures, ip)
+		}
+	}
+	t.swept = now
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"dvhthomas/snippetbox/pkg/models/mock"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		want     time.Duration
+	}{
+		{"None", 0, 0},
+		{"Still free", 4, 0},
+		{"First lockout", 5, time.Minute},
+		{"Doubled", 6, 2 * time.Minute},
+		{"Doubled again", 7, 4 * time.Minute},
+		{"Capped", 20, 10 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := backoff(tt.failures, 5, time.Minute, 10*time.Minute)
+			if got != tt.want {
+				t.Errorf("want %v; got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	now := time.Date(2020, 12, 17, 10, 0, 0, 0, time.UTC)
+	th := newThrottle()
+	th.now = func() time.Time { return now }
+
+	for i := 0; i < ipFreeFailures-1; i++ {
+		th.fail("192.0.2.1")
+	}
+	if d := th.wait("192.0.2.1"); d != 0 {
+		t.Fatalf("want no wait during the free failures; got %v", d)
+	}
+
+	th.fail("192.0.2.1")
+	if d := th.wait("192.0.2.1"); d != ipBackoff {
+		t.Errorf("want %v; got %v", ipBackoff, d)
+	}
+	th.fail("192.0.2.1")
+	if d := th.wait("192.0.2.1"); d != 2*ipBackoff {
+		t.Errorf("want %v; got %v", 2*ipBackoff, d)
+	}
+	// Other addresses don't suffer for it
+	if d := th.wait("192.0.2.2"); d != 0 {
+		t.Errorf("want no wait for another address; got %v", d)
+	}
+
+	// The wait runs out
+	now = now.Add(2 * ipBackoff)
+	if d := th.wait("192.0.2.1"); d != 0 {
+		t.Errorf("want the wait over; got %v", d)
+	}
+
+	// And the address is forgotten once it's been quiet for long enough
+	now = now.Add(failureWindow + time.Minute)
+	th.fail("192.0.2.2")
+	if _, ok := th.failures["192.0.2.1"]; ok {
+		t.Error("want the old address swept away")
+	}
+	if f := th.failures["192.0.2.2"]; f == nil || f.count != 1 {
+		t.Errorf("want the new address counted once; got %+v", f)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	app := newTestApplication(t)
+	users := app.users.(*mock.UserModel)
+	audit := app.auditEvents.(*mock.AuditModel)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+
+	login := func(email string) (int, http.Header, []byte) {
+		form := url.Values{}
+		form.Add("email", email)
+		form.Add("password", "validPa$$word")
+		form.Add("csrf_token", csrfToken)
+		return ts.postForm(t, "/user/login", form)
+	}
+	notice := []byte("Too many failed attempts to log in. Please try again in 15 minutes.")
+
+	// The mock doesn't know Mallory, so these all fail
+	for i := 1; i < accountFreeFailures; i++ {
+		code, _, body := login("mallory@example.com")
+		if code != http.StatusOK || !bytes.Contains(body, []byte("Email or password is incorrect")) {
+			t.Fatalf("attempt %d: want %d and the usual error; got %d", i, http.StatusOK, code)
+		}
+	}
+	code, headers, body := login("mallory@example.com")
+	if code != http.StatusTooManyRequests {
+		t.Fatalf("want %d; got %d", http.StatusTooManyRequests, code)
+	}
+	if ra := headers.Get("Retry-After"); ra != "900" {
+		t.Errorf("want Retry-After 900; got %q", ra)
+	}
+	if !bytes.Contains(body, notice) {
+		t.Errorf("want the lockout notice; got %s", body)
+	}
+	// Differently written, but the same address
+	code, _, _ = login(" MALLORY@example.com")
+	if code != http.StatusTooManyRequests {
+		t.Errorf("want %d for the same address in capitals; got %d", http.StatusTooManyRequests, code)
+	}
+	if users.Failures["mallory@example.com"].Count != accountFreeFailures {
+		t.Errorf("want locked out attempts not counted; got %d", users.Failures["mallory@example.com"].Count)
+	}
+	if got := auditedActions(audit); got[len(got)-1] != "user.lockout email:mallory@example.com" {
+		t.Errorf("want the lockout audited; got %q", got)
+	}
+
+	// Somebody who does exist gets exactly the same page, even with the
+	// right password
+	users.Failures["alice@example.com"] = &mock.LoginFailures{Count: accountFreeFailures, Last: time.Now()}
+	code, _, aliceBody := login("alice@example.com")
+	if code != http.StatusTooManyRequests {
+		t.Fatalf("want %d; got %d", http.StatusTooManyRequests, code)
+	}
+	// Apart from the email address that was typed in and the CSRF token,
+	// which is different every time
+	strip := func(body []byte, email string) []byte {
+		body = csrfTokenRX.ReplaceAll(body, nil)
+		return bytes.ReplaceAll(body, []byte(email), nil)
+	}
+	if !bytes.Equal(strip(aliceBody, "alice@example.com"), strip(body, "mallory@example.com")) {
+		t.Error("want the same page whether or not the email address exists")
+	}
+
+	// The lockout runs out
+	users.Failures["alice@example.com"].Last = time.Now().Add(-accountLockout)
+	code, _, _ = login("alice@example.com")
+	if code != http.StatusSeeOther {
+		t.Fatalf("want %d; got %d", http.StatusSeeOther, code)
+	}
+	// And logging in starts the count again
+	if _, ok := users.Failures["alice@example.com"]; ok {
+		t.Error("want the failures reset after logging in")
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// As if this address had been trying lots of different accounts
+	for i := 0; i < ipFreeFailures; i++ {
+		app.loginThrottle.fail("127.0.0.1")
+	}
+
+	_, _, body := ts.get(t, "/user/login")
+	form := url.Values{}
+	form.Add("email", "alice@example.com")
+	form.Add("password", "validPa$$word")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, headers, body := ts.postForm(t, "/user/login", form)
+
+	if code != http.StatusTooManyRequests {
+		t.Fatalf("want %d; got %d", http.StatusTooManyRequests, code)
+	}
+	if ra := headers.Get("Retry-After"); ra != "1" {
+		t.Errorf("want Retry-After 1; got %q", ra)
+	}
+	if !bytes.Contains(body, []byte("Please try again in 1 minute.")) {
+		t.Errorf("want the lockout notice; got %s", body)
+	}
+}
@@ -6,13 +6,26 @@ import (
 	"flag"
 	"fmt"
 	"html/template"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"dvhthomas/snippetbox/pkg/mailer"
 	"dvhthomas/snippetbox/pkg/models"
+	"dvhthomas/snippetbox/pkg/models/memory"
+	"dvhthomas/snippetbox/pkg/models/migrate"
 	"dvhthomas/snippetbox/pkg/models/mysql"
+	"dvhthomas/snippetbox/pkg/models/postgres"
+	"dvhthomas/snippetbox/pkg/models/sqlite"
+	"dvhthomas/snippetbox/pkg/oidc"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/golangcollege/sessions"
@@ -25,6 +38,19 @@ import (
 type contextKey string
 
 const contextKeyIsAuthenticated = contextKey("isAuthenticated")
+const contextKeyAuthenticatedUserID = contextKey("authenticatedUserID")
+
+// What the user is allowed to do, like models.RoleAdmin. Only set for users
+// who logged in with a session, since the API has no admin features.
+const contextKeyAuthenticatedUserRole = contextKey("authenticatedUserRole")
+
+// Set when the user was authenticated by an API token rather than a session
+// cookie. Those requests don't need CSRF protection.
+const contextKeyIsTokenAuthenticated = contextKey("isTokenAuthenticated")
+
+// The ID of the server-side session that the request's cookie belongs to, so
+// the user can tell which browser they're using on the sessions page
+const contextKeySessionID = contextKey("sessionID")
 
 type application struct {
 	errorLog *log.Logger
@@ -35,9 +61,17 @@ type application struct {
 	// match the interface instead of putting a concrete implementation like
 	// mysql.UserModel in here instead.
 	snippets interface {
-		Insert(string, string, string) (int, error)
+		Insert(int, string, string, string, string, string) (int, error)
 		Get(int) (*models.Snippet, error)
+		GetBySlug(string) (*models.Snippet, error)
+		Update(int, string, string, string, string) error
+		Delete(int) error
 		Latest() ([]*models.Snippet, error)
+		List(*models.Cursor, int) ([]*models.Snippet, error)
+		Search(string, int) ([]*models.Snippet, error)
+		All(int) ([]*models.Snippet, error)
+		ByAuthor(int) ([]*models.Snippet, error)
+		DeleteExpired(int) (int, error)
 	}
 	templateCache map[string]*template.Template
 	session       *sessions.Session
@@ -46,28 +80,204 @@ type application struct {
 		Insert(string, string, string) error
 		Authenticate(string, string) (int, error)
 		Get(int) (*models.User, error)
+		GetByEmail(string) (*models.User, error)
+		List() ([]*models.User, error)
+		SetActive(int, bool) error
+		SetVerified(int, bool) error
+		SetPassword(int, string) error
+		SetName(int, string) error
+		SetEmail(int, string) error
+		ChangePassword(int, string, string) error
+		SetRole(int, string) error
+		LoginFailures(string) (int, time.Time, error)
+		RecordLoginFailure(string) error
+		ResetLoginFailures(string) error
+		SetTOTPSecret(int, []byte) error
+		UseTOTPStep(int, int64) error
+	}
+	// Personal API tokens for scripts and other non-browser clients
+	tokens interface {
+		Insert(int, string) (string, error)
+		List(int) ([]*models.APIToken, error)
+		Delete(int, int) error
+		Authenticate(string) (int, error)
+	}
+	// Reverse proxies whose X-Forwarded-For header can be believed
+	trustedProxies []*net.IPNet
+	// Slows down clients that keep getting their password wrong
+	loginThrottle *throttle
+	// The append-only record of who did what, which admins can read
+	auditEvents interface {
+		Insert(int, string, string, string, string) error
+		List(int) ([]*models.AuditEvent, error)
+		Before(int) ([]*models.AuditEvent, error)
+	}
+	// One-time links for users who have forgotten their password
+	passwordResets interface {
+		Insert(int, time.Duration) (string, error)
+		Check(string) (int, error)
+		Consume(string) (int, error)
 	}
+	// One-time codes for users with two-factor authentication to log in
+	// with when they don't have their authenticator app
+	recoveryCodes interface {
+		Replace(int) ([]string, error)
+		Consume(int, string) error
+		Count(int) (int, error)
+		Delete(int) error
+	}
+	// The accounts at the identity provider that users have logged in with
+	identities interface {
+		Insert(int, string, string) error
+		Get(string, string) (int, error)
+	}
+	// The browsers that users are logged in on. The session cookie only
+	// holds a token for one of these, so deleting it logs that browser out
+	// wherever it is.
+	userSessions interface {
+		Insert(int, string, string, time.Duration) (string, error)
+		Authenticate(string, string) (*models.Session, error)
+		List(int) ([]*models.Session, error)
+		Delete(int, int) error
+		DeleteAll(int) error
+	}
+	// The identity provider that users can log in with instead of a
+	// password, and what to call it on the login page. It's nil unless
+	// -oidc-issuer is set.
+	oidc     *oidc.Provider
+	oidcName string
+	// Sends the emails with those links in, which need the address of the
+	// site because they're read somewhere else
+	mailer  mailer.Mailer
+	baseURL string
+	// The -secret flag, which signs things like verification links and
+	// encrypts two-factor secrets as well as the session cookie
+	secret []byte
+	// The time now, which the tests can change to check two-factor codes
+	now func() time.Time
 }
 
 func main() {
 	dbUser := flag.String("dbuser", "", "Database user that application runs under")
 	dbPass := flag.String("dbpass", "", "Database password for the application user")
 	dbHost := flag.String("dbhost", "0.0.0.0", "Database host")
+	dbDriver := flag.String("db-driver", "mysql", "Where to keep the data: mysql, postgres, sqlite or memory")
+	dsn := flag.String("dsn", "", "Data source name. For mysql and postgres it's built from -dbuser, -dbpass and -dbhost if empty.\nFor sqlite it's the database file, snippetbox.db if empty")
 	addr := flag.String("addr", ":4000", "HTTP network address")
 	// The secret is a random 32 character value used to encrypt and auth cookies
 	secret := flag.String("secret", "", "Secret key for session encryption.\nTry 'openssl rand -base64 32' to generate one")
+	reapInterval := flag.Duration("reap-interval", time.Hour, "How often to delete expired snippets, or 0 to never delete them")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "How long to wait for requests to finish when shutting down")
+	autoMigrate := flag.Bool("migrate", false, "Apply any pending schema migrations before starting")
+	baseURL := flag.String("base-url", "https://localhost:4000", "Address of the site for links in emails")
+	smtpAddr := flag.String("smtp-addr", "", "SMTP server host:port for sending email. If empty, emails are written to -mail-log instead")
+	smtpUser := flag.String("smtp-user", "", "SMTP username, if the server needs one")
+	smtpPass := flag.String("smtp-pass", "", "SMTP password")
+	mailFrom := flag.String("mail-from", "Snippetbox <no-reply@localhost>", "Sender of emails")
+	mailLog := flag.String("mail-log", "", "File to append emails to when there's no SMTP server, or standard output if empty")
+	trustedProxies := flag.String("trusted-proxies", "", "Comma-separated IP addresses or CIDR ranges of reverse proxies\nwhose X-Forwarded-For header tells us the client's address")
+	oidcIssuer := flag.String("oidc-issuer", "", "URL of an OpenID Connect provider that users can log in with, like https://accounts.google.com.\nIts redirect URL is -base-url followed by /user/login/oidc/callback")
+	oidcClientID := flag.String("oidc-client-id", "", "Client ID that the OpenID Connect provider gave us")
+	oidcClientSecret := flag.String("oidc-client-secret", "", "Client secret that the OpenID Connect provider gave us, if it gave us one")
+	oidcName := flag.String("oidc-name", "single sign-on", "What to call the OpenID Connect provider on the login page")
 	flag.Parse()
 
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
 	// DSN is a Data Source Name
-	dsn := fmt.Sprintf("%s:%s@tcp(%s)/snippetbox?parseTime=true", *dbUser, *dbPass, *dbHost)
+	if *dsn == "" {
+		switch *dbDriver {
+		case "mysql":
+			*dsn = fmt.Sprintf("%s:%s@tcp(%s)/snippetbox?parseTime=true", *dbUser, *dbPass, *dbHost)
+		case "postgres":
+			u := url.URL{
+				Scheme:   "postgres",
+				User:     url.UserPassword(*dbUser, *dbPass),
+				Host:     *dbHost,
+				Path:     "/snippetbox",
+				RawQuery: "sslmode=disable",
+			}
+			*dsn = u.String()
+		case "sqlite":
+			*dsn = "snippetbox.db"
+		}
+	}
+
+	proxies, err := parseTrustedProxies(*trustedProxies)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	app := &application{
+		errorLog:       errorLog,
+		infoLog:        infoLog,
+		trustedProxies: proxies,
+		loginThrottle:  newThrottle(),
+		baseURL:        strings.TrimSuffix(*baseURL, "/"),
+		now:            time.Now,
+	}
+
+	if *oidcIssuer != "" {
+		if *oidcClientID == "" {
+			errorLog.Fatal("-oidc-client-id is needed with -oidc-issuer")
+		}
+		app.oidc = oidc.New(*oidcIssuer, *oidcClientID, *oidcClientSecret, app.baseURL+"/user/login/oidc/callback")
+		app.oidcName = *oidcName
+	}
+
+	if *smtpAddr != "" {
+		app.mailer, err = mailer.NewSMTP(*smtpAddr, *smtpUser, *smtpPass, *mailFrom)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+	} else {
+		out := io.Writer(os.Stdout)
+		if *mailLog != "" {
+			f, err := os.OpenFile(*mailLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+			if err != nil {
+				errorLog.Fatal(err)
+			}
+			// It stays open until the server stops
+			out = f
+		}
+		app.mailer = &mailer.Log{Out: out, From: *mailFrom}
+	}
 
-	db, err := openDB(dsn)
+	migrator, closeDB, err := app.openModels(*dbDriver, *dsn)
 	if err != nil {
 		errorLog.Fatal(err)
 	}
 
+	// `web migrate up|down|status` changes the schema and `web admin ...`
+	// manages users. Both exit without starting the server.
+	switch flag.Arg(0) {
+	case "":
+		// No command, so run the server
+	case "migrate":
+		err = runMigrate(migrator, flag.Args()[1:], os.Stdout)
+	case "admin":
+		err = app.runAdmin(flag.Args()[1:], os.Stdin, os.Stdout)
+	default:
+		err = fmt.Errorf("unknown command %q, want migrate or admin", flag.Arg(0))
+	}
+	if flag.NArg() > 0 {
+		closeDB()
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		return
+	}
+
+	if *autoMigrate && migrator != nil {
+		done, err := migrator.Up()
+		for _, mg := range done {
+			infoLog.Printf("Applied migration %04d %s", mg.Version, mg.Name)
+		}
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+	}
+
 	templateCache, err := newTemplateCache("./ui/html")
 	if err != nil {
 		errorLog.Fatal(err)
@@ -78,22 +288,16 @@ func main() {
 	session.Lifetime = 12 * time.Hour
 	session.Secure = true
 
-	app := &application{
-		errorLog:      errorLog,
-		infoLog:       infoLog,
-		session:       session,
-		snippets:      &mysql.SnippetModel{DB: db},
-		templateCache: templateCache,
-		users:         &mysql.UserModel{DB: db},
-	}
+	app.session = session
+	app.secret = []byte(*secret)
+	app.templateCache = templateCache
 
 	tlsConfig := &tls.Config{
 		PreferServerCipherSuites: true,
 		CurvePreferences:         []tls.CurveID{tls.X25519, tls.CurveP256},
 	}
 
-	app.infoLog.Printf("Connected to the database as %s", *dbUser)
-	defer db.Close()
+	app.infoLog.Printf("Using the %s database", *dbDriver)
 
 	srv := &http.Server{
 		Addr:         *addr,
@@ -105,9 +309,124 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 	}
 
+	// Background workers finish what they're doing once stop is closed, and
+	// the WaitGroup lets us wait for them to do so.
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	if *reapInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.reapSnippets(*reapInterval, stop)
+		}()
+	}
+
+	// Deploys and Ctrl-C send these. Anything else still kills us outright.
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
 	infoLog.Printf("Starting server on %s", *addr)
-	err = srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
-	errorLog.Fatal(err)
+	err = app.serve(srv, func() error {
+		return srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
+	}, quit, *drainTimeout)
+
+	// Don't use errorLog.Fatal from here on because it would skip the
+	// cleanup. Instead we note whether anything went wrong for the exit code.
+	status := 0
+	if err != nil {
+		errorLog.Print(err)
+		status = 1
+	}
+
+	close(stop)
+	wg.Wait()
+
+	if err := closeDB(); err != nil {
+		errorLog.Print(err)
+		status = 1
+	}
+
+	infoLog.Print("Stopped")
+	os.Exit(status)
+}
+
+// Point the models at the chosen backend. The migrator changes the schema of
+// the database, and is nil for the memory backend because it has no schema.
+// The function that's returned closes whatever connections the backend opened.
+func (app *application) openModels(driver, dsn string) (*migrate.Migrator, func() error, error) {
+	switch driver {
+	case "mysql":
+		db, err := openDB(dsn)
+		if err != nil {
+			return nil, nil, err
+		}
+		app.snippets = &mysql.SnippetModel{DB: db}
+		app.users = &mysql.UserModel{DB: db}
+		app.tokens = &mysql.TokenModel{DB: db}
+		app.auditEvents = &mysql.AuditModel{DB: db}
+		app.passwordResets = &mysql.PasswordResetModel{DB: db}
+		app.recoveryCodes = &mysql.RecoveryCodeModel{DB: db}
+		app.identities = &mysql.IdentityModel{DB: db}
+		app.userSessions = &mysql.SessionModel{DB: db}
+		m, err := migrate.New(db, "mysql", mysql.Migrations())
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		return m, db.Close, nil
+	case "postgres":
+		db, err := postgres.Open(dsn)
+		if err != nil {
+			return nil, nil, err
+		}
+		app.snippets = &postgres.SnippetModel{DB: db}
+		app.users = &postgres.UserModel{DB: db}
+		app.tokens = &postgres.TokenModel{DB: db}
+		app.auditEvents = &postgres.AuditModel{DB: db}
+		app.passwordResets = &postgres.PasswordResetModel{DB: db}
+		app.recoveryCodes = &postgres.RecoveryCodeModel{DB: db}
+		app.identities = &postgres.IdentityModel{DB: db}
+		app.userSessions = &postgres.SessionModel{DB: db}
+		m, err := migrate.New(db, "postgres", postgres.Migrations())
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		return m, db.Close, nil
+	case "sqlite":
+		db, err := sqlite.Open(dsn)
+		if err != nil {
+			return nil, nil, err
+		}
+		app.snippets = &sqlite.SnippetModel{DB: db}
+		app.users = &sqlite.UserModel{DB: db}
+		app.tokens = &sqlite.TokenModel{DB: db}
+		app.auditEvents = &sqlite.AuditModel{DB: db}
+		app.passwordResets = &sqlite.PasswordResetModel{DB: db}
+		app.recoveryCodes = &sqlite.RecoveryCodeModel{DB: db}
+		app.identities = &sqlite.IdentityModel{DB: db}
+		app.userSessions = &sqlite.SessionModel{DB: db}
+		m, err := migrate.New(db, "sqlite", sqlite.Migrations())
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		return m, db.Close, nil
+	case "memory":
+		// Everything is lost when the server stops
+		db := memory.New()
+		app.snippets = &memory.SnippetModel{DB: db}
+		app.users = &memory.UserModel{DB: db}
+		app.tokens = &memory.TokenModel{DB: db}
+		app.auditEvents = &memory.AuditModel{DB: db}
+		app.passwordResets = &memory.PasswordResetModel{DB: db}
+		app.recoveryCodes = &memory.RecoveryCodeModel{DB: db}
+		app.identities = &memory.IdentityModel{DB: db}
+		app.userSessions = &memory.SessionModel{DB: db}
+		return nil, func() error { return nil }, nil
+	}
+	return nil, nil, fmt.Errorf("unknown database driver %q", driver)
 }
 
 func openDB(connStr string) (*sql.DB, error) {
@@ -116,6 +435,7 @@ func openDB(connStr string) (*sql.DB, error) {
 		return nil, err
 	}
 	if err = db.Ping(); err != nil {
+		db.Close()
 		return nil, err
 	}
 	return db, nil
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenModels(t *testing.T) {
+	tests := []struct {
+		name    string
+		driver  string
+		dsn     string
+		wantErr bool
+	}{
+		{"Memory", "memory", "", false},
+		{"SQLite", "sqlite", filepath.Join(t.TempDir(), "snippetbox.db"), false},
+		{"Unknown driver", "oracle", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{}
+			migrator, closeDB, err := app.openModels(tt.driver, tt.dsn)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("want an error; got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer closeDB()
+
+			// Only the memory backend has no schema to create
+			if migrator != nil {
+				if _, err = migrator.Up(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Every backend starts out empty
+			latest, err := app.snippets.Latest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(latest) != 0 {
+				t.Errorf("want no snippets; got %d", len(latest))
+			}
+		})
+	}
+}
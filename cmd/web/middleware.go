@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/justinas/nosurf"
 )
@@ -22,34 +23,93 @@ func secureHeaders(next http.Handler) http.Handler {
 
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// An API token has already identified the user, and it takes
+		// precedence over any session cookie that came along with it.
+		if isTokenAuthenticated(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
 
-		// Check if an authenticatedUserID value exists in the session.
+		// Check if a sessionToken value exists in the session.
 		// If this *IS NOT* present then call the next handler in the chain as
 		// normal.
-		exists := app.session.Exists(r, "authenticatedUserID")
-		if !exists {
+		token := app.session.GetString(r, "sessionToken")
+		if token == "" {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		// Fetch the details of the the current user from the DB. If no matching
-		// value is found or the user has been deactivated, remove the (invalid!)
-		// authenticatedUserID from the their session and call the next
+		// Look the token up in the server-side sessions. If it has expired
+		// or been revoked, from this browser or any other, remove the
+		// (invalid!) sessionToken from their session and call the next
 		// handler in the chain as normal.
-		user, err := app.users.Get(app.session.GetInt(r, "authenticatedUserID"))
-		if errors.Is(err, models.ErrNoRecord) || !user.Active {
-			app.session.Remove(r, "authenticatedUserID")
+		s, err := app.userSessions.Authenticate(token, app.clientIP(r))
+		if err != nil && !errors.Is(err, models.ErrInvalidCredentials) {
+			app.serverError(w, err)
+			return
+		}
+		if err != nil {
+			app.session.Remove(r, "sessionToken")
 			next.ServeHTTP(w, r)
 			return
-		} else if err != nil {
+		}
+
+		// Fetch the details of the the current user from the DB. If no matching
+		// value is found or the user has been deactivated, treat them the
+		// same way.
+		user, err := app.users.Get(s.UserID)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
 			app.serverError(w, err)
 			return
 		}
+		if err != nil || !user.Active {
+			app.session.Remove(r, "sessionToken")
+			next.ServeHTTP(w, r)
+			return
+		}
 
 		// OK. If we got here there is an active user session and that user
 		// is both in the DB and Active. We're good! Let's create a copy of the
-		// request and put our value in the context.
+		// request and put our values in the context.
 		ctx := context.WithValue(r.Context(), contextKeyIsAuthenticated, true)
+		ctx = context.WithValue(ctx, contextKeyAuthenticatedUserID, user.ID)
+		ctx = context.WithValue(ctx, contextKeyAuthenticatedUserRole, user.Role)
+		ctx = context.WithValue(ctx, contextKeySessionID, s.ID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Authenticate API requests that carry an 'Authorization: Bearer <token>'
+// header. Requests without one carry on to the usual session-based
+// authenticate. A token that doesn't check out is rejected outright rather
+// than falling back to the session, since the client clearly meant to use it.
+func (app *application) authenticateToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := strings.TrimPrefix(header, "Bearer ")
+		if token == header || token == "" {
+			app.apiError(w, http.StatusUnauthorized, "malformed Authorization header")
+			return
+		}
+
+		id, err := app.tokens.Authenticate(token)
+		if err != nil {
+			if errors.Is(err, models.ErrInvalidCredentials) {
+				app.apiError(w, http.StatusUnauthorized, "invalid API token")
+			} else {
+				app.apiServerError(w, err)
+			}
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), contextKeyIsAuthenticated, true)
+		ctx = context.WithValue(ctx, contextKeyAuthenticatedUserID, id)
+		ctx = context.WithValue(ctx, contextKeyIsTokenAuthenticated, true)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -103,9 +163,56 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+// Only let users with the role through. Use it after requireAuthentication,
+// which takes care of sending anybody who isn't logged in to the login page.
+// Everybody else gets a 403, and there's no point hiding that the admin pages
+// exist because the routes are in the source for anybody to read.
+func (app *application) requireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if app.authenticatedUserRole(r) != role {
+				app.clientError(w, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// The API equivalent of requireAuthentication. Scripts can't follow a
+// redirect to a login form, so tell them what's wrong in JSON instead.
+func (app *application) requireAPIAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.isAuthenticated(r) {
+			app.apiError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+
+		w.Header().Add("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
+}
+
 // Create a NoSurf middleware to use a customized CSRF cookie with the Secure, Path, and
 // HttpOnly flags set
 func noSurf(next http.Handler) http.Handler {
+	return newCSRFHandler(next)
+}
+
+// The same CSRF protection for the API, except that failures are reported as
+// JSON. Browser clients send the token in the X-CSRF-Token header. Requests
+// authenticated by an API token are exempt, because a cross-site request
+// can't forge a header that the browser never had.
+func (app *application) apiNoSurf(next http.Handler) http.Handler {
+	csrfHandler := newCSRFHandler(next)
+	csrfHandler.ExemptFunc(isTokenAuthenticated)
+	csrfHandler.SetFailureHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.apiError(w, http.StatusBadRequest, nosurf.Reason(r).Error())
+	}))
+	return csrfHandler
+}
+
+func newCSRFHandler(next http.Handler) *nosurf.CSRFHandler {
 	csrfHandler := nosurf.New(next)
 	csrfHandler.SetBaseCookie(http.Cookie{
 		HttpOnly: true,
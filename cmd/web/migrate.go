@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"dvhthomas/snippetbox/pkg/models/migrate"
+)
+
+// Handle `web migrate up|down|status`. The output is for a person at a
+// terminal, so it goes to out rather than the logs.
+func runMigrate(m *migrate.Migrator, args []string, out io.Writer) error {
+	// The memory backend starts from nothing every time, so there is no
+	// schema to change.
+	if m == nil {
+		return errors.New("this database driver has no migrations")
+	}
+	if len(args) != 1 {
+		return errors.New("usage: web [flags] migrate up|down|status")
+	}
+
+	switch args[0] {
+	case "up":
+		done, err := m.Up()
+		for _, mg := range done {
+			fmt.Fprintf(out, "Applied %04d %s\n", mg.Version, mg.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(done) == 0 {
+			fmt.Fprintln(out, "Already up to date")
+		}
+		return nil
+	case "down":
+		mg, err := m.Down()
+		if errors.Is(err, migrate.ErrNoChange) {
+			fmt.Fprintln(out, "Nothing to undo")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Undid %04d %s\n", mg.Version, mg.Name)
+		return nil
+	case "status":
+		statuses, err := m.Status()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, s := range statuses {
+			applied := "pending"
+			if !s.Pending() {
+				applied = s.Applied.UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return w.Flush()
+	}
+	return fmt.Errorf("unknown migrate command %q, want up, down or status", args[0])
+}
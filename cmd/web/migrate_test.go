@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunMigrate(t *testing.T) {
+	app := &application{}
+	migrator, closeDB, err := app.openModels("sqlite", filepath.Join(t.TempDir(), "snippetbox.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeDB()
+
+	// Each step runs against the database the previous step left behind
+	tests := []struct {
+		name    string
+		args    []string
+		wantOut []string
+		wantErr bool
+	}{
+		{"Status before", []string{"status"}, []string{"VERSION", "0001", "initial_schema", "pending"}, false},
+		{"Up", []string{"up"}, []string{"Applied 0001 initial_schema"}, false},
+		{"Up again", []string{"up"}, []string{"Already up to date"}, false},
+		{"Status after", []string{"status"}, []string{"0001", "initial_schema"}, false},
+		{"Down", []string{"down"}, []string{"Undid"}, false},
+		{"Unknown command", []string{"sideways"}, nil, true},
+		{"No command", []string{}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := runMigrate(migrator, tt.args, &out)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("want an error; got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.wantOut {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("want output to contain %q; got %q", want, out.String())
+				}
+			}
+		})
+	}
+
+	// Undo everything, one migration at a time, so the first one goes last
+	var out, last bytes.Buffer
+	for !strings.Contains(out.String(), "Nothing to undo") {
+		last = out
+		out = bytes.Buffer{}
+		if err = runMigrate(migrator, []string{"down"}, &out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !strings.Contains(last.String(), "Undid 0001 initial_schema") {
+		t.Errorf("want the initial schema undone last; got %q", last.String())
+	}
+
+	// Once they're applied the status shows when rather than pending
+	out.Reset()
+	if err = runMigrate(migrator, []string{"up"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err = runMigrate(migrator, []string{"status"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "pending") {
+		t.Errorf("want nothing pending; got %q", out.String())
+	}
+}
+
+func TestRunMigrateMemory(t *testing.T) {
+	if err := runMigrate(nil, []string{"up"}, &bytes.Buffer{}); err == nil {
+		t.Error("want an error; got nil")
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/subtle"
+	"dvhthomas/snippetbox/pkg/models"
+	"dvhthomas/snippetbox/pkg/oidc"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Send the user to the identity provider to log in. What we need to check
+// their return is kept in the session, which they can't read or change.
+func (app *application) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFound(w)
+		return
+	}
+
+	ar, err := app.oidc.AuthCodeURL(r.Context())
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.session.Put(r, "oidcState", ar.State)
+	app.session.Put(r, "oidcNonce", ar.Nonce)
+	app.session.Put(r, "oidcVerifier", ar.Verifier)
+	http.Redirect(w, r, ar.URL, http.StatusSeeOther)
+}
+
+// The identity provider sends the user back here with a code, which we swap
+// for who they are. Somebody we haven't seen before gets an account made for
+// them, or linked to the one that already has their email address.
+func (app *application) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFound(w)
+		return
+	}
+
+	// Each login can only be finished once
+	state := app.session.PopString(r, "oidcState")
+	nonce := app.session.PopString(r, "oidcNonce")
+	verifier := app.session.PopString(r, "oidcVerifier")
+
+	q := r.URL.Query()
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(q.Get("state"))) != 1 {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	// Like when the user said no to sharing their details with us
+	if q.Get("error") != "" {
+		app.oidcFailed(w, r, "Logging in with "+app.oidcName+" didn't work. Please try again.")
+		return
+	}
+
+	claims, err := app.oidc.Exchange(r.Context(), q.Get("code"), verifier, nonce)
+	if err != nil {
+		if errors.Is(err, oidc.ErrInvalidToken) {
+			app.infoLog.Printf("oidc login from %s: %s", app.clientIP(r), err)
+			app.oidcFailed(w, r, "Logging in with "+app.oidcName+" didn't work. Please try again.")
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+
+	u, err := app.oidcUser(r, claims)
+	if errors.Is(err, errUnverifiedEmail) {
+		app.oidcFailed(w, r, "Your "+app.oidcName+" account doesn't have a verified email address, so we can't log you in with it.")
+		return
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if !u.Active {
+		app.audit(r, 0, "user.login_failed", fmt.Sprintf("user:%d", u.ID))
+		app.oidcFailed(w, r, "Your account has been deactivated.")
+		return
+	}
+
+	// The provider vouches for the password, but not for the second factor
+	if u.TOTPSecret != nil {
+		app.startTwoFactorLogin(w, r, u)
+		return
+	}
+	app.finishLogin(w, r, u)
+}
+
+// errUnverifiedEmail is returned for somebody we've never seen before whose
+// provider hasn't checked their email address
+var errUnverifiedEmail = errors.New("email address not verified by the identity provider")
+
+// Find the user that the provider says has logged in. It's whoever has been
+// linked to them before. Failing that it's the user with the same email
+// address, who gets linked now, and if there isn't one, a new user.
+func (app *application) oidcUser(r *http.Request, claims *oidc.Claims) (*models.User, error) {
+	issuer := app.oidc.Issuer
+	id, err := app.identities.Get(issuer, claims.Subject)
+	if err == nil {
+		return app.users.Get(id)
+	}
+	if !errors.Is(err, models.ErrNoRecord) {
+		return nil, err
+	}
+
+	// Matching on an address the provider hasn't checked would let anybody
+	// take over an account by putting its address on theirs
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errUnverifiedEmail
+	}
+
+	u, err := app.users.GetByEmail(claims.Email)
+	switch {
+	case err == nil:
+		if !u.Active {
+			// Linking wouldn't let them in anyway
+			return u, nil
+		}
+		if !u.Verified {
+			// Nobody has proved that this address was theirs when they
+			// signed up, and it might have been somebody else hoping for
+			// the real owner to come along and verify it for them. Their
+			// password goes, and the real owner can set a new one if they
+			// want one.
+			password, _, err := models.NewToken()
+			if err != nil {
+				return nil, err
+			}
+			if err = app.users.SetPassword(u.ID, password); err != nil {
+				return nil, err
+			}
+			if err = app.userSessions.DeleteAll(u.ID); err != nil {
+				return nil, err
+			}
+		}
+		app.audit(r, u.ID, "user.oidc_link", fmt.Sprintf("user:%d", u.ID))
+	case errors.Is(err, models.ErrNoRecord):
+		// Nobody can log in with the password, since nobody knows it. They
+		// can set one through the forgotten password page if they want.
+		password, _, err := models.NewToken()
+		if err != nil {
+			return nil, err
+		}
+		err = app.users.Insert(oidcName(claims), claims.Email, password)
+		if err != nil {
+			return nil, err
+		}
+		app.audit(r, 0, "user.signup", "email:"+claims.Email)
+		u, err = app.users.GetByEmail(claims.Email)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	// The provider has checked the address, so there's no need for us to
+	if !u.Verified {
+		if err = app.users.SetVerified(u.ID, true); err != nil {
+			return nil, err
+		}
+	}
+	if err = app.identities.Insert(u.ID, issuer, claims.Subject); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// The name for a new user. Not every provider shares one, so it falls back
+// to the start of their email address.
+func oidcName(claims *oidc.Claims) string {
+	name := strings.TrimSpace(claims.Name)
+	if name == "" {
+		name = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+	return truncate(name, 255)
+}
+
+// Send the user back to the login page to read why they aren't logged in
+func (app *application) oidcFailed(w http.ResponseWriter, r *http.Request, message string) {
+	app.session.Put(r, "flash", message)
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
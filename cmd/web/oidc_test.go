@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"dvhthomas/snippetbox/pkg/models"
+	"dvhthomas/snippetbox/pkg/models/mock"
+	"dvhthomas/snippetbox/pkg/oidc"
+	"dvhthomas/snippetbox/pkg/oidc/oidctest"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// Point the application at a fake identity provider that logs in identity
+func withOIDC(t *testing.T, app *application, identity oidctest.Identity) *oidctest.Issuer {
+	t.Helper()
+	issuer := oidctest.NewIssuer(t, identity)
+	app.oidc = oidc.New(issuer.URL, oidctest.ClientID, oidctest.ClientSecret, app.baseURL+"/user/login/oidc/callback")
+	app.oidcName = "Example SSO"
+	return issuer
+}
+
+// Log in through the identity provider the way a browser would, and return
+// the response to coming back to the callback
+func (ts *testServer) loginOIDC(t *testing.T) (int, http.Header, []byte) {
+	t.Helper()
+	code, headers, _ := ts.get(t, "/user/login/oidc")
+	if code != http.StatusSeeOther {
+		t.Fatalf("want %d; got %d", http.StatusSeeOther, code)
+	}
+
+	// The provider is on another server, so it's a different client
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	rs, err := client.Get(headers.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+	back, err := url.Parse(rs.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.Path != "/user/login/oidc/callback" {
+		t.Fatalf("want to be sent back to the callback; got %q", back)
+	}
+	return ts.get(t, back.Path+"?"+back.RawQuery)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	tests := []struct {
+		name         string
+		identity     oidctest.Identity
+		wantLocation string
+		wantUserID   int
+		wantAudit    string
+	}{
+		{"New user", oidctest.Identity{Subject: "erin", Email: "erin@example.com", EmailVerified: true, Name: "Erin"},
+			"/snippet/create", 100, "user.signup email:erin@example.com"},
+		{"Existing user", oidctest.Identity{Subject: "alice", Email: "alice@example.com", EmailVerified: true, Name: "Alice"},
+			"/snippet/create", 1, "user.oidc_link user:1"},
+		{"Existing unverified user", oidctest.Identity{Subject: "dave", Email: "dave@example.com", EmailVerified: true},
+			"/snippet/create", 4, "user.oidc_link user:4"},
+		{"Unverified email", oidctest.Identity{Subject: "alice", Email: "alice@example.com", Name: "Alice"},
+			"/user/login", 0, ""},
+		{"No email", oidctest.Identity{Subject: "alice", Name: "Alice"},
+			"/user/login", 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			users := app.users.(*mock.UserModel)
+			audit := app.auditEvents.(*mock.AuditModel)
+			withOIDC(t, app, tt.identity)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			code, headers, _ := ts.loginOIDC(t)
+			if code != http.StatusSeeOther || headers.Get("Location") != tt.wantLocation {
+				t.Fatalf("want %d to %q; got %d to %q", http.StatusSeeOther, tt.wantLocation, code, headers.Get("Location"))
+			}
+
+			id, err := app.identities.Get(app.oidc.Issuer, tt.identity.Subject)
+			if tt.wantUserID == 0 {
+				if !errors.Is(err, models.ErrNoRecord) {
+					t.Errorf("want nobody linked; got user %d and %v", id, err)
+				}
+				if len(users.SignedUp) > 0 {
+					t.Errorf("want nobody signed up; got %+v", users.SignedUp[0])
+				}
+				return
+			}
+			if err != nil || id != tt.wantUserID {
+				t.Errorf("want user %d linked; got %d and %v", tt.wantUserID, id, err)
+			}
+			if got := auditedActions(audit); len(got) < 2 || got[0] != tt.wantAudit || got[1] != fmt.Sprintf("user.login user:%d", tt.wantUserID) {
+				t.Errorf("want %q then the login audited; got %q", tt.wantAudit, got)
+			}
+			code, _, _ = ts.get(t, "/snippet/create")
+			if code != http.StatusOK {
+				t.Errorf("want to be logged in; got %d", code)
+			}
+		})
+	}
+}
+
+func TestOIDCNewUser(t *testing.T) {
+	app := newTestApplication(t)
+	users := app.users.(*mock.UserModel)
+	issuer := withOIDC(t, app, oidctest.Identity{Subject: "erin", Email: "erin@example.com", EmailVerified: true})
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.loginOIDC(t)
+	if len(users.SignedUp) != 1 {
+		t.Fatalf("want a user signed up; got %d", len(users.SignedUp))
+	}
+	u := users.SignedUp[0]
+	// Without a name from the provider, it's made from the email address
+	if u.Name != "erin" || !u.Verified {
+		t.Errorf("want a verified user called erin; got %+v", u)
+	}
+	// No verification email, since the provider has checked the address
+	if mail := sentMail(app); mail != "" {
+		t.Errorf("want no email; got %s", mail)
+	}
+
+	// Logging in again finds them by their subject, even with a new address
+	issuer.SetIdentity(oidctest.Identity{Subject: "erin", Email: "erin@elsewhere.example.com"})
+	ts2 := newTestServer(t, app.routes())
+	defer ts2.Close()
+	code, headers, _ := ts2.loginOIDC(t)
+	if code != http.StatusSeeOther || headers.Get("Location") != "/snippet/create" {
+		t.Errorf("want %d to /snippet/create; got %d to %q", http.StatusSeeOther, code, headers.Get("Location"))
+	}
+	if len(users.SignedUp) != 1 {
+		t.Errorf("want nobody else signed up; got %d", len(users.SignedUp))
+	}
+
+	// Deactivated users can't get in this way either
+	if err := users.SetActive(u.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	ts3 := newTestServer(t, app.routes())
+	defer ts3.Close()
+	code, headers, _ = ts3.loginOIDC(t)
+	if code != http.StatusSeeOther || headers.Get("Location") != "/user/login" {
+		t.Errorf("want %d to /user/login; got %d to %q", http.StatusSeeOther, code, headers.Get("Location"))
+	}
+	_, _, body := ts3.get(t, "/user/login")
+	if !bytes.Contains(body, []byte("Your account has been deactivated")) {
+		t.Errorf("want to be told why; got %s", body)
+	}
+}
+
+func TestOIDCTwoFactor(t *testing.T) {
+	app := newTestApplication(t)
+	withTwoFactor(t, app)
+	withOIDC(t, app, oidctest.Identity{Subject: "alice", Email: "alice@example.com", EmailVerified: true})
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The provider doesn't get them past their second factor
+	code, headers, _ := ts.loginOIDC(t)
+	if code != http.StatusSeeOther || headers.Get("Location") != "/user/login/2fa" {
+		t.Errorf("want %d to /user/login/2fa; got %d to %q", http.StatusSeeOther, code, headers.Get("Location"))
+	}
+	if code, _, _ = ts.get(t, "/snippet/create"); code != http.StatusSeeOther {
+		t.Errorf("want to not be logged in yet; got %d", code)
+	}
+}
+
+func TestOIDCCallback(t *testing.T) {
+	app := newTestApplication(t)
+	withOIDC(t, app, oidctest.Identity{Subject: "alice", Email: "alice@example.com", EmailVerified: true})
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Coming back without having started a login, or from somebody else's
+	if code, _, _ := ts.get(t, "/user/login/oidc/callback?code=abc&state=xyz"); code != http.StatusBadRequest {
+		t.Errorf("want %d without a login; got %d", http.StatusBadRequest, code)
+	}
+	ts.get(t, "/user/login/oidc")
+	if code, _, _ := ts.get(t, "/user/login/oidc/callback?code=abc&state=xyz"); code != http.StatusBadRequest {
+		t.Errorf("want %d for the wrong state; got %d", http.StatusBadRequest, code)
+	}
+
+	// The user said no at the provider
+	_, headers, _ := ts.get(t, "/user/login/oidc")
+	loc, err := url.Parse(headers.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := loc.Query().Get("state")
+	code, headers, _ := ts.get(t, "/user/login/oidc/callback?error=access_denied&state="+url.QueryEscape(state))
+	if code != http.StatusSeeOther || headers.Get("Location") != "/user/login" {
+		t.Errorf("want %d to /user/login; got %d to %q", http.StatusSeeOther, code, headers.Get("Location"))
+	}
+	_, _, body := ts.get(t, "/user/login")
+	if !bytes.Contains(body, []byte("Logging in with Example SSO didn&#39;t work")) {
+		t.Errorf("want to be told it didn't work; got %s", body)
+	}
+	if !bytes.Contains(body, []byte("<a href='/user/login/oidc'>Log in with Example SSO</a>")) {
+		t.Errorf("want a link to log in with the provider; got %s", body)
+	}
+}
+
+func TestOIDCNotConfigured(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	for _, path := range []string{"/user/login/oidc", "/user/login/oidc/callback"} {
+		if code, _, _ := ts.get(t, path); code != http.StatusNotFound {
+			t.Errorf("%s: want %d; got %d", path, http.StatusNotFound, code)
+		}
+	}
+	_, _, body := ts.get(t, "/user/login")
+	if strings.Contains(string(body), "/user/login/oidc") {
+		t.Error("want no link to log in with a provider")
+	}
+}
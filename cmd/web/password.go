@@ -0,0 +1,165 @@
+package main
+
+import (
+	"dvhthomas/snippetbox/pkg/forms"
+	"dvhthomas/snippetbox/pkg/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// How long the link in a password reset email works for
+const passwordResetLifetime = time.Hour
+
+func (app *application) forgotPasswordForm(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "forgot.page.tmpl", &templateData{
+		Form: forms.New(nil),
+	})
+}
+
+func (app *application) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.MatchesPattern("email", forms.EmailRX)
+	if !form.Valid() {
+		app.render(w, r, "forgot.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	u, err := app.users.GetByEmail(form.Get("email"))
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, err)
+		return
+	}
+
+	// Everybody gets the same answer whether or not there's an account, so
+	// this can't be used to find out who has one. For the same reason a
+	// failure to send the email is only logged.
+	if err == nil && u.Active {
+		if err = app.sendPasswordReset(u); err != nil {
+			app.errorLog.Printf("password reset for user:%d: %s", u.ID, err)
+		} else {
+			app.audit(r, 0, "user.password_reset_requested", fmt.Sprintf("user:%d", u.ID))
+		}
+	}
+
+	app.session.Put(r, "flash", "If there's an account for that address, we've emailed it a link to reset the password.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) sendPasswordReset(u *models.User) error {
+	token, err := app.passwordResets.Insert(u.ID, passwordResetLifetime)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf(`Hi %s,
+
+Somebody asked to reset the password for your Snippetbox account. If it was
+you, follow this link within the hour to choose a new one:
+
+%s/user/password/reset/%s
+
+If it wasn't you, you can ignore this email and your password will stay the
+same.
+`, u.Name, app.baseURL, token)
+	return app.mailer.Send(u.Email, "Reset your Snippetbox password", body)
+}
+
+func (app *application) resetPasswordForm(w http.ResponseWriter, r *http.Request) {
+	// The token is in the address, so don't hand it to anybody we link to
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	token := r.URL.Query().Get(":token")
+	if _, err := app.passwordResets.Check(token); err != nil {
+		app.invalidResetLink(w, r, err)
+		return
+	}
+
+	app.render(w, r, "reset.page.tmpl", &templateData{
+		Form:       forms.New(nil),
+		ResetToken: token,
+	})
+}
+
+func (app *application) resetPassword(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Check the new password before using the token up, so that a typo
+	// doesn't mean asking for another email
+	token := r.URL.Query().Get(":token")
+	form := forms.New(r.PostForm)
+	form.Required("password")
+	form.MinLength("password", 10)
+	if !form.Valid() {
+		app.render(w, r, "reset.page.tmpl", &templateData{
+			Form:       form,
+			ResetToken: token,
+		})
+		return
+	}
+
+	id, err := app.passwordResets.Consume(token)
+	if err != nil {
+		app.invalidResetLink(w, r, err)
+		return
+	}
+
+	err = app.users.SetPassword(id, form.Get("password"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Somebody else who knew the old password might be logged in with it
+	if err = app.userSessions.DeleteAll(id); err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Getting the email proves the address is theirs just as well as the
+	// verification link does
+	if err = app.users.SetVerified(id, true); err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Whoever was guessing the old password has nothing left to guess, so
+	// don't keep the real owner locked out
+	u, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if err = app.users.ResetLoginFailures(failureKey(u.Email)); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit(r, id, "user.password_reset", fmt.Sprintf("user:%d", id))
+
+	app.session.Put(r, "flash", "Your password has been reset. Please log in.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// Send anybody with a bad link back to ask for a new one
+func (app *application) invalidResetLink(w http.ResponseWriter, r *http.Request, err error) {
+	if !errors.Is(err, models.ErrInvalidCredentials) {
+		app.serverError(w, err)
+		return
+	}
+	app.session.Put(r, "flash", "That link is invalid or has expired. Please ask for a new one.")
+	http.Redirect(w, r, "/user/password/forgot", http.StatusSeeOther)
+}
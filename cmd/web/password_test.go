@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"dvhthomas/snippetbox/pkg/mailer"
+	"dvhthomas/snippetbox/pkg/models/mock"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestForgotPassword(t *testing.T) {
+	app := newTestApplication(t)
+	audit := app.auditEvents.(*mock.AuditModel)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/password/forgot")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name         string
+		email        string
+		wantCode     int
+		wantLocation string
+		wantBody     []byte
+		wantMail     bool
+	}{
+		{"Known user", "alice@example.com", http.StatusSeeOther, "/user/login", nil, true},
+		{"Unknown user", "mallory@example.com", http.StatusSeeOther, "/user/login", nil, false},
+		{"Empty email", "", http.StatusOK, "", []byte("This field cannot be blank"), false},
+		{"Invalid email", "aliceexample.com", http.StatusOK, "", []byte("This field is invalid"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app.mailer.(*mailer.Log).Out.(*bytes.Buffer).Reset()
+			audit.Events = nil
+
+			form := url.Values{}
+			form.Add("email", tt.email)
+			form.Add("csrf_token", csrfToken)
+			code, headers, body := ts.postForm(t, "/user/password/forgot", form)
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if loc := headers.Get("Location"); loc != tt.wantLocation {
+				t.Errorf("want Location %q; got %q", tt.wantLocation, loc)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+			// Whether or not there's an account, the answer is the same
+			if tt.wantLocation != "" {
+				_, _, body = ts.get(t, tt.wantLocation)
+				if !bytes.Contains(body, []byte("If there&#39;s an account for that address")) {
+					t.Errorf("want the flash message; got %s", body)
+				}
+			}
+
+			mail := sentMail(app)
+			if !tt.wantMail {
+				if mail != "" {
+					t.Errorf("want no email; got %q", mail)
+				}
+				return
+			}
+			for _, want := range []string{
+				"To: alice@example.com",
+				"Hi Alice",
+				"https://snippetbox.example.com/user/password/reset/valid-reset-token\n",
+			} {
+				if !strings.Contains(mail, want) {
+					t.Errorf("want the email to contain %q; got %q", want, mail)
+				}
+			}
+			if got := auditedActions(audit); len(got) != 1 || got[0] != "user.password_reset_requested user:1" {
+				t.Errorf("want the request audited; got %q", got)
+			}
+		})
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	app := newTestApplication(t)
+	users := app.users.(*mock.UserModel)
+	audit := app.auditEvents.(*mock.AuditModel)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, headers, body := ts.get(t, "/user/password/reset/valid-reset-token")
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	if !bytes.Contains(body, []byte("action='/user/password/reset/valid-reset-token'")) {
+		t.Errorf("want the form to post back to the link; got %s", body)
+	}
+	if rp := headers.Get("Referrer-Policy"); rp != "no-referrer" {
+		t.Errorf("want Referrer-Policy no-referrer; got %q", rp)
+	}
+	csrfToken := extractCSRFToken(t, body)
+
+	code, headers, _ = ts.get(t, "/user/password/reset/wrong-token")
+	if code != http.StatusSeeOther || headers.Get("Location") != "/user/password/forgot" {
+		t.Errorf("want %d to /user/password/forgot; got %d to %q", http.StatusSeeOther, code, headers.Get("Location"))
+	}
+
+	// ...and they might be logged in as her somewhere
+	sessions := app.userSessions.(*mock.SessionModel)
+	if _, err := sessions.Insert(1, "203.0.113.9", "Chrome on Windows", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// Alice was locked out by whoever forgot... or guessed her password
+	users.Failures = map[string]*mock.LoginFailures{
+		"alice@example.com": {Count: accountFreeFailures, Last: time.Now()},
+	}
+
+	tests := []struct {
+		name         string
+		token        string
+		password     string
+		wantCode     int
+		wantLocation string
+		wantBody     []byte
+		wantAudit    string
+	}{
+		{"Empty password", "valid-reset-token", "", http.StatusOK, "", []byte("This field cannot be blank"), ""},
+		{"Short password", "valid-reset-token", "pa$$word", http.StatusOK, "", []byte("This field is too short (minimum is 10 characters)"), ""},
+		{"Invalid token", "wrong-token", "newPa$$word123", http.StatusSeeOther, "/user/password/forgot", nil, ""},
+		{"Valid submission", "valid-reset-token", "newPa$$word123", http.StatusSeeOther, "/user/login", nil, "user.password_reset user:1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audit.Events = nil
+
+			form := url.Values{}
+			form.Add("password", tt.password)
+			form.Add("csrf_token", csrfToken)
+			code, headers, body := ts.postForm(t, "/user/password/reset/"+tt.token, form)
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if loc := headers.Get("Location"); loc != tt.wantLocation {
+				t.Errorf("want Location %q; got %q", tt.wantLocation, loc)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+			got := auditedActions(audit)
+			if tt.wantAudit == "" && len(got) > 0 {
+				t.Errorf("want nothing audited; got %q", got)
+			}
+			if tt.wantAudit != "" && (len(got) != 1 || got[0] != tt.wantAudit) {
+				t.Errorf("want %q audited; got %q", tt.wantAudit, got)
+			}
+		})
+	}
+
+	if _, ok := users.Failures["alice@example.com"]; ok {
+		t.Error("want the lockout lifted by the reset")
+	}
+	if len(sessions.Sessions) > 0 {
+		t.Errorf("want every session logged out by the reset; got %d", len(sessions.Sessions))
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"dvhthomas/snippetbox/pkg/forms"
+	"dvhthomas/snippetbox/pkg/models"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+func (app *application) profile(w http.ResponseWriter, r *http.Request) {
+	app.renderProfile(w, r, forms.New(nil))
+}
+
+// Show the user who they are and what they've written, along with the forms
+// to change their details. Only one of the forms is ever posted, so the
+// others are filled in with what's there now.
+func (app *application) renderProfile(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	u, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	snippets, err := app.snippets.ByAuthor(u.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if form.Values == nil {
+		form.Values = map[string][]string{}
+	}
+	if _, ok := form.Values["name"]; !ok {
+		form.Set("name", u.Name)
+	}
+	if _, ok := form.Values["email"]; !ok {
+		form.Set("email", u.Email)
+	}
+
+	app.render(w, r, "profile.page.tmpl", &templateData{
+		Form:     form,
+		User:     u,
+		Snippets: snippets,
+	})
+}
+
+func (app *application) changeName(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name")
+	form.MaxLength("name", 255)
+	if !form.Valid() {
+		app.renderProfile(w, r, form)
+		return
+	}
+
+	id := app.authenticatedUserID(r)
+	if err = app.users.SetName(id, form.Get("name")); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit(r, id, "user.name_change", fmt.Sprintf("user:%d", id))
+
+	app.session.Put(r, "flash", "Your name has been changed.")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// Changing the email address means verifying the new one, the same as
+// signing up. They stay logged in, but can't log in again until they have.
+// Like changing the password it needs the current one, because whoever has
+// the address can reset the password and take the account for good.
+func (app *application) changeEmail(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email", "password")
+	form.MaxLength("email", 255)
+	form.MatchesPattern("email", forms.EmailRX)
+	if !form.Valid() {
+		app.renderProfile(w, r, form)
+		return
+	}
+
+	u, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// A user who has just changed their address is logged in but not
+	// verified, and that's fine here, since the password was right
+	_, err = app.users.Authenticate(u.Email, form.Get("password"))
+	if errors.Is(err, models.ErrInvalidCredentials) {
+		form.Errors.Add("password", "Your current password is incorrect")
+		app.renderProfile(w, r, form)
+		return
+	}
+	if err != nil && !errors.Is(err, models.ErrUnverified) {
+		app.serverError(w, err)
+		return
+	}
+	if form.Get("email") == u.Email {
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+
+	err = app.users.SetEmail(u.ID, form.Get("email"))
+	if errors.Is(err, models.ErrDuplicateEmail) {
+		form.Errors.Add("email", "Address is already in use")
+		app.renderProfile(w, r, form)
+		return
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit(r, u.ID, "user.email_change", fmt.Sprintf("user:%d", u.ID))
+
+	changed := *u
+	changed.Email = form.Get("email")
+	if err = app.sendVerification(&changed); err != nil {
+		app.errorLog.Printf("verification for user:%d: %s", u.ID, err)
+	}
+
+	// Let the old address know too, in case it wasn't them
+	body := fmt.Sprintf(`Hi %s,
+
+The email address for your Snippetbox account has been changed to
+%s.
+
+If you didn't change it, please reset your password straight away at:
+
+%s/user/password/forgot
+`, u.Name, changed.Email, app.baseURL)
+	if err = app.mailer.Send(u.Email, "Your Snippetbox email address has changed", body); err != nil {
+		app.errorLog.Printf("email change notice for user:%d: %s", u.ID, err)
+	}
+
+	app.session.Put(r, "flash", "Please follow the link we've emailed to your new address to verify it.")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+func (app *application) changePassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("current_password", "new_password")
+	form.MinLength("new_password", 10)
+	if !form.Valid() {
+		app.renderProfile(w, r, form)
+		return
+	}
+
+	// Knowing the current password shows it's really them, and not
+	// somebody who found them logged in
+	id := app.authenticatedUserID(r)
+	err = app.users.ChangePassword(id, form.Get("current_password"), form.Get("new_password"))
+	if errors.Is(err, models.ErrInvalidCredentials) {
+		form.Errors.Add("current_password", "Your current password is incorrect")
+		app.renderProfile(w, r, form)
+		return
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit(r, id, "user.password_change", fmt.Sprintf("user:%d", id))
+
+	// Anybody else who knew the old password gets logged out. So does this
+	// browser, but it gets a new session straight away since it just proved
+	// who it is.
+	if err = app.userSessions.DeleteAll(id); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if err = app.startSession(r, id); err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Your password has been changed.")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"dvhthomas/snippetbox/pkg/mailer"
+	"dvhthomas/snippetbox/pkg/models/mock"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, headers, _ := ts.get(t, "/user/profile")
+	if code != http.StatusSeeOther || headers.Get("Location") != "/user/login" {
+		t.Fatalf("want %d to /user/login; got %d to %q", http.StatusSeeOther, code, headers.Get("Location"))
+	}
+
+	ts.login(t)
+	code, _, body := ts.get(t, "/user/profile")
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	for _, want := range []string{
+		"alice@example.com",
+		"<input type='text' name='name' value='Alice'>",
+		"An old silent pond",
+		// Their own snippets are all there, whoever else can see them
+		"Alice&#39;s secret",
+	} {
+		if !bytes.Contains(body, []byte(want)) {
+			t.Errorf("want body to contain %q", want)
+		}
+	}
+	if bytes.Contains(body, []byte("Over the wintry forest")) {
+		t.Error("want only their own snippets")
+	}
+	if bytes.Contains(body, []byte("not verified yet")) {
+		t.Error("want a verified address")
+	}
+}
+
+func TestChangeName(t *testing.T) {
+	app := newTestApplication(t)
+	users := app.users.(*mock.UserModel)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	csrfToken := ts.login(t)
+
+	tests := []struct {
+		name     string
+		userName string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Empty", "", http.StatusOK, []byte("This field cannot be blank")},
+		{"Too long", strings.Repeat("a", 256), http.StatusOK, []byte("This field is too long")},
+		{"Valid", "Alice Liddell", http.StatusSeeOther, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("name", tt.userName)
+			form.Add("csrf_token", csrfToken)
+			code, _, body := ts.postForm(t, "/user/profile/name", form)
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+
+	if users.Names[1] != "Alice Liddell" {
+		t.Errorf("want the name changed; got %q", users.Names[1])
+	}
+	_, _, body := ts.get(t, "/user/profile")
+	if !bytes.Contains(body, []byte("Your name has been changed")) {
+		t.Errorf("want to be told; got %s", body)
+	}
+}
+
+func TestChangeEmail(t *testing.T) {
+	app := newTestApplication(t)
+	users := app.users.(*mock.UserModel)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	csrfToken := ts.login(t)
+
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Empty", "", "", http.StatusOK, []byte("This field cannot be blank")},
+		{"Invalid", "alice@", "validPa$$word", http.StatusOK, []byte("This field is invalid")},
+		{"Wrong password", "alice@wonderland.example.com", "wrongPa$$word", http.StatusOK, []byte("Your current password is incorrect")},
+		{"Taken", "carol@example.com", "validPa$$word", http.StatusOK, []byte("Address is already in use")},
+		{"Unchanged", "alice@example.com", "validPa$$word", http.StatusSeeOther, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("email", tt.email)
+			form.Add("password", tt.password)
+			form.Add("csrf_token", csrfToken)
+			code, _, body := ts.postForm(t, "/user/profile/email", form)
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+			// The other forms still show what's there now
+			if code == http.StatusOK && !bytes.Contains(body, []byte("value='Alice'")) {
+				t.Error("want the name filled in")
+			}
+		})
+	}
+	if len(users.Emails) > 0 || sentMail(app) != "" {
+		t.Fatalf("want nothing changed yet; got %q", users.Emails)
+	}
+
+	// That's used up the hour's email budget, which new routes start afresh
+	ts2 := newTestServer(t, app.routes())
+	defer ts2.Close()
+	csrfToken = ts2.login(t)
+	ts = ts2
+
+	form := url.Values{}
+	form.Add("email", "alice@wonderland.example.com")
+	form.Add("password", "validPa$$word")
+	form.Add("csrf_token", csrfToken)
+	code, headers, _ := ts.postForm(t, "/user/profile/email", form)
+	if code != http.StatusSeeOther || headers.Get("Location") != "/user/profile" {
+		t.Fatalf("want %d to /user/profile; got %d to %q", http.StatusSeeOther, code, headers.Get("Location"))
+	}
+	if users.Emails[1] != "alice@wonderland.example.com" {
+		t.Errorf("want the address changed; got %q", users.Emails[1])
+	}
+
+	// The new address gets a link to verify it, and the old one a warning
+	mail := sentMail(app)
+	if !strings.Contains(mail, "To: alice@wonderland.example.com") || !strings.Contains(mail, "To: alice@example.com") {
+		t.Errorf("want emails to both addresses; got %s", mail)
+	}
+	_, _, body := ts.get(t, "/user/profile")
+	if !bytes.Contains(body, []byte("not verified yet")) {
+		t.Errorf("want the new address unverified; got %s", body)
+	}
+
+	// Following the link verifies it
+	link := regexp.MustCompile(`/user/verify/\S+`).FindString(mail)
+	app.mailer.(*mailer.Log).Out.(*bytes.Buffer).Reset()
+	if code, _, _ = ts.get(t, link); code != http.StatusSeeOther {
+		t.Errorf("want %d; got %d", http.StatusSeeOther, code)
+	}
+	if len(users.VerifiedIDs) != 1 || users.VerifiedIDs[0] != 1 {
+		t.Errorf("want Alice verified; got %v", users.VerifiedIDs)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	app := newTestApplication(t)
+	users := app.users.(*mock.UserModel)
+	audit := app.auditEvents.(*mock.AuditModel)
+	// Logged in somewhere else too
+	other := newTestServer(t, app.routes())
+	defer other.Close()
+	other.login(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	csrfToken := ts.login(t)
+
+	tests := []struct {
+		name            string
+		currentPassword string
+		newPassword     string
+		wantCode        int
+		wantBody        []byte
+	}{
+		{"Empty", "", "", http.StatusOK, []byte("This field cannot be blank")},
+		{"Short", "validPa$$word", "short", http.StatusOK, []byte("This field is too short")},
+		{"Wrong password", "wrongPa$$word", "newPa$$word123", http.StatusOK, []byte("Your current password is incorrect")},
+		{"Valid", "validPa$$word", "newPa$$word123", http.StatusSeeOther, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("current_password", tt.currentPassword)
+			form.Add("new_password", tt.newPassword)
+			form.Add("csrf_token", csrfToken)
+			code, _, body := ts.postForm(t, "/user/profile/password", form)
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+
+	if users.Passwords[1] != "newPa$$word123" {
+		t.Errorf("want the password changed; got %q", users.Passwords[1])
+	}
+	if got := auditedActions(audit); len(got) == 0 || got[len(got)-1] != "user.password_change user:1" {
+		t.Errorf("want it audited; got %q", got)
+	}
+
+	// Which logs out everywhere else, but not here
+	if code, _, _ := other.get(t, "/user/profile"); code != http.StatusSeeOther {
+		t.Errorf("want the other browser logged out; got %d", code)
+	}
+	if code, _, _ := ts.get(t, "/user/profile"); code != http.StatusOK {
+		t.Errorf("want this browser still logged in; got %d", code)
+	}
+}
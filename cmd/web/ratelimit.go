@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// budget is how many requests a client can make in a period. They can make
+// all of them at once, and then get them back a bit at a time over the
+// period.
+type budget struct {
+	requests int
+	per      time.Duration
+}
+
+// rateLimiter gives every client a token bucket holding their budget. Each
+// request takes a token, and tokens are put back at a steady rate until the
+// bucket is full again.
+type rateLimiter struct {
+	mu      sync.Mutex
+	budget  budget
+	buckets map[string]*bucket
+	// The time now, which the tests can change
+	now func() time.Time
+	// When idle buckets were last thrown away
+	swept time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(b budget) *rateLimiter {
+	return &rateLimiter{budget: b, buckets: map[string]*bucket{}, now: time.Now}
+}
+
+// How long it takes to get one token back
+func (l *rateLimiter) interval() time.Duration {
+	return l.budget.per / time.Duration(l.budget.requests)
+}
+
+// Take a token from key's bucket. It returns zero if there was one, and
+// otherwise how long until there will be.
+func (l *rateLimiter) take(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.budget.requests), last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens += float64(now.Sub(b.last)) / float64(l.interval())
+	if max := float64(l.budget.requests); b.tokens > max {
+		b.tokens = max
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) * float64(l.interval()))
+}
+
+// A bucket that hasn't been touched for a whole period has filled up again,
+// so it's no different from one that doesn't exist yet and can be thrown
+// away. That keeps memory down to the clients seen recently. It happens at
+// most once a minute, and the caller must hold the lock.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= l.budget.per {
+			delete(l.buckets, key)
+		}
+	}
+	l.swept = now
+}
+
+// Limit each client to the budget, sending 429 Too Many Requests once they've
+// used it up. Logged in users are counted by who they are, and everybody else
+// by their IP address, which only works once authenticate has run. Each call
+// makes a new limiter, so every route group wrapped by the same one shares a
+// budget.
+func (app *application) rateLimit(b budget) func(http.Handler) http.Handler {
+	return app.limitRequests(b, func(w http.ResponseWriter) {
+		app.clientError(w, http.StatusTooManyRequests)
+	})
+}
+
+// Just like rateLimit, but the error is JSON for the API
+func (app *application) apiRateLimit(b budget) func(http.Handler) http.Handler {
+	return app.limitRequests(b, func(w http.ResponseWriter) {
+		app.apiError(w, http.StatusTooManyRequests, "rate limit exceeded")
+	})
+}
+
+func (app *application) limitRequests(b budget, reject func(http.ResponseWriter)) func(http.Handler) http.Handler {
+	l := newRateLimiter(b)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := "ip:" + app.clientIP(r)
+			if id := app.authenticatedUserID(r); id != 0 {
+				key = fmt.Sprintf("user:%d", id)
+			}
+
+			if wait := l.take(key); wait > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				reject(w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Parse a comma-separated list of IP addresses and CIDR ranges, such as
+// "10.0.0.0/8,192.0.2.1"
+func parseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", p)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", p)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Report whether ip belongs to one of our own reverse proxies
+func (app *application) isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range app.trustedProxies {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
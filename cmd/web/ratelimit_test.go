@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2020, 12, 17, 10, 0, 0, 0, time.UTC)
+	l := newRateLimiter(budget{requests: 3, per: time.Minute})
+	l.now = func() time.Time { return now }
+
+	// The whole budget can be used at once
+	for i := 0; i < 3; i++ {
+		if wait := l.take("ip:192.0.2.1"); wait != 0 {
+			t.Fatalf("request %d: want it allowed; got a wait of %v", i+1, wait)
+		}
+	}
+	// Then a token comes back every 20 seconds
+	if wait := l.take("ip:192.0.2.1"); wait != 20*time.Second {
+		t.Errorf("want a wait of 20s; got %v", wait)
+	}
+	// Other clients have budgets of their own
+	if wait := l.take("ip:192.0.2.2"); wait != 0 {
+		t.Errorf("want another client allowed; got a wait of %v", wait)
+	}
+
+	now = now.Add(15 * time.Second)
+	if wait := l.take("ip:192.0.2.1"); wait != 5*time.Second {
+		t.Errorf("want a wait of 5s; got %v", wait)
+	}
+	now = now.Add(5 * time.Second)
+	if wait := l.take("ip:192.0.2.1"); wait != 0 {
+		t.Errorf("want a token back; got a wait of %v", wait)
+	}
+
+	// Buckets that have filled up again are thrown away
+	now = now.Add(time.Minute)
+	l.take("ip:192.0.2.3")
+	if len(l.buckets) != 1 {
+		t.Errorf("want only the new bucket kept; got %d buckets", len(l.buckets))
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.0.2.1,2001:db8::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &application{trustedProxies: proxies}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"Direct", "198.51.100.7:1234", nil, "198.51.100.7"},
+		{"Untrusted forwarder", "198.51.100.7:1234", []string{"203.0.113.9"}, "198.51.100.7"},
+		{"Trusted proxy", "10.1.2.3:1234", []string{"203.0.113.9"}, "203.0.113.9"},
+		{"Trusted address", "192.0.2.1:1234", []string{"203.0.113.9"}, "203.0.113.9"},
+		{"IPv6 proxy", "[2001:db8::1]:1234", []string{"203.0.113.9"}, "203.0.113.9"},
+		{"Chain of proxies", "10.1.2.3:1234", []string{"203.0.113.9, 10.4.5.6"}, "203.0.113.9"},
+		{"Spoofed by the client", "10.1.2.3:1234", []string{"1.1.1.1, 203.0.113.9"}, "203.0.113.9"},
+		{"Several headers", "10.1.2.3:1234", []string{"1.1.1.1", "203.0.113.9"}, "203.0.113.9"},
+		{"Only proxies", "10.1.2.3:1234", []string{"10.4.5.6"}, "10.4.5.6"},
+		{"Garbage", "10.1.2.3:1234", []string{"203.0.113.9, nonsense"}, "10.1.2.3"},
+		{"No header", "10.1.2.3:1234", nil, "10.1.2.3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, f := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", f)
+			}
+			if got := app.clientIP(r); got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "not-an-ip"} {
+		if _, err := parseTrustedProxies(bad); err == nil {
+			t.Errorf("%q: want an error", bad)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	app := &application{errorLog: log.New(ioutil.Discard, "", 0)}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+	limited := app.rateLimit(budget{requests: 1, per: time.Minute})(next)
+	apiLimited := app.apiRateLimit(budget{requests: 1, per: time.Minute})(next)
+
+	request := func(h http.Handler, userID int) *http.Response {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.RemoteAddr = "198.51.100.7:1234"
+		if userID != 0 {
+			r = r.WithContext(context.WithValue(r.Context(), contextKeyAuthenticatedUserID, userID))
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		return rr.Result()
+	}
+
+	if rs := request(limited, 0); rs.StatusCode != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, rs.StatusCode)
+	}
+	rs := request(limited, 0)
+	if rs.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("want %d; got %d", http.StatusTooManyRequests, rs.StatusCode)
+	}
+	if ra := rs.Header.Get("Retry-After"); ra != "60" {
+		t.Errorf("want Retry-After 60; got %q", ra)
+	}
+
+	// Users behind the same address are counted separately
+	if rs := request(limited, 1); rs.StatusCode != http.StatusOK {
+		t.Errorf("want %d for user 1; got %d", http.StatusOK, rs.StatusCode)
+	}
+	if rs := request(limited, 2); rs.StatusCode != http.StatusOK {
+		t.Errorf("want %d for user 2; got %d", http.StatusOK, rs.StatusCode)
+	}
+	if rs := request(limited, 1); rs.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("want %d for user 1 again; got %d", http.StatusTooManyRequests, rs.StatusCode)
+	}
+
+	// The API says so in JSON, and has a separate budget
+	request(apiLimited, 0)
+	rs = request(apiLimited, 0)
+	body, _ := ioutil.ReadAll(rs.Body)
+	if rs.StatusCode != http.StatusTooManyRequests || !strings.Contains(string(body), `"error":"rate limit exceeded"`) {
+		t.Errorf("want %d and a JSON error; got %d and %s", http.StatusTooManyRequests, rs.StatusCode, body)
+	}
+}
+
+func TestSignupRateLimit(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/signup")
+	form := url.Values{}
+	form.Add("name", "Bob")
+	form.Add("email", "bob@example.com")
+	form.Add("password", "validPa$$word")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	for i := 0; i < 10; i++ {
+		if code, _, _ := ts.postForm(t, "/user/signup", form); code != http.StatusSeeOther {
+			t.Fatalf("signup %d: want %d; got %d", i+1, http.StatusSeeOther, code)
+		}
+	}
+	code, headers, _ := ts.postForm(t, "/user/signup", form)
+	if code != http.StatusTooManyRequests {
+		t.Fatalf("want %d; got %d", http.StatusTooManyRequests, code)
+	}
+	if headers.Get("Retry-After") == "" {
+		t.Error("want a Retry-After header")
+	}
+
+	// Which doesn't stop anybody reading snippets
+	if code, _, _ := ts.get(t, "/"); code != http.StatusOK {
+		t.Errorf("want %d; got %d", http.StatusOK, code)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"time"
+)
+
+// How many expired snippets to delete with each statement
+const reapBatchSize = 1000
+
+// Expired snippets are hidden by every query, but the rows would stay in the
+// table forever if nothing removed them. reapSnippets deletes them every
+// interval until stop is closed. It's meant to be run in its own goroutine.
+func (app *application) reapSnippets(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n, err := app.reapExpiredSnippets(reapBatchSize, stop)
+			if err != nil {
+				app.errorLog.Printf("reaping expired snippets: %s", err)
+			}
+			if n > 0 {
+				app.infoLog.Printf("Deleted %d expired snippets", n)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Delete expired snippets a batch at a time until there are none left, or
+// we're asked to stop, and report how many went.
+func (app *application) reapExpiredSnippets(batch int, stop <-chan struct{}) (int, error) {
+	total := 0
+	for {
+		n, err := app.snippets.DeleteExpired(batch)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		// A short batch means that was the last of them
+		if n < batch {
+			return total, nil
+		}
+
+		select {
+		case <-stop:
+			return total, nil
+		default:
+		}
+	}
+}
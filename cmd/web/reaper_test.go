@@ -0,0 +1,75 @@
+package main
+
+import (
+	"dvhthomas/snippetbox/pkg/models/mock"
+	"testing"
+	"time"
+)
+
+func TestReapExpiredSnippets(t *testing.T) {
+	tests := []struct {
+		name    string
+		expired int
+		want    int
+	}{
+		{"Nothing to do", 0, 0},
+		{"Less than a batch", 7, 7},
+		{"Exactly one batch", 10, 10},
+		{"Several batches", 25, 25},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			snippets := &mock.SnippetModel{Expired: tt.expired}
+			app.snippets = snippets
+
+			n, err := app.reapExpiredSnippets(10, make(chan struct{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.want {
+				t.Errorf("want %d; got %d", tt.want, n)
+			}
+			if snippets.Expired != 0 {
+				t.Errorf("want no expired snippets left; got %d", snippets.Expired)
+			}
+		})
+	}
+
+	t.Run("Stopped between batches", func(t *testing.T) {
+		app := newTestApplication(t)
+		snippets := &mock.SnippetModel{Expired: 25}
+		app.snippets = snippets
+
+		stop := make(chan struct{})
+		close(stop)
+
+		n, err := app.reapExpiredSnippets(10, stop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 10 {
+			t.Errorf("want %d; got %d", 10, n)
+		}
+	})
+}
+
+func TestReapSnippetsStops(t *testing.T) {
+	app := newTestApplication(t)
+	app.snippets = &mock.SnippetModel{Expired: 3}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		app.reapSnippets(time.Millisecond, stop)
+		close(done)
+	}()
+
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("reaper didn't stop")
+	}
+}
@@ -1,14 +1,28 @@
 package main
 
 import (
+	"dvhthomas/snippetbox/pkg/models"
 	"net/http"
+	"time"
 
 	"github.com/bmizerany/pat"
 	"github.com/justinas/alice"
 )
 
 func (app *application) routes() http.Handler {
-	standardMiddleware := alice.New(app.recoverPanic, app.logRequest, secureHeaders)
+	// How many requests each client can make. Everything shares a generous
+	// budget that's only there to stop floods. It comes before sessions, so
+	// it can only tell clients apart by IP address. The things worth spamming
+	// get a much smaller budget of their own on top, counted per user once
+	// somebody has logged in.
+	everything := app.rateLimit(budget{requests: 300, per: time.Minute})
+	signups := app.rateLimit(budget{requests: 10, per: time.Hour})
+	logins := app.rateLimit(budget{requests: 20, per: time.Minute})
+	emails := app.rateLimit(budget{requests: 5, per: time.Hour})
+	writes := app.rateLimit(budget{requests: 30, per: time.Minute})
+	apiWrites := app.apiRateLimit(budget{requests: 60, per: time.Minute})
+
+	standardMiddleware := alice.New(app.recoverPanic, app.logRequest, secureHeaders, everything)
 	// All dynamic routes will have a session cookie courtesy of golangcollege,
 	// and a CSRF cookie courtesy of noSurf. Then we add a context value to
 	// show whether the user session includes an authenticated user.
@@ -17,6 +31,8 @@ func (app *application) routes() http.Handler {
 	mux := pat.New()
 	// We're adding the session middleware to all the routes...
 	mux.Get("/", dynamicMiddleware.ThenFunc(app.home))
+	mux.Get("/snippets", dynamicMiddleware.ThenFunc(app.listSnippets))
+	mux.Get("/search", dynamicMiddleware.ThenFunc(app.searchSnippets))
 
 	// Add the authentication middleware. The Append method makes the requireAuthentication
 	// call the final one in the chain (see https://godoc.org/github.com/justinas/alice#Chain.Append)
@@ -24,25 +40,146 @@ func (app *application) routes() http.Handler {
 		Append(app.requireAuthentication).
 		ThenFunc(app.createSnippetForm))
 	mux.Post("/snippet/create", dynamicMiddleware.
-		Append(app.requireAuthentication).
+		Append(app.requireAuthentication, writes).
 		ThenFunc(app.createSnippet))
 	// This actually matches '/snippet/create' but would assign the value
 	// 'create' to the id variable. Which isn't really what we want since there's
 	// no snippet with the id 'create'. So put this _after_ the '/snippet/create'
 	// pattern in our code.
+	// Snippets are shown at their slug. The numeric address redirects there
+	// for the sake of old links.
 	mux.Get("/snippet/:id", dynamicMiddleware.ThenFunc(app.showSnippet))
+	mux.Get("/s/:slug", dynamicMiddleware.ThenFunc(app.showSnippetBySlug))
+
+	// Only the author can change a snippet, but the handlers check that
+	// because it depends on the snippet. The middleware just makes sure
+	// somebody is logged in.
+	mux.Get("/snippet/:id/edit", dynamicMiddleware.
+		Append(app.requireAuthentication).
+		ThenFunc(app.editSnippetForm))
+	mux.Post("/snippet/:id/edit", dynamicMiddleware.
+		Append(app.requireAuthentication, writes).
+		ThenFunc(app.editSnippet))
+	mux.Post("/snippet/:id/delete", dynamicMiddleware.
+		Append(app.requireAuthentication, writes).
+		ThenFunc(app.deleteSnippet))
 
 	// User-related routes
 	mux.Get("/user/signup", dynamicMiddleware.ThenFunc(app.signupUserForm))
-	mux.Post("/user/signup", dynamicMiddleware.ThenFunc(app.signupUser))
+	mux.Post("/user/signup", dynamicMiddleware.Append(signups).ThenFunc(app.signupUser))
 	mux.Get("/user/login", dynamicMiddleware.ThenFunc(app.loginUserForm))
-	mux.Post("/user/login", dynamicMiddleware.ThenFunc(app.loginUser))
+	mux.Post("/user/login", dynamicMiddleware.Append(logins).ThenFunc(app.loginUser))
+	// The second step of logging in for users with two-factor authentication.
+	// Nobody is logged in yet, so it can't need authentication.
+	mux.Get("/user/login/2fa", dynamicMiddleware.ThenFunc(app.twoFactorLoginForm))
+	mux.Post("/user/login/2fa", dynamicMiddleware.Append(logins).ThenFunc(app.twoFactorLogin))
+	// Logging in with the identity provider, which sends users back to the
+	// callback. It's a GET because it comes from another site, so the state
+	// in the session is what stops forged logins rather than the CSRF token.
+	mux.Get("/user/login/oidc", dynamicMiddleware.Append(logins).ThenFunc(app.oidcLogin))
+	mux.Get("/user/login/oidc/callback", dynamicMiddleware.Append(logins).ThenFunc(app.oidcCallback))
+
+	// Forgotten passwords. Asking for a link sends an email, so it has a
+	// budget of its own.
+	mux.Get("/user/password/forgot", dynamicMiddleware.ThenFunc(app.forgotPasswordForm))
+	mux.Post("/user/password/forgot", dynamicMiddleware.Append(emails).ThenFunc(app.forgotPassword))
+	mux.Get("/user/password/reset/:token", dynamicMiddleware.ThenFunc(app.resetPasswordForm))
+	mux.Post("/user/password/reset/:token", dynamicMiddleware.Append(logins).ThenFunc(app.resetPassword))
+
+	// New users follow the emailed link to verify their address, and can ask
+	// for another one if it went astray
+	mux.Get("/user/verify", dynamicMiddleware.ThenFunc(app.resendVerificationForm))
+	mux.Post("/user/verify", dynamicMiddleware.Append(emails).ThenFunc(app.resendVerification))
+	mux.Get("/user/verify/:token", dynamicMiddleware.ThenFunc(app.verifyUser))
+
+	// Users see and change their own details. A new email address gets a
+	// verification email, and guessing the current password to change it
+	// is held back like guessing it to log in.
+	mux.Get("/user/profile", dynamicMiddleware.
+		Append(app.requireAuthentication).
+		ThenFunc(app.profile))
+	mux.Post("/user/profile/name", dynamicMiddleware.
+		Append(app.requireAuthentication, writes).
+		ThenFunc(app.changeName))
+	mux.Post("/user/profile/email", dynamicMiddleware.
+		Append(app.requireAuthentication, emails).
+		ThenFunc(app.changeEmail))
+	mux.Post("/user/profile/password", dynamicMiddleware.
+		Append(app.requireAuthentication, logins).
+		ThenFunc(app.changePassword))
+
+	// Users see the browsers they're logged in on, and log any or all of
+	// them out
+	mux.Get("/user/sessions", dynamicMiddleware.
+		Append(app.requireAuthentication).
+		ThenFunc(app.listSessions))
+	mux.Post("/user/sessions/revoke-all", dynamicMiddleware.
+		Append(app.requireAuthentication).
+		ThenFunc(app.revokeAllSessions))
+	mux.Post("/user/sessions/:id/revoke", dynamicMiddleware.
+		Append(app.requireAuthentication).
+		ThenFunc(app.revokeSession))
+
+	// Users manage their own API tokens from a normal logged in session
+	mux.Get("/user/tokens", dynamicMiddleware.
+		Append(app.requireAuthentication).
+		ThenFunc(app.listTokens))
+	mux.Post("/user/tokens", dynamicMiddleware.
+		Append(app.requireAuthentication, writes).
+		ThenFunc(app.createToken))
+	mux.Post("/user/tokens/:id/revoke", dynamicMiddleware.
+		Append(app.requireAuthentication).
+		ThenFunc(app.revokeToken))
+
+	// Users turn two-factor authentication on and off for themselves
+	mux.Get("/user/2fa", dynamicMiddleware.
+		Append(app.requireAuthentication).
+		ThenFunc(app.twoFactorSettings))
+	mux.Post("/user/2fa/enable", dynamicMiddleware.
+		Append(app.requireAuthentication, writes).
+		ThenFunc(app.enableTwoFactor))
+	mux.Post("/user/2fa/disable", dynamicMiddleware.
+		Append(app.requireAuthentication, writes).
+		ThenFunc(app.disableTwoFactor))
+	mux.Post("/user/2fa/recovery-codes", dynamicMiddleware.
+		Append(app.requireAuthentication, writes).
+		ThenFunc(app.replaceRecoveryCodes))
 
 	// Don't want unauthenticated users getting to the logout page
 	mux.Post("/user/logout", dynamicMiddleware.
 		Append(app.requireAuthentication).
 		ThenFunc(app.logoutUser))
 
+	// The admin console is only for admins, and anybody who isn't logged in
+	// is sent to log in first.
+	adminMiddleware := dynamicMiddleware.Append(app.requireAuthentication, app.requireRole(models.RoleAdmin))
+	mux.Get("/admin", http.RedirectHandler("/admin/users", http.StatusSeeOther))
+	mux.Get("/admin/users", adminMiddleware.ThenFunc(app.adminUsers))
+	mux.Post("/admin/users/:id/deactivate", adminMiddleware.ThenFunc(app.adminDeactivateUser))
+	mux.Post("/admin/users/:id/reactivate", adminMiddleware.ThenFunc(app.adminReactivateUser))
+	mux.Get("/admin/snippets", adminMiddleware.ThenFunc(app.adminSnippets))
+	mux.Post("/admin/snippets/:id/delete", adminMiddleware.ThenFunc(app.adminDeleteSnippet))
+	mux.Get("/admin/audit", adminMiddleware.ThenFunc(app.adminAudit))
+	mux.Get("/admin/audit.csv", adminMiddleware.ThenFunc(app.adminAuditCSV))
+
+	// The JSON API shares the session cookie with the HTML pages, so it needs
+	// the same CSRF protection. The difference is that every error, including
+	// a failed CSRF check or a missing login, is reported as JSON. Scripts can
+	// use an API token instead of a session, and authenticateToken has to come
+	// first so that apiNoSurf knows to let those requests through.
+	apiMiddleware := alice.New(app.session.Enable, app.authenticateToken, app.apiNoSurf, app.authenticate)
+	mux.Get("/api/v1/snippets", apiMiddleware.ThenFunc(app.apiListSnippets))
+	mux.Post("/api/v1/snippets", apiMiddleware.
+		Append(app.requireAPIAuthentication, apiWrites).
+		ThenFunc(app.apiCreateSnippet))
+	mux.Get("/api/v1/snippets/:id", apiMiddleware.ThenFunc(app.apiShowSnippet))
+	mux.Put("/api/v1/snippets/:id", apiMiddleware.
+		Append(app.requireAPIAuthentication, apiWrites).
+		ThenFunc(app.apiUpdateSnippet))
+	mux.Del("/api/v1/snippets/:id", apiMiddleware.
+		Append(app.requireAPIAuthentication, apiWrites).
+		ThenFunc(app.apiDeleteSnippet))
+
 	mux.Get("/ping", http.HandlerFunc(ping))
 
 	fileServer := http.FileServer(http.Dir("./ui/static"))
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Run the server until either it fails or a signal arrives on quit. On a
+// signal we stop accepting connections and give the requests in flight up to
+// drain to finish. listen is what actually starts srv, which lets the tests
+// use a plain listener rather than TLS.
+func (app *application) serve(srv *http.Server, listen func() error, quit <-chan os.Signal, drain time.Duration) error {
+	// The server blocks, so run it in the background. The buffer means it
+	// can always hand back its error, even if we've stopped listening.
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- listen()
+	}()
+
+	select {
+	case err := <-serverErr:
+		// Usually the address is in use or the certificates are missing
+		return err
+	case sig := <-quit:
+		app.infoLog.Printf("Caught %s, draining requests for up to %s", sig, drain)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		return err
+	}
+
+	// Once Shutdown returns, listen has returned ErrServerClosed, which is
+	// what we'd expect rather than a problem.
+	if err := <-serverErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestServeDrainsRequests(t *testing.T) {
+	app := newTestApplication(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A slow handler that tells us when it has started
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("OK"))
+	})}
+
+	quit := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- app.serve(srv, func() error { return srv.Serve(ln) }, quit, time.Second)
+	}()
+
+	// The request is in flight when the signal arrives, and should still
+	// get its answer.
+	code := make(chan int, 1)
+	go func() {
+		rs, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			code <- 0
+			return
+		}
+		rs.Body.Close()
+		code <- rs.StatusCode
+	}()
+
+	<-started
+	quit <- syscall.SIGTERM
+
+	if got := <-code; got != http.StatusOK {
+		t.Errorf("want %d; got %d", http.StatusOK, got)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("want no error; got %s", err)
+	}
+}
+
+func TestServeDrainTimeout(t *testing.T) {
+	app := newTestApplication(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// This one takes far longer than we're prepared to wait
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+
+	quit := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- app.serve(srv, func() error { return srv.Serve(ln) }, quit, 10*time.Millisecond)
+	}()
+
+	go http.Get("http://" + ln.Addr().String())
+
+	<-started
+	quit <- syscall.SIGINT
+
+	if err := <-served; err != context.DeadlineExceeded {
+		t.Errorf("want %v; got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestServeListenError(t *testing.T) {
+	app := newTestApplication(t)
+
+	srv := &http.Server{}
+	err := app.serve(srv, func() error { return syscall.EADDRINUSE }, make(chan os.Signal), time.Second)
+	if err != syscall.EADDRINUSE {
+		t.Errorf("want %v; got %v", syscall.EADDRINUSE, err)
+	}
+}
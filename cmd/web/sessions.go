@@ -0,0 +1,80 @@
+package main
+
+import (
+	"dvhthomas/snippetbox/pkg/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Start a server-side session for the browser making the request and put its
+// token in the session cookie. It lasts as long as the cookie does.
+func (app *application) startSession(r *http.Request, userID int) error {
+	token, err := app.userSessions.Insert(userID, app.clientIP(r), truncate(r.UserAgent(), 255), app.session.Lifetime)
+	if err != nil {
+		return err
+	}
+	app.session.Put(r, "sessionToken", token)
+	return nil
+}
+
+// Show the user every browser they're logged in on, so that they can log
+// out of the ones they don't recognise or have left logged in somewhere
+func (app *application) listSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := app.userSessions.List(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "sessions.page.tmpl", &templateData{
+		Sessions:  sessions,
+		SessionID: app.sessionID(r),
+	})
+}
+
+func (app *application) revokeSession(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	userID := app.authenticatedUserID(r)
+	err = app.userSessions.Delete(userID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+	app.audit(r, userID, "user.session_revoke", fmt.Sprintf("session:%d", id))
+
+	// Revoking the one they're using is just logging out
+	if id == app.sessionID(r) {
+		app.session.Remove(r, "sessionToken")
+		app.session.Put(r, "flash", "You've been logged out successfully!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	app.session.Put(r, "flash", "That session has been logged out.")
+	http.Redirect(w, r, "/user/sessions", http.StatusSeeOther)
+}
+
+// Log out everywhere, this browser included
+func (app *application) revokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	id := app.authenticatedUserID(r)
+	if err := app.userSessions.DeleteAll(id); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit(r, id, "user.session_revoke_all", fmt.Sprintf("user:%d", id))
+
+	app.session.Remove(r, "sessionToken")
+	app.session.Put(r, "flash", "You've been logged out everywhere.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"dvhthomas/snippetbox/pkg/models/mock"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestListSessions(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, headers, _ := ts.get(t, "/user/sessions")
+	if code != http.StatusSeeOther || headers.Get("Location") != "/user/login" {
+		t.Fatalf("want %d to /user/login; got %d to %q", http.StatusSeeOther, code, headers.Get("Location"))
+	}
+
+	// Alice logs in on two browsers, and Carol on another
+	other := newTestServer(t, app.routes())
+	defer other.Close()
+	other.login(t)
+	carol := newTestServer(t, app.routes())
+	defer carol.Close()
+	carol.loginAs(t, "carol@example.com")
+	ts.login(t)
+
+	code, _, body := ts.get(t, "/user/sessions")
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	for _, want := range []string{
+		"action='/user/sessions/1/revoke'",
+		"action='/user/sessions/3/revoke'",
+		"127.0.0.1",
+		"Go-http-client/1.1 (this browser)",
+	} {
+		if !bytes.Contains(body, []byte(want)) {
+			t.Errorf("want body to contain %q", want)
+		}
+	}
+	if n := bytes.Count(body, []byte("(this browser)")); n != 1 {
+		t.Errorf("want one session marked as this one; got %d", n)
+	}
+	if bytes.Contains(body, []byte("action='/user/sessions/2/revoke'")) {
+		t.Error("want only Alice's own sessions")
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	app := newTestApplication(t)
+	audit := app.auditEvents.(*mock.AuditModel)
+	other := newTestServer(t, app.routes())
+	defer other.Close()
+	other.login(t)
+	carol := newTestServer(t, app.routes())
+	defer carol.Close()
+	carol.loginAs(t, "carol@example.com")
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	csrfToken := ts.login(t)
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		wantCode     int
+		wantLocation string
+	}{
+		{"Not a number", "/user/sessions/foo/revoke", http.StatusNotFound, ""},
+		{"Somebody else's", "/user/sessions/2/revoke", http.StatusNotFound, ""},
+		{"Another browser", "/user/sessions/1/revoke", http.StatusSeeOther, "/user/sessions"},
+		{"Already revoked", "/user/sessions/1/revoke", http.StatusNotFound, ""},
+		{"This browser", "/user/sessions/3/revoke", http.StatusSeeOther, "/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+			code, headers, _ := ts.postForm(t, tt.urlPath, form)
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if loc := headers.Get("Location"); loc != tt.wantLocation {
+				t.Errorf("want Location %q; got %q", tt.wantLocation, loc)
+			}
+		})
+	}
+
+	// Both of Alice's browsers are logged out, and Carol isn't
+	for _, s := range []*testServer{other, ts} {
+		if code, _, _ := s.get(t, "/snippet/create"); code != http.StatusSeeOther {
+			t.Errorf("want to be logged out; got %d", code)
+		}
+	}
+	if code, _, _ := carol.get(t, "/snippet/create"); code != http.StatusOK {
+		t.Errorf("want Carol still logged in; got %d", code)
+	}
+
+	got := auditedActions(audit)
+	if len(got) < 2 || got[len(got)-2] != "user.session_revoke session:1" || got[len(got)-1] != "user.session_revoke session:3" {
+		t.Errorf("want the revocations audited; got %q", got)
+	}
+}
+
+func TestRevokeAllSessions(t *testing.T) {
+	app := newTestApplication(t)
+	sessions := app.userSessions.(*mock.SessionModel)
+	other := newTestServer(t, app.routes())
+	defer other.Close()
+	other.login(t)
+	carol := newTestServer(t, app.routes())
+	defer carol.Close()
+	carol.loginAs(t, "carol@example.com")
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	csrfToken := ts.login(t)
+
+	form := url.Values{}
+	form.Add("csrf_token", csrfToken)
+	code, headers, _ := ts.postForm(t, "/user/sessions/revoke-all", form)
+	if code != http.StatusSeeOther || headers.Get("Location") != "/" {
+		t.Fatalf("want %d to /; got %d to %q", http.StatusSeeOther, code, headers.Get("Location"))
+	}
+
+	for _, s := range []*testServer{other, ts} {
+		if code, _, _ := s.get(t, "/snippet/create"); code != http.StatusSeeOther {
+			t.Errorf("want to be logged out; got %d", code)
+		}
+	}
+	if len(sessions.Sessions) != 1 {
+		t.Errorf("want only Carol's session left; got %d", len(sessions.Sessions))
+	}
+	_, _, body := ts.get(t, "/")
+	if !bytes.Contains(body, []byte("You&#39;ve been logged out everywhere")) {
+		t.Errorf("want to be told; got %s", body)
+	}
+}
+
+func TestLogoutEndsSession(t *testing.T) {
+	app := newTestApplication(t)
+	sessions := app.userSessions.(*mock.SessionModel)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	csrfToken := ts.login(t)
+
+	if len(sessions.Sessions) != 1 {
+		t.Fatalf("want a session for the login; got %d", len(sessions.Sessions))
+	}
+	form := url.Values{}
+	form.Add("csrf_token", csrfToken)
+	ts.postForm(t, "/user/logout", form)
+	if len(sessions.Sessions) != 0 {
+		t.Errorf("want the session ended; got %d", len(sessions.Sessions))
+	}
+}
@@ -6,18 +6,59 @@ import (
 	"html/template"
 	"net/url"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
 )
 
 type templateData struct {
-	Flash           string
-	CurrentYear     int
-	Snippet         *models.Snippet
-	Snippets        []*models.Snippet
-	FormData        url.Values
-	Form            *forms.Form
-	IsAuthenticated bool
-	CSRFToken       string
+	Flash               string
+	CurrentYear         int
+	Snippet             *models.Snippet
+	Snippets            []*models.Snippet
+	FormData            url.Values
+	Form                *forms.Form
+	IsAuthenticated     bool
+	AuthenticatedUserID int
+	IsAdmin             bool
+	CSRFToken           string
+	// Opaque cursors for the neighbouring pages of a paginated list. They
+	// are empty when there's no page in that direction.
+	NextCursor string
+	PrevCursor string
+	// Search terms and the neighbouring page numbers of the results. A page
+	// number of zero means there's no page in that direction.
+	Query    string
+	NextPage int
+	PrevPage int
+	// The user's API tokens, plus a newly created one which can only be
+	// shown this one time.
+	APITokens   []*models.APIToken
+	NewAPIToken string
+	// How long somebody has to wait before they can try logging in again
+	RetryAfter time.Duration
+	// The token from a password reset link, which the form posts back
+	ResetToken string
+	// Two-factor authentication. Users setting it up get a QR code of the
+	// new secret for their app, and the secret itself for typing in by
+	// hand. Users who have it get told how many recovery codes they have
+	// left, plus any new ones, which can only be shown this one time.
+	TwoFactorEnabled  bool
+	TOTPQRCode        template.URL
+	TOTPSecret        string
+	RecoveryCodes     []string
+	RecoveryCodesLeft int
+	// What to call the identity provider that users can log in with, or
+	// empty if there isn't one
+	OIDCName string
+	// The logged in user, for their profile page
+	User *models.User
+	// The browsers the user is logged in on, and which one is this
+	Sessions  []*models.Session
+	SessionID int
+	// Everybody, and what they've been up to, for the admin console
+	Users       []*models.User
+	AuditEvents []*models.AuditEvent
 }
 
 func humanDate(t time.Time) string {
@@ -30,8 +71,102 @@ func humanDate(t time.Time) string {
 	return t.UTC().Format("02 Jan 2006 at 15:04")
 }
 
+// Wrap every case-insensitive occurrence of the words in query with <mark>
+// tags. The text is HTML escaped along the way, which is what makes it safe
+// to hand back as template.HTML.
+func highlight(text, query string) template.HTML {
+	terms := strings.Fields(query)
+	if len(terms) == 0 {
+		return template.HTML(template.HTMLEscapeString(text))
+	}
+
+	for i, term := range terms {
+		terms[i] = regexp.QuoteMeta(term)
+	}
+	rx := regexp.MustCompile("(?i)" + strings.Join(terms, "|"))
+
+	var b strings.Builder
+	last := 0
+	for _, m := range rx.FindAllStringIndex(text, -1) {
+		b.WriteString(template.HTMLEscapeString(text[last:m[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(template.HTMLEscapeString(text[m[0]:m[1]]))
+		b.WriteString("</mark>")
+		last = m[1]
+	}
+	b.WriteString(template.HTMLEscapeString(text[last:]))
+	return template.HTML(b.String())
+}
+
+// Report whether the snippet has expired, which only the admin console shows
+func expired(s *models.Snippet) bool {
+	return !s.Expires.After(time.Now())
+}
+
+// Describe the browser a session was started in, like "Firefox on Linux",
+// from its User-Agent header. Anything we don't recognise is shown as it is.
+func device(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	// Order matters, because most browsers claim to be several others too
+	browser := ""
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"CriOS/", "Chrome"},
+		{"Safari/", "Safari"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	system := ""
+	for _, o := range []struct{ token, name string }{
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, o.token) {
+			system = o.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	}
+	return userAgent
+}
+
+// Round a wait up to whole minutes, so that it's never less than one
+func minutes(d time.Duration) int {
+	return int((d + time.Minute - 1) / time.Minute)
+}
+
 var functions = template.FuncMap{
-	"humanDate": humanDate,
+	"device":        device,
+	"expired":       expired,
+	"minutes":       minutes,
+	"humanDate":     humanDate,
+	"highlight":     highlight,
+	"highlightCode": highlightCode,
+	"languageName":  languageName,
+	"languages":     func() []language { return languages },
+	"snippetURL":    snippetURL,
 }
 
 func newTemplateCache(dir string) (map[string]*template.Template, error) {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"html/template"
 	"testing"
 	"time"
 )
@@ -39,3 +40,82 @@ func TestHumanDate(t *testing.T) {
 		})
 	}
 }
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		query string
+		want  template.HTML
+	}{
+		{
+			name:  "Single term",
+			text:  "An old silent pond",
+			query: "pond",
+			want:  "An old silent <mark>pond</mark>",
+		},
+		{
+			name:  "Ignores case",
+			text:  "Nginx config",
+			query: "NGINX",
+			want:  "<mark>Nginx</mark> config",
+		},
+		{
+			name:  "Several terms",
+			text:  "server listen 80",
+			query: "listen server",
+			want:  "<mark>server</mark> <mark>listen</mark> 80",
+		},
+		{
+			name:  "Escapes text",
+			text:  "<b>bold</b>",
+			query: "bold",
+			want:  "&lt;b&gt;<mark>bold</mark>&lt;/b&gt;",
+		},
+		{
+			name:  "Regexp characters in query",
+			text:  "a.b and axb",
+			query: "a.b",
+			want:  "<mark>a.b</mark> and axb",
+		},
+		{
+			name:  "Empty query",
+			text:  "<pond>",
+			query: " ",
+			want:  "&lt;pond&gt;",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := highlight(tt.text, tt.query)
+			if got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDevice(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		want      string
+	}{
+		{"Firefox", "Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/119.0", "Firefox on Linux"},
+		{"Chrome", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Safari/537.36", "Chrome on Windows"},
+		{"Edge", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Safari/537.36 Edg/119.0.0.0", "Edge on Windows"},
+		{"Safari", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1", "Safari on iPhone"},
+		{"Android", "Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Mobile Safari/537.36", "Chrome on Android"},
+		{"Unknown", "curl/8.4.0", "curl/8.4.0"},
+		{"Empty", "", "Unknown device"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := device(tt.userAgent); got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"dvhthomas/snippetbox/pkg/mailer"
 	"dvhthomas/snippetbox/pkg/models/mock"
 	"html"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -45,12 +48,24 @@ func newTestApplication(t *testing.T) *application {
 	session.Secure = true
 
 	return &application{
-		errorLog:      log.New(ioutil.Discard, "", 0),
-		infoLog:       log.New(ioutil.Discard, "", 0),
-		session:       session,
-		snippets:      &mock.SnippetModel{},
-		users:         &mock.UserModel{},
-		templateCache: templateCache,
+		errorLog:       log.New(ioutil.Discard, "", 0),
+		infoLog:        log.New(ioutil.Discard, "", 0),
+		session:        session,
+		snippets:       &mock.SnippetModel{},
+		users:          &mock.UserModel{},
+		tokens:         &mock.TokenModel{},
+		auditEvents:    &mock.AuditModel{},
+		passwordResets: &mock.PasswordResetModel{},
+		recoveryCodes:  &mock.RecoveryCodeModel{},
+		identities:     &mock.IdentityModel{},
+		userSessions:   &mock.SessionModel{},
+		loginThrottle:  newThrottle(),
+		templateCache:  templateCache,
+		// Emails are kept in a buffer that the tests can read
+		mailer:  &mailer.Log{Out: &bytes.Buffer{}, From: "no-reply@example.com"},
+		baseURL: "https://snippetbox.example.com",
+		secret:  []byte("s6Ndh+pPbnzHbS*+9Pk8qGWhTzbpa@ge"),
+		now:     time.Now,
 	}
 }
 
@@ -118,3 +133,56 @@ func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (i
 
 	return rs.StatusCode, rs.Header, body
 }
+
+// Log in as the mock user so that routes behind requireAuthentication can be
+// tested. The CSRF token is returned because any subsequent POST needs it.
+func (ts *testServer) login(t *testing.T) string {
+	return ts.loginAs(t, "alice@example.com")
+}
+
+// Log in as one of the other mock users, like the admin carol@example.com
+func (ts *testServer) loginAs(t *testing.T, email string) string {
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+
+	form := url.Values{}
+	form.Add("email", email)
+	form.Add("password", "validPa$$word")
+	form.Add("csrf_token", csrfToken)
+
+	code, _, _ := ts.postForm(t, "/user/login", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("login: want %d; got %d", http.StatusSeeOther, code)
+	}
+	return csrfToken
+}
+
+// Make a request with any method, body and headers. The API needs this since
+// it uses PUT and DELETE as well as JSON bodies.
+func (ts *testServer) do(t *testing.T, method, urlPath string, body io.Reader, header http.Header) (int, http.Header, []byte) {
+	req, err := http.NewRequest(method, ts.URL+urlPath, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rs.Body.Close()
+	resBody, err := ioutil.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rs.StatusCode, rs.Header, resBody
+}
+
+// Everything the application has emailed so far
+func sentMail(app *application) string {
+	return app.mailer.(*mailer.Log).Out.(*bytes.Buffer).String()
+}
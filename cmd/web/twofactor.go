@@ -0,0 +1,489 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"dvhthomas/snippetbox/pkg/forms"
+	"dvhthomas/snippetbox/pkg/models"
+	"dvhthomas/snippetbox/pkg/totp"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// How long somebody who got their password right has to type in the code
+// from their authenticator app
+const twoFactorLoginTimeout = 5 * time.Minute
+
+// errInvalidCode is returned for a two-factor code that's wrong, too old or
+// has been used already
+var errInvalidCode = errors.New("invalid two-factor code")
+
+// Secrets are encrypted with a key derived from -secret before they're
+// stored, so that a copy of the database isn't enough to make codes. The
+// user's ID goes along as additional data, which stops one user's secret
+// being copied over to somebody else.
+func (app *application) sealTOTPSecret(userID int, secret []byte) ([]byte, error) {
+	aead, err := app.totpCipher()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	// The nonce goes at the front so that it can be found again
+	return aead.Seal(nonce, nonce, secret, []byte(strconv.Itoa(userID))), nil
+}
+
+func (app *application) openTOTPSecret(u *models.User) ([]byte, error) {
+	aead, err := app.totpCipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(u.TOTPSecret) < aead.NonceSize() {
+		return nil, fmt.Errorf("two-factor secret for user:%d is too short", u.ID)
+	}
+	nonce, sealed := u.TOTPSecret[:aead.NonceSize()], u.TOTPSecret[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, []byte(strconv.Itoa(u.ID)))
+}
+
+func (app *application) totpCipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(app.key("totp secret"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Check a code that a user with two-factor authentication typed in. It can
+// be the one their authenticator app is showing, or one of their recovery
+// codes, which is used up. It reports which it was, or returns
+// errInvalidCode if it was neither.
+func (app *application) checkTwoFactorCode(u *models.User, code string) (recovery bool, err error) {
+	// Apps show the code in two halves, and people copy it like that
+	code = strings.Join(strings.Fields(code), "")
+
+	if len(code) == totp.Digits && strings.Trim(code, "0123456789") == "" {
+		secret, err := app.openTOTPSecret(u)
+		if err != nil {
+			return false, err
+		}
+		step, ok := totp.Validate(secret, code, app.now())
+		if !ok {
+			return false, errInvalidCode
+		}
+		// Somebody looking over their shoulder can't use it again
+		err = app.users.UseTOTPStep(u.ID, step)
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			return false, errInvalidCode
+		}
+		return false, err
+	}
+
+	err = app.recoveryCodes.Consume(u.ID, code)
+	if errors.Is(err, models.ErrInvalidCredentials) {
+		return false, errInvalidCode
+	}
+	return err == nil, err
+}
+
+// Finish logging somebody in once they've proved who they are
+func (app *application) finishLogin(w http.ResponseWriter, r *http.Request, u *models.User) {
+	// Start counting from nothing again
+	if err := app.users.ResetLoginFailures(failureKey(u.Email)); err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Start a session for this browser, so that they are now 'logged in'
+	if err := app.startSession(r, u.ID); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit(r, u.ID, "user.login", fmt.Sprintf("user:%d", u.ID))
+
+	// Redirect the user to the create snippet page.
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+}
+
+// Put a user who got their password right, but still has to type in a code,
+// part way through logging in. They aren't authenticated until they do.
+func (app *application) startTwoFactorLogin(w http.ResponseWriter, r *http.Request, u *models.User) {
+	app.session.Put(r, "pendingTwoFactorUserID", u.ID)
+	// Sessions can only hold the basic types, so not a time.Time
+	app.session.Put(r, "pendingTwoFactorExpires", app.now().Add(twoFactorLoginTimeout).Unix())
+	http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+}
+
+// Return the user who is part way through logging in, or nil if nobody is or
+// they took too long
+func (app *application) pendingTwoFactorUser(r *http.Request) (*models.User, error) {
+	id := app.session.GetInt(r, "pendingTwoFactorUserID")
+	if id == 0 {
+		return nil, nil
+	}
+	expires, _ := app.session.Get(r, "pendingTwoFactorExpires").(int64)
+	if app.now().Unix() >= expires {
+		app.clearTwoFactorLogin(r)
+		return nil, nil
+	}
+
+	u, err := app.users.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clearTwoFactorLogin(r)
+			return nil, nil
+		}
+		return nil, err
+	}
+	// They might have been deactivated, or turned it off somewhere else
+	if !u.Active || u.TOTPSecret == nil {
+		app.clearTwoFactorLogin(r)
+		return nil, nil
+	}
+	return u, nil
+}
+
+func (app *application) clearTwoFactorLogin(r *http.Request) {
+	app.session.Remove(r, "pendingTwoFactorUserID")
+	app.session.Remove(r, "pendingTwoFactorExpires")
+}
+
+func (app *application) twoFactorLoginForm(w http.ResponseWriter, r *http.Request) {
+	u, err := app.pendingTwoFactorUser(r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if u == nil {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	app.render(w, r, "twofactorlogin.page.tmpl", &templateData{
+		Form: forms.New(nil),
+	})
+}
+
+func (app *application) twoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form := forms.New(r.PostForm)
+
+	u, err := app.pendingTwoFactorUser(r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if u == nil {
+		app.session.Put(r, "flash", "That took too long. Please log in again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	// Guessing codes goes through the same lockout as guessing passwords
+	ip := app.clientIP(r)
+	wait := app.loginThrottle.wait(ip)
+	if wait == 0 {
+		wait, err = app.accountLockedFor(u.Email)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+	if wait > 0 {
+		app.renderLockedOut(w, r, "twofactorlogin.page.tmpl", form, wait)
+		return
+	}
+
+	form.Required("code")
+	if !form.Valid() {
+		app.render(w, r, "twofactorlogin.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	recovery, err := app.checkTwoFactorCode(u, form.Get("code"))
+	if err != nil {
+		if !errors.Is(err, errInvalidCode) {
+			app.serverError(w, err)
+			return
+		}
+
+		app.audit(r, 0, "user.login_failed", fmt.Sprintf("user:%d", u.ID))
+		app.loginThrottle.fail(ip)
+		locked, err := app.recordLoginFailure(u.Email)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		if locked {
+			app.audit(r, 0, "user.lockout", "email:"+u.Email)
+			wait, err = app.accountLockedFor(u.Email)
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+			app.renderLockedOut(w, r, "twofactorlogin.page.tmpl", form, wait)
+			return
+		}
+
+		form.Errors.Add("code", "That code is incorrect")
+		app.render(w, r, "twofactorlogin.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	app.clearTwoFactorLogin(r)
+	if recovery {
+		app.audit(r, u.ID, "user.recovery_code_used", fmt.Sprintf("user:%d", u.ID))
+		n, err := app.recoveryCodes.Count(u.ID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		app.session.Put(r, "flash", fmt.Sprintf("You logged in with a recovery code, and have %d left. You can get new ones from the two-factor authentication page.", n))
+	}
+	app.finishLogin(w, r, u)
+}
+
+func (app *application) twoFactorSettings(w http.ResponseWriter, r *http.Request) {
+	u, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.renderTwoFactor(w, r, u, forms.New(nil))
+}
+
+// The page either sets up two-factor authentication, or manages it for
+// users who already have it, so both the GET and a failed POST render it
+// this way.
+func (app *application) renderTwoFactor(w http.ResponseWriter, r *http.Request, u *models.User, form *forms.Form) {
+	app.renderTwoFactorStatus(w, r, http.StatusOK, u, &templateData{Form: form})
+}
+
+// Render the page with a status other than 200 OK, such as when it's locked
+// out for too many wrong codes
+func (app *application) renderTwoFactorStatus(w http.ResponseWriter, r *http.Request, status int, u *models.User, td *templateData) {
+	td.TwoFactorEnabled = u.TOTPSecret != nil
+
+	if td.TwoFactorEnabled {
+		n, err := app.recoveryCodes.Count(u.ID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		td.RecoveryCodesLeft = n
+		// Put there when they were made, and gone for good once they've
+		// been shown
+		if codes := app.session.PopString(r, "newRecoveryCodes"); codes != "" {
+			td.RecoveryCodes = strings.Fields(codes)
+		}
+		app.renderStatus(w, r, status, "twofactor.page.tmpl", td)
+		return
+	}
+
+	// The secret is only kept in the session until the user shows that
+	// they've set up their app by typing in a code. It stays the same until
+	// then, so reloading the page doesn't make them scan it again.
+	secret := app.session.GetBytes(r, "pendingTOTPSecret")
+	if secret == nil {
+		var err error
+		secret, err = totp.NewSecret()
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		app.session.Put(r, "pendingTOTPSecret", secret)
+	}
+
+	png, err := qrcode.Encode(totp.URL("Snippetbox", u.Email, secret), qrcode.Medium, 256)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	// html/template won't put a data: URL in an attribute unless it's told
+	// that it's safe, which it is since we made it
+	td.TOTPQRCode = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+	td.TOTPSecret = totp.Encode(secret)
+	app.renderStatus(w, r, status, "twofactor.page.tmpl", td)
+}
+
+func (app *application) enableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form := forms.New(r.PostForm)
+
+	u, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	secret := app.session.GetBytes(r, "pendingTOTPSecret")
+	if u.TOTPSecret != nil || secret == nil {
+		http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+		return
+	}
+
+	form.Required("code")
+	if !form.Valid() {
+		app.renderTwoFactor(w, r, u, form)
+		return
+	}
+	step, ok := totp.Validate(secret, strings.Join(strings.Fields(form.Get("code")), ""), app.now())
+	if !ok {
+		form.Errors.Add("code", "That code is incorrect. Check that the time on your device is right.")
+		app.renderTwoFactor(w, r, u, form)
+		return
+	}
+
+	sealed, err := app.sealTOTPSecret(u.ID, secret)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if err = app.users.SetTOTPSecret(u.ID, sealed); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	// The code they just typed in can't be used to log in as well
+	if err = app.users.UseTOTPStep(u.ID, step); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	codes, err := app.recoveryCodes.Replace(u.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.session.Remove(r, "pendingTOTPSecret")
+	app.audit(r, u.ID, "user.2fa_enable", fmt.Sprintf("user:%d", u.ID))
+
+	app.session.Put(r, "newRecoveryCodes", strings.Join(codes, " "))
+	app.session.Put(r, "flash", "Two-factor authentication is on.")
+	http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+}
+
+// Turning two-factor authentication off, or getting new recovery codes, needs
+// a code too. Otherwise anybody who got hold of a logged in session could
+// take it over for good.
+func (app *application) confirmTwoFactor(w http.ResponseWriter, r *http.Request, then func(*models.User) error, action, flash string) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form := forms.New(r.PostForm)
+
+	u, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if u.TOTPSecret == nil {
+		http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+		return
+	}
+
+	// Guessing codes with a stolen session goes through the same lockout as
+	// guessing them while logging in
+	ip := app.clientIP(r)
+	wait := app.loginThrottle.wait(ip)
+	if wait == 0 {
+		wait, err = app.accountLockedFor(u.Email)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+	if wait > 0 {
+		app.renderTwoFactorLockedOut(w, r, u, form, wait)
+		return
+	}
+
+	form.Required("code")
+	if !form.Valid() {
+		app.renderTwoFactor(w, r, u, form)
+		return
+	}
+
+	_, err = app.checkTwoFactorCode(u, form.Get("code"))
+	if err != nil {
+		if !errors.Is(err, errInvalidCode) {
+			app.serverError(w, err)
+			return
+		}
+
+		app.loginThrottle.fail(ip)
+		locked, err := app.recordLoginFailure(u.Email)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		if locked {
+			app.audit(r, u.ID, "user.lockout", "email:"+u.Email)
+			wait, err = app.accountLockedFor(u.Email)
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+			app.renderTwoFactorLockedOut(w, r, u, form, wait)
+			return
+		}
+
+		form.Errors.Add("code", "That code is incorrect")
+		app.renderTwoFactor(w, r, u, form)
+		return
+	}
+
+	if err = then(u); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit(r, u.ID, action, fmt.Sprintf("user:%d", u.ID))
+	app.session.Put(r, "flash", flash)
+	http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+}
+
+func (app *application) renderTwoFactorLockedOut(w http.ResponseWriter, r *http.Request, u *models.User, form *forms.Form, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	app.renderTwoFactorStatus(w, r, http.StatusTooManyRequests, u, &templateData{
+		Form:       form,
+		RetryAfter: wait,
+	})
+}
+
+func (app *application) disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	app.confirmTwoFactor(w, r, func(u *models.User) error {
+		if err := app.users.SetTOTPSecret(u.ID, nil); err != nil {
+			return err
+		}
+		return app.recoveryCodes.Delete(u.ID)
+	}, "user.2fa_disable", "Two-factor authentication is off.")
+}
+
+func (app *application) replaceRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	app.confirmTwoFactor(w, r, func(u *models.User) error {
+		codes, err := app.recoveryCodes.Replace(u.ID)
+		if err != nil {
+			return err
+		}
+		app.session.Put(r, "newRecoveryCodes", strings.Join(codes, " "))
+		return nil
+	}, "user.recovery_codes_replace", "Your old recovery codes don't work any more.")
+}
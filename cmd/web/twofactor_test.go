@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bytes"
+	"dvhthomas/snippetbox/pkg/models"
+	"dvhthomas/snippetbox/pkg/models/mock"
+	"dvhthomas/snippetbox/pkg/totp"
+	"encoding/base32"
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// A secret for the mock user's authenticator app
+var aliceTOTPSecret = []byte("12345678901234567890")
+
+// Turn on two-factor authentication for the mock user, and stop the clock
+// so that the tests can choose which code is current. It returns a pointer
+// to the time, which they can move on.
+func withTwoFactor(t *testing.T, app *application) *time.Time {
+	t.Helper()
+	sealed, err := app.sealTOTPSecret(1, aliceTOTPSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = app.users.SetTOTPSecret(1, sealed); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2020, 12, 17, 10, 0, 0, 0, time.UTC)
+	app.now = func() time.Time { return now }
+	return &now
+}
+
+// Post a code to the second step of logging in
+func (ts *testServer) postCode(t *testing.T, csrfToken, code string) (int, http.Header, []byte) {
+	form := url.Values{}
+	form.Add("code", code)
+	form.Add("csrf_token", csrfToken)
+	return ts.postForm(t, "/user/login/2fa", form)
+}
+
+func TestTOTPSecretSealing(t *testing.T) {
+	app := newTestApplication(t)
+
+	sealed, err := app.sealTOTPSecret(1, aliceTOTPSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, aliceTOTPSecret) {
+		t.Error("want the secret encrypted")
+	}
+
+	got, err := app.openTOTPSecret(&models.User{ID: 1, TOTPSecret: sealed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, aliceTOTPSecret) {
+		t.Errorf("want %q; got %q", aliceTOTPSecret, got)
+	}
+
+	// It only works for the user it was made for, and with the same key
+	if _, err = app.openTOTPSecret(&models.User{ID: 2, TOTPSecret: sealed}); err == nil {
+		t.Error("want an error for somebody else's secret")
+	}
+	app.secret = []byte("a different secret")
+	if _, err = app.openTOTPSecret(&models.User{ID: 1, TOTPSecret: sealed}); err == nil {
+		t.Error("want an error for a different key")
+	}
+	if _, err = app.openTOTPSecret(&models.User{ID: 1, TOTPSecret: []byte("short")}); err == nil {
+		t.Error("want an error for garbage")
+	}
+}
+
+func TestEnableTwoFactor(t *testing.T) {
+	app := newTestApplication(t)
+	users := app.users.(*mock.UserModel)
+	recoveryCodes := app.recoveryCodes.(*mock.RecoveryCodeModel)
+	audit := app.auditEvents.(*mock.AuditModel)
+	now := time.Date(2020, 12, 17, 10, 0, 0, 0, time.UTC)
+	app.now = func() time.Time { return now }
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	if code, headers, _ := ts.get(t, "/user/2fa"); code != http.StatusSeeOther || headers.Get("Location") != "/user/login" {
+		t.Fatalf("want %d to /user/login; got %d to %q", http.StatusSeeOther, code, headers.Get("Location"))
+	}
+
+	csrfToken := ts.login(t)
+	_, _, body := ts.get(t, "/user/2fa")
+	if !bytes.Contains(body, []byte("src='data:image/png;base64,")) {
+		t.Errorf("want a QR code; got %s", body)
+	}
+	matches := regexp.MustCompile(`<code>([A-Z2-7]+)</code>`).FindSubmatch(body)
+	if len(matches) < 2 {
+		t.Fatalf("want the secret on the page; got %s", body)
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(string(matches[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reloading shows the same secret
+	_, _, body = ts.get(t, "/user/2fa")
+	if !bytes.Contains(body, matches[0]) {
+		t.Errorf("want the same secret again; got %s", body)
+	}
+
+	tests := []struct {
+		name         string
+		code         string
+		wantCode     int
+		wantLocation string
+		wantBody     []byte
+	}{
+		{"Empty code", "", http.StatusOK, "", []byte("This field cannot be blank")},
+		{"Wrong code", "000000", http.StatusOK, "", []byte("That code is incorrect")},
+		{"Old code", totp.Code(secret, now.Add(-2*totp.Period)), http.StatusOK, "", []byte("That code is incorrect")},
+		{"Right code", totp.Code(secret, now), http.StatusSeeOther, "/user/2fa", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("code", tt.code)
+			form.Add("csrf_token", csrfToken)
+			code, headers, body := ts.postForm(t, "/user/2fa/enable", form)
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if loc := headers.Get("Location"); loc != tt.wantLocation {
+				t.Errorf("want Location %q; got %q", tt.wantLocation, loc)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+
+	u, err := app.users.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := app.openTOTPSecret(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, secret) {
+		t.Errorf("want the secret stored; got %x", got)
+	}
+	// The code used to turn it on can't be used to log in
+	if users.TOTPSteps[1] != totp.Step(now) {
+		t.Errorf("want step %d used; got %d", totp.Step(now), users.TOTPSteps[1])
+	}
+	if got := auditedActions(audit); len(got) == 0 || got[len(got)-1] != "user.2fa_enable user:1" {
+		t.Errorf("want it audited; got %q", got)
+	}
+
+	// The recovery codes are shown once
+	_, _, body = ts.get(t, "/user/2fa")
+	codes := recoveryCodes.Codes[1]
+	if len(codes) != models.RecoveryCodeCount {
+		t.Fatalf("want %d recovery codes; got %d", models.RecoveryCodeCount, len(codes))
+	}
+	for _, c := range codes {
+		if !bytes.Contains(body, []byte("<code>"+c+"</code>")) {
+			t.Errorf("want recovery code %q shown; got %s", c, body)
+		}
+	}
+	_, _, body = ts.get(t, "/user/2fa")
+	if bytes.Contains(body, []byte(codes[0])) {
+		t.Error("want the recovery codes shown only once")
+	}
+	if !bytes.Contains(body, []byte("You have 10 recovery codes left")) {
+		t.Errorf("want the recovery codes counted; got %s", body)
+	}
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	app := newTestApplication(t)
+	users := app.users.(*mock.UserModel)
+	audit := app.auditEvents.(*mock.AuditModel)
+	now := withTwoFactor(t, app)
+	codes, err := app.recoveryCodes.Replace(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each test starts again with nothing in the session
+	start := func(t *testing.T) (*testServer, string) {
+		ts := newTestServer(t, app.routes())
+		t.Cleanup(ts.Close)
+		csrfToken := ts.login(t)
+		audit.Events = nil
+		return ts, csrfToken
+	}
+
+	t.Run("Password is only half way", func(t *testing.T) {
+		ts, _ := start(t)
+		if code, headers, _ := ts.get(t, "/snippet/create"); code != http.StatusSeeOther || headers.Get("Location") != "/user/login" {
+			t.Errorf("want %d to /user/login; got %d to %q", http.StatusSeeOther, code, headers.Get("Location"))
+		}
+		if code, _, body := ts.get(t, "/user/login/2fa"); code != http.StatusOK || !bytes.Contains(body, []byte("authenticator app")) {
+			t.Errorf("want %d and the code form; got %d and %s", http.StatusOK, code, body)
+		}
+	})
+
+	t.Run("Nobody logging in", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+		if code, headers, _ := ts.get(t, "/user/login/2fa"); code != http.StatusSeeOther || headers.Get("Location") != "/user/login" {
+			t.Errorf("want %d to /user/login; got %d to %q", http.StatusSeeOther, code, headers.Get("Location"))
+		}
+	})
+
+	t.Run("Authenticator code", func(t *testing.T) {
+		ts, csrfToken := start(t)
+		code, _, body := ts.postCode(t, csrfToken, "123456")
+		if code != http.StatusOK || !bytes.Contains(body, []byte("That code is incorrect")) {
+			t.Errorf("want %d and an error; got %d and %s", http.StatusOK, code, body)
+		}
+		if f := users.Failures["alice@example.com"]; f == nil || f.Count != 1 {
+			t.Errorf("want the failure counted; got %+v", f)
+		}
+
+		code, headers, _ := ts.postCode(t, csrfToken, totp.Code(aliceTOTPSecret, *now))
+		if code != http.StatusSeeOther || headers.Get("Location") != "/snippet/create" {
+			t.Fatalf("want %d to /snippet/create; got %d to %q", http.StatusSeeOther, code, headers.Get("Location"))
+		}
+		if code, _, _ := ts.get(t, "/snippet/create"); code != http.StatusOK {
+			t.Errorf("want to be logged in; got %d", code)
+		}
+		if _, ok := users.Failures["alice@example.com"]; ok {
+			t.Error("want the failures forgotten")
+		}
+		if got := auditedActions(audit); len(got) != 2 || got[0] != "user.login_failed user:1" || got[1] != "user.login user:1" {
+			t.Errorf("want the failure and the login audited; got %q", got)
+		}
+	})
+
+	t.Run("Code used already", func(t *testing.T) {
+		ts, csrfToken := start(t)
+		code, _, body := ts.postCode(t, csrfToken, totp.Code(aliceTOTPSecret, *now))
+		if code != http.StatusOK || !bytes.Contains(body, []byte("That code is incorrect")) {
+			t.Errorf("want %d and an error; got %d and %s", http.StatusOK, code, body)
+		}
+
+		// But the next one is fine, even typed the way the app shows it
+		*now = now.Add(totp.Period)
+		next := totp.Code(aliceTOTPSecret, *now)
+		code, _, _ = ts.postCode(t, csrfToken, next[:3]+" "+next[3:])
+		if code != http.StatusSeeOther {
+			t.Errorf("want %d; got %d", http.StatusSeeOther, code)
+		}
+	})
+
+	t.Run("Recovery code", func(t *testing.T) {
+		ts, csrfToken := start(t)
+		code, headers, _ := ts.postCode(t, csrfToken, codes[0])
+		if code != http.StatusSeeOther || headers.Get("Location") != "/snippet/create" {
+			t.Fatalf("want %d to /snippet/create; got %d to %q", http.StatusSeeOther, code, headers.Get("Location"))
+		}
+		_, _, body := ts.get(t, "/snippet/create")
+		if !bytes.Contains(body, []byte("You logged in with a recovery code, and have 9 left.")) {
+			t.Errorf("want a warning; got %s", body)
+		}
+		if got := auditedActions(audit); len(got) != 2 || got[0] != "user.recovery_code_used user:1" {
+			t.Errorf("want the recovery code audited; got %q", got)
+		}
+
+		// Each one works once
+		ts, csrfToken = start(t)
+		code, _, _ = ts.postCode(t, csrfToken, codes[0])
+		if code != http.StatusOK {
+			t.Errorf("want %d for a used recovery code; got %d", http.StatusOK, code)
+		}
+	})
+
+	t.Run("Too slow", func(t *testing.T) {
+		ts, csrfToken := start(t)
+		*now = now.Add(twoFactorLoginTimeout)
+		code, headers, _ := ts.postCode(t, csrfToken, totp.Code(aliceTOTPSecret, *now))
+		if code != http.StatusSeeOther || headers.Get("Location") != "/user/login" {
+			t.Errorf("want %d to /user/login; got %d to %q", http.StatusSeeOther, code, headers.Get("Location"))
+		}
+		if code, _, _ := ts.get(t, "/snippet/create"); code != http.StatusSeeOther {
+			t.Errorf("want to be logged out; got %d", code)
+		}
+	})
+
+	t.Run("Lockout", func(t *testing.T) {
+		users.Failures = nil
+		ts, csrfToken := start(t)
+		var code int
+		var headers http.Header
+		for i := 0; i < accountFreeFailures; i++ {
+			code, headers, _ = ts.postCode(t, csrfToken, "000000")
+		}
+		if code != http.StatusTooManyRequests || headers.Get("Retry-After") == "" {
+			t.Fatalf("want %d with Retry-After; got %d", http.StatusTooManyRequests, code)
+		}
+		// Even the right code has to wait now
+		*now = now.Add(totp.Period)
+		code, _, _ = ts.postCode(t, csrfToken, totp.Code(aliceTOTPSecret, *now))
+		if code != http.StatusTooManyRequests {
+			t.Errorf("want %d; got %d", http.StatusTooManyRequests, code)
+		}
+	})
+}
+
+func TestManageTwoFactor(t *testing.T) {
+	app := newTestApplication(t)
+	users := app.users.(*mock.UserModel)
+	recoveryCodes := app.recoveryCodes.(*mock.RecoveryCodeModel)
+	audit := app.auditEvents.(*mock.AuditModel)
+	now := withTwoFactor(t, app)
+	if _, err := app.recoveryCodes.Replace(1); err != nil {
+		t.Fatal(err)
+	}
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t)
+	if code, _, _ := ts.postCode(t, csrfToken, totp.Code(aliceTOTPSecret, *now)); code != http.StatusSeeOther {
+		t.Fatalf("want %d; got %d", http.StatusSeeOther, code)
+	}
+	_, _, body := ts.get(t, "/user/2fa")
+	if !bytes.Contains(body, []byte("Two-factor authentication is on")) {
+		t.Errorf("want it shown as on; got %s", body)
+	}
+
+	post := func(path, code string) (int, []byte) {
+		form := url.Values{}
+		form.Add("code", code)
+		form.Add("csrf_token", csrfToken)
+		status, _, body := ts.postForm(t, path, form)
+		return status, body
+	}
+
+	// New recovery codes need a code, and the one that was just used to log
+	// in doesn't count
+	old := recoveryCodes.Codes[1]
+	if code, body := post("/user/2fa/recovery-codes", totp.Code(aliceTOTPSecret, *now)); code != http.StatusOK || !bytes.Contains(body, []byte("That code is incorrect")) {
+		t.Errorf("want %d and an error; got %d and %s", http.StatusOK, code, body)
+	}
+	*now = now.Add(totp.Period)
+	if code, _ := post("/user/2fa/recovery-codes", totp.Code(aliceTOTPSecret, *now)); code != http.StatusSeeOther {
+		t.Fatalf("want %d; got %d", http.StatusSeeOther, code)
+	}
+	if got := recoveryCodes.Codes[1]; len(got) != models.RecoveryCodeCount || got[0] == old[0] {
+		t.Errorf("want new recovery codes; got %q", got)
+	}
+	_, _, body = ts.get(t, "/user/2fa")
+	if !bytes.Contains(body, []byte(recoveryCodes.Codes[1][0])) {
+		t.Errorf("want the new codes shown; got %s", body)
+	}
+
+	// Turning it off works with a recovery code too
+	if code, body := post("/user/2fa/disable", "wrong-code"); code != http.StatusOK || !bytes.Contains(body, []byte("That code is incorrect")) {
+		t.Errorf("want %d and an error; got %d and %s", http.StatusOK, code, body)
+	}
+	if _, ok := users.TOTPSecrets[1]; !ok {
+		t.Fatal("want it still on")
+	}
+	if code, _ := post("/user/2fa/disable", recoveryCodes.Codes[1][0]); code != http.StatusSeeOther {
+		t.Fatalf("want %d; got %d", http.StatusSeeOther, code)
+	}
+	if _, ok := users.TOTPSecrets[1]; ok {
+		t.Error("want the secret gone")
+	}
+	if _, ok := recoveryCodes.Codes[1]; ok {
+		t.Error("want the recovery codes gone")
+	}
+	if got := auditedActions(audit); len(got) < 2 || got[len(got)-2] != "user.recovery_codes_replace user:1" || got[len(got)-1] != "user.2fa_disable user:1" {
+		t.Errorf("want the changes audited; got %q", got)
+	}
+
+	// And logging in takes just the password again
+	ts = newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.login(t)
+	if code, _, _ := ts.get(t, "/snippet/create"); code != http.StatusOK {
+		t.Errorf("want to be logged in; got %d", code)
+	}
+}
+
+func TestConfirmTwoFactorLockout(t *testing.T) {
+	app := newTestApplication(t)
+	users := app.users.(*mock.UserModel)
+	audit := app.auditEvents.(*mock.AuditModel)
+	now := withTwoFactor(t, app)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t)
+	if code, _, _ := ts.postCode(t, csrfToken, totp.Code(aliceTOTPSecret, *now)); code != http.StatusSeeOther {
+		t.Fatalf("want %d; got %d", http.StatusSeeOther, code)
+	}
+	*now = now.Add(totp.Period)
+
+	post := func(code string) (int, http.Header, []byte) {
+		form := url.Values{}
+		form.Add("code", code)
+		form.Add("csrf_token", csrfToken)
+		return ts.postForm(t, "/user/2fa/disable", form)
+	}
+
+	// Somebody with a stolen session can't keep guessing codes
+	var code int
+	var headers http.Header
+	var body []byte
+	for i := 0; i < accountFreeFailures; i++ {
+		code, headers, body = post("000000")
+	}
+	if code != http.StatusTooManyRequests || headers.Get("Retry-After") == "" {
+		t.Fatalf("want %d with Retry-After; got %d", http.StatusTooManyRequests, code)
+	}
+	if !bytes.Contains(body, []byte("Too many incorrect codes")) {
+		t.Errorf("want the lockout explained; got %s", body)
+	}
+	if got := auditedActions(audit); len(got) == 0 || got[len(got)-1] != "user.lockout email:alice@example.com" {
+		t.Errorf("want the lockout audited; got %q", got)
+	}
+
+	// Even the right code has to wait now
+	code, _, _ = post(totp.Code(aliceTOTPSecret, *now))
+	if code != http.StatusTooManyRequests {
+		t.Errorf("want %d; got %d", http.StatusTooManyRequests, code)
+	}
+	if _, ok := users.TOTPSecrets[1]; !ok {
+		t.Error("want it still on")
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"dvhthomas/snippetbox/pkg/forms"
+	"dvhthomas/snippetbox/pkg/models"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long the link in a verification email works for
+const verifyLifetime = 48 * time.Hour
+
+// errInvalidLink is returned for a verification link that has been tampered
+// with, has expired or is for somebody who isn't there any more
+var errInvalidLink = errors.New("invalid or expired link")
+
+// Derive a key for one purpose from the secret, so that a signature made for
+// one thing can never be passed off as one for something else
+func (app *application) key(purpose string) []byte {
+	mac := hmac.New(sha256.New, app.secret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// Verification links don't need to be stored anywhere. They hold the user's
+// ID and when they expire, signed so that they can't be changed. The
+// signature covers the email address too, so changing it makes the old links
+// stop working, but the address itself isn't in the link.
+func (app *application) verificationToken(u *models.User, expires time.Time) string {
+	payload := fmt.Sprintf("%d.%d", u.ID, expires.Unix())
+	return payload + "." + app.signVerification(payload, u.Email)
+}
+
+func (app *application) signVerification(payload, email string) string {
+	mac := hmac.New(sha256.New, app.key("email verification"))
+	mac.Write([]byte(payload + "\x00" + email))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Return the user that the verification token is for, or errInvalidLink
+func (app *application) checkVerificationToken(token string) (*models.User, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidLink
+	}
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, errInvalidLink
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().After(time.Unix(expires, 0)) {
+		return nil, errInvalidLink
+	}
+
+	u, err := app.users.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return nil, errInvalidLink
+		}
+		return nil, err
+	}
+
+	want := app.signVerification(parts[0]+"."+parts[1], u.Email)
+	if !hmac.Equal([]byte(parts[2]), []byte(want)) || !u.Active {
+		return nil, errInvalidLink
+	}
+	return u, nil
+}
+
+func (app *application) sendVerification(u *models.User) error {
+	token := app.verificationToken(u, time.Now().Add(verifyLifetime))
+	body := fmt.Sprintf(`Hi %s,
+
+Please follow this link within the next two days to verify your email
+address, and then you can log in to Snippetbox:
+
+%s/user/verify/%s
+
+If you didn't sign up, you can ignore this email.
+`, u.Name, app.baseURL, token)
+	return app.mailer.Send(u.Email, "Verify your Snippetbox email address", body)
+}
+
+func (app *application) verifyUser(w http.ResponseWriter, r *http.Request) {
+	u, err := app.checkVerificationToken(r.URL.Query().Get(":token"))
+	if err != nil {
+		if !errors.Is(err, errInvalidLink) {
+			app.serverError(w, err)
+			return
+		}
+		app.session.Put(r, "flash", "That link is invalid or has expired. Please ask for a new one.")
+		http.Redirect(w, r, "/user/verify", http.StatusSeeOther)
+		return
+	}
+
+	// Following the link twice is harmless
+	if !u.Verified {
+		if err = app.users.SetVerified(u.ID, true); err != nil {
+			app.serverError(w, err)
+			return
+		}
+		app.audit(r, u.ID, "user.verify", fmt.Sprintf("user:%d", u.ID))
+	}
+
+	app.session.Put(r, "flash", "Your email address has been verified. Please log in.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) resendVerificationForm(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "verify.page.tmpl", &templateData{
+		Form: forms.New(nil),
+	})
+}
+
+func (app *application) resendVerification(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.MatchesPattern("email", forms.EmailRX)
+	if !form.Valid() {
+		app.render(w, r, "verify.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	u, err := app.users.GetByEmail(form.Get("email"))
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, err)
+		return
+	}
+
+	// Like a forgotten password, the answer is the same whoever asks
+	if err == nil && u.Active && !u.Verified {
+		if err = app.sendVerification(u); err != nil {
+			app.errorLog.Printf("verification for user:%d: %s", u.ID, err)
+		}
+	}
+
+	app.session.Put(r, "flash", "If that address needs verifying, we've emailed it a new link.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"dvhthomas/snippetbox/pkg/mailer"
+	"dvhthomas/snippetbox/pkg/models/mock"
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerificationToken(t *testing.T) {
+	app := newTestApplication(t)
+	alice, err := app.users.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := app.verificationToken(alice, time.Now().Add(time.Hour))
+
+	// Somebody else's address, as if Alice had changed hers since
+	moved := *alice
+	moved.Email = "alice@example.org"
+
+	parts := strings.Split(valid, ".")
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"Valid", valid, nil},
+		{"Expired", app.verificationToken(alice, time.Now().Add(-time.Second)), errInvalidLink},
+		{"Address changed", app.verificationToken(&moved, time.Now().Add(time.Hour)), errInvalidLink},
+		{"Somebody else", "3." + parts[1] + "." + parts[2], errInvalidLink},
+		{"Extended", parts[0] + ".99999999999." + parts[2], errInvalidLink},
+		{"Missing user", "99." + parts[1] + "." + parts[2], errInvalidLink},
+		{"Garbage", "nonsense", errInvalidLink},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := app.checkVerificationToken(tt.token)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("want %v; got %v", tt.want, err)
+			}
+			if tt.want == nil && u.ID != alice.ID {
+				t.Errorf("want user %d; got %d", alice.ID, u.ID)
+			}
+		})
+	}
+
+	// A different secret makes all of the links useless
+	app.secret = []byte("another secret")
+	if _, err := app.checkVerificationToken(valid); !errors.Is(err, errInvalidLink) {
+		t.Errorf("want %v with another secret; got %v", errInvalidLink, err)
+	}
+}
+
+var verifyLinkRX = regexp.MustCompile(`https://snippetbox\.example\.com(/user/verify/\S+)`)
+
+func TestSignupVerification(t *testing.T) {
+	app := newTestApplication(t)
+	users := app.users.(*mock.UserModel)
+	audit := app.auditEvents.(*mock.AuditModel)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/signup")
+	form := url.Values{}
+	form.Add("name", "Bob")
+	form.Add("email", "bob@example.com")
+	form.Add("password", "validPa$$word")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	ts.postForm(t, "/user/signup", form)
+
+	mail := sentMail(app)
+	if !strings.Contains(mail, "To: bob@example.com") {
+		t.Fatalf("want an email to Bob; got %q", mail)
+	}
+	m := verifyLinkRX.FindStringSubmatch(mail)
+	if m == nil {
+		t.Fatalf("want a verification link; got %q", mail)
+	}
+
+	// Following it twice is harmless, but only verifies once
+	for i := 0; i < 2; i++ {
+		code, headers, _ := ts.get(t, m[1])
+		if code != http.StatusSeeOther || headers.Get("Location") != "/user/login" {
+			t.Errorf("want %d to /user/login; got %d to %q", http.StatusSeeOther, code, headers.Get("Location"))
+		}
+	}
+	bob := users.SignedUp[0]
+	if !reflect.DeepEqual(users.VerifiedIDs, []int{bob.ID}) {
+		t.Errorf("want Bob verified; got %v", users.VerifiedIDs)
+	}
+	if got := auditedActions(audit); got[len(got)-1] != "user.verify user:100" {
+		t.Errorf("want the verification audited; got %q", got)
+	}
+
+	code, headers, _ := ts.get(t, "/user/verify/nonsense")
+	if code != http.StatusSeeOther || headers.Get("Location") != "/user/verify" {
+		t.Errorf("want %d to /user/verify; got %d to %q", http.StatusSeeOther, code, headers.Get("Location"))
+	}
+}
+
+func TestLoginUnverified(t *testing.T) {
+	app := newTestApplication(t)
+	users := app.users.(*mock.UserModel)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+	form := url.Values{}
+	form.Add("email", "dave@example.com")
+	form.Add("password", "validPa$$word")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, body := ts.postForm(t, "/user/login", form)
+
+	if code != http.StatusOK {
+		t.Errorf("want %d; got %d", http.StatusOK, code)
+	}
+	if !bytes.Contains(body, []byte("Please verify your email address before logging in.")) ||
+		!bytes.Contains(body, []byte("href='/user/verify'")) {
+		t.Errorf("want a link to verify; got %s", body)
+	}
+	// The password was right, so it isn't a failure
+	if len(users.Failures) != 0 {
+		t.Errorf("want no failures recorded; got %v", users.Failures)
+	}
+}
+
+func TestResendVerification(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/verify")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name     string
+		email    string
+		wantCode int
+		wantBody []byte
+		wantMail bool
+	}{
+		{"Unverified user", "dave@example.com", http.StatusSeeOther, nil, true},
+		{"Verified user", "alice@example.com", http.StatusSeeOther, nil, false},
+		{"Unknown user", "mallory@example.com", http.StatusSeeOther, nil, false},
+		{"Invalid email", "daveexample.com", http.StatusOK, []byte("This field is invalid"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app.mailer.(*mailer.Log).Out.(*bytes.Buffer).Reset()
+
+			form := url.Values{}
+			form.Add("email", tt.email)
+			form.Add("csrf_token", csrfToken)
+			code, _, body := ts.postForm(t, "/user/verify", form)
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+			mail := sentMail(app)
+			if tt.wantMail != verifyLinkRX.MatchString(mail) {
+				t.Errorf("want a link sent %t; got %q", tt.wantMail, mail)
+			}
+		})
+	}
+}
+
+func TestResetVerifies(t *testing.T) {
+	app := newTestApplication(t)
+	users := app.users.(*mock.UserModel)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/password/reset/valid-reset-token")
+	form := url.Values{}
+	form.Add("password", "newPa$$word123")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	ts.postForm(t, "/user/password/reset/valid-reset-token", form)
+
+	if !reflect.DeepEqual(users.VerifiedIDs, []int{1}) {
+		t.Errorf("want the user verified by resetting their password; got %v", users.VerifiedIDs)
+	}
+}
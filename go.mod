@@ -1,12 +1,16 @@
 module dvhthomas/snippetbox
 
-go 1.14
+go 1.16
 
 require (
+	github.com/alecthomas/chroma v0.10.0
 	github.com/bmizerany/pat v0.0.0-20170815010413-6226ea591a40
 	github.com/go-sql-driver/mysql v1.5.0
 	github.com/golangcollege/sessions v1.2.0
 	github.com/justinas/alice v1.2.0
 	github.com/justinas/nosurf v1.1.0
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.22
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	golang.org/x/crypto v0.0.0-20200317142112-1b76d66859c6
 )
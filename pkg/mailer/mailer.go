@@ -0,0 +1,134 @@
+// Package mailer sends email. SMTP delivers it for real, and Log just writes
+// it out, which is handy for development and tests.
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidHeader is returned for a sender, recipient or subject with a line
+// break in it, which could otherwise be used to add headers of its own
+var ErrInvalidHeader = errors.New("mailer: line break in header")
+
+// Mailer sends a plain text email to one address
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// SMTP sends email through an SMTP server
+type SMTP struct {
+	// The server's host:port
+	Addr string
+	// Who the email is from, such as "Snippetbox <no-reply@example.com>"
+	From string
+	// How to log in to the server, or nil if it doesn't need it
+	Auth smtp.Auth
+}
+
+// NewSMTP returns an SMTP mailer. It logs in with the username and password
+// if there is a username. Go's SMTP client refuses to send those in the clear
+// unless the server is on localhost or offers STARTTLS.
+func NewSMTP(addr, username, password, from string) (*SMTP, error) {
+	if _, err := mail.ParseAddress(from); err != nil {
+		return nil, fmt.Errorf("mailer: invalid sender %q: %w", from, err)
+	}
+
+	m := &SMTP{Addr: addr, From: from}
+	if username != "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		m.Auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m, nil
+}
+
+// Send the email
+func (m *SMTP) Send(to, subject, body string) error {
+	msg, err := message(m.From, to, subject, body, time.Now())
+	if err != nil {
+		return err
+	}
+
+	// The envelope only wants the bare addresses
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return err
+	}
+	rcpt, err := mail.ParseAddress(to)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(m.Addr, m.Auth, from.Address, []string{rcpt.Address}, msg)
+}
+
+// Log writes every email to Out instead of sending it, one after another, so
+// that somebody can follow the links in them while they're developing. The
+// body is written as it is rather than encoded, which is easier to read.
+type Log struct {
+	mu   sync.Mutex
+	Out  io.Writer
+	From string
+}
+
+// Send writes the email out
+func (m *Log) Send(to, subject, body string) error {
+	if err := checkHeaders(m.From, to, subject); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := fmt.Fprintf(m.Out, "From: %s\nTo: %s\nSubject: %s\nDate: %s\n\n%s\n\n",
+		m.From, to, subject, time.Now().Format(time.RFC1123Z), strings.TrimRight(body, "\n"))
+	return err
+}
+
+func checkHeaders(headers ...string) error {
+	for _, h := range headers {
+		if strings.ContainsAny(h, "\r\n") {
+			return ErrInvalidHeader
+		}
+	}
+	return nil
+}
+
+// Put together the whole email, headers and all. The body is quoted-printable
+// so that long lines and non-ASCII text make it through any server.
+func message(from, to, subject, body string, date time.Time) ([]byte, error) {
+	if err := checkHeaders(from, to, subject); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+	b.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(&b)
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	if _, err := qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
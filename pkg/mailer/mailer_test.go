@@ -0,0 +1,171 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessage(t *testing.T) {
+	date := time.Date(2020, 12, 17, 10, 0, 0, 0, time.UTC)
+	link := "https://localhost:4000/user/password/reset/" + strings.Repeat("x", 80)
+	msg, err := message("Snippetbox <no-reply@example.com>", "alice@example.com", "Réinitialiser", "Hi Alice,\n\n"+link+"\n", date)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Header.Get("To"); got != "alice@example.com" {
+		t.Errorf("want To alice@example.com; got %q", got)
+	}
+	if got := m.Header.Get("Subject"); got != "=?utf-8?q?R=C3=A9initialiser?=" {
+		t.Errorf("want the subject encoded; got %q", got)
+	}
+	if got, _ := m.Header.Date(); !got.Equal(date) {
+		t.Errorf("want Date %v; got %v", date, got)
+	}
+
+	// The long link is wrapped on the way but comes out whole
+	body, err := ioutil.ReadAll(quotedprintable.NewReader(m.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(body, []byte(link+"\r\n")) {
+		t.Errorf("want the link intact; got %q", body)
+	}
+}
+
+func TestInvalidHeaders(t *testing.T) {
+	var out bytes.Buffer
+	m := &Log{Out: &out, From: "no-reply@example.com"}
+
+	tests := []struct {
+		name    string
+		to      string
+		subject string
+	}{
+		{"Recipient", "alice@example.com\r\nBcc: mallory@example.com", "Hello"},
+		{"Subject", "alice@example.com", "Hello\nBcc: mallory@example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := m.Send(tt.to, tt.subject, "Body"); !errors.Is(err, ErrInvalidHeader) {
+				t.Errorf("want %v; got %v", ErrInvalidHeader, err)
+			}
+			if _, err := message("no-reply@example.com", tt.to, tt.subject, "Body", time.Now()); !errors.Is(err, ErrInvalidHeader) {
+				t.Errorf("want %v; got %v", ErrInvalidHeader, err)
+			}
+		})
+	}
+	if out.Len() > 0 {
+		t.Errorf("want nothing written; got %q", out.String())
+	}
+}
+
+func TestLog(t *testing.T) {
+	var out bytes.Buffer
+	m := &Log{Out: &out, From: "no-reply@example.com"}
+
+	if err := m.Send("alice@example.com", "First", "One"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Send("bob@example.com", "Second", "Two\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"To: alice@example.com\nSubject: First\n", "\n\nOne\n\n", "To: bob@example.com\nSubject: Second\n", "\n\nTwo\n\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("want output to contain %q; got %q", want, out.String())
+		}
+	}
+}
+
+// Just enough of an SMTP server to take one email
+func fakeSMTPServer(t *testing.T, received chan<- []string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var lines []string
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ESMTP")
+		data := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+			switch {
+			case data && line == ".":
+				data = false
+				reply("250 OK")
+			case data:
+			case strings.HasPrefix(line, "EHLO"):
+				reply("250 localhost")
+			case line == "DATA":
+				data = true
+				reply("354 Go ahead")
+			case line == "QUIT":
+				reply("221 Bye")
+				received <- lines
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestSMTP(t *testing.T) {
+	received := make(chan []string, 1)
+	addr := fakeSMTPServer(t, received)
+
+	m, err := NewSMTP(addr, "", "", "Snippetbox <no-reply@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Send("Alice <alice@example.com>", "Hello", "Hi Alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Join(<-received, "\n")
+	for _, want := range []string{
+		"MAIL FROM:<no-reply@example.com>",
+		"RCPT TO:<alice@example.com>",
+		"To: Alice <alice@example.com>",
+		"Subject: Hello",
+		"Hi Alice",
+	} {
+		if !strings.Contains(lines, want) {
+			t.Errorf("want the server to get %q; got %q", want, lines)
+		}
+	}
+
+	if _, err = NewSMTP(addr, "", "", "not an address"); err == nil {
+		t.Error("want an error for an invalid sender")
+	}
+}
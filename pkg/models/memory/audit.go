@@ -0,0 +1,60 @@
+package memory
+
+import (
+	"dvhthomas/snippetbox/pkg/models"
+)
+
+// AuditModel works with the audit events in a DB, which are only ever added to
+type AuditModel struct {
+	DB *DB
+}
+
+// Insert records an event. userID is zero if nobody was logged in.
+func (m *AuditModel) Insert(userID int, action, target, ip, userAgent string) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	m.DB.events = append(m.DB.events, &models.AuditEvent{
+		ID:        len(m.DB.events) + 1,
+		UserID:    userID,
+		Action:    action,
+		Target:    target,
+		IP:        ip,
+		UserAgent: userAgent,
+		Created:   now(),
+	})
+	return nil
+}
+
+// List returns a page of events, newest first. Pages are numbered from 1 and
+// hold models.AuditEventsPerPage events.
+func (m *AuditModel) List(page int) ([]*models.AuditEvent, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+
+	// The events are kept oldest first, so walk backwards from the end
+	events := []*models.AuditEvent{}
+	start := len(m.DB.events) - 1 - (page-1)*models.AuditEventsPerPage
+	for i := start; i >= 0 && len(events) < models.AuditEventsPerPage; i-- {
+		c := *m.DB.events[i]
+		events = append(events, &c)
+	}
+	return events, nil
+}
+
+// Before returns up to models.AuditEventsPerPage events with IDs below id,
+// highest first, or the newest events if id is zero. Unlike pages, these
+// don't shift when events are added, so this is what the export walks.
+func (m *AuditModel) Before(id int) ([]*models.AuditEvent, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+
+	events := []*models.AuditEvent{}
+	for i := len(m.DB.events) - 1; i >= 0 && len(events) < models.AuditEventsPerPage; i-- {
+		if id == 0 || m.DB.events[i].ID < id {
+			c := *m.DB.events[i]
+			events = append(events, &c)
+		}
+	}
+	return events, nil
+}
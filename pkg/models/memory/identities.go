@@ -0,0 +1,46 @@
+package memory
+
+import (
+	"dvhthomas/snippetbox/pkg/models"
+	"errors"
+)
+
+// The SQL backends fail on the unique constraint in this case
+var errDuplicateIdentity = errors.New("memory: identity is already linked to a user")
+
+// The issuer and subject that identify a user at an identity provider
+type identity struct {
+	issuer, subject string
+}
+
+// IdentityModel works with the identity provider accounts in a DB
+type IdentityModel struct {
+	DB *DB
+}
+
+// Insert links the user to their subject at an identity provider. Each
+// subject can only be linked to one user, the same as the SQL backends.
+func (m *IdentityModel) Insert(userID int, issuer, subject string) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	key := identity{issuer, subject}
+	if _, ok := m.DB.identities[key]; ok {
+		return errDuplicateIdentity
+	}
+	m.DB.identities[key] = userID
+	return nil
+}
+
+// Get returns the ID of the user linked to the subject at an identity
+// provider, or models.ErrNoRecord if nobody is
+func (m *IdentityModel) Get(issuer, subject string) (int, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+
+	userID, ok := m.DB.identities[identity{issuer, subject}]
+	if !ok {
+		return 0, models.ErrNoRecord
+	}
+	return userID, nil
+}
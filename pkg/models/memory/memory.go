@@ -0,0 +1,55 @@
+// Package memory keeps everything in memory, which is handy for trying the
+// application out without a database. Nothing survives a restart.
+package memory
+
+import (
+	"dvhthomas/snippetbox/pkg/models"
+	"sync"
+	"time"
+)
+
+// DB holds all of the records and the lock that guards them. The models all
+// share one DB so that, just like in a real database, snippets can see the
+// names of their authors and tokens can see whether their user is active.
+type DB struct {
+	mu       sync.RWMutex
+	snippets map[int]*snippet
+	users    map[int]*user
+	tokens   map[int]*token
+	// Audit events in the order they happened, so the ID is one more than
+	// the index
+	events []*models.AuditEvent
+	// Failed logins by the email address that was tried
+	loginFailures map[string]*loginFailures
+	// Password reset tokens by their hash
+	resets map[string]*passwordReset
+	// The hashes of each user's unused recovery codes
+	recoveryCodes map[int]map[string]bool
+	// The user linked to each identity provider account
+	identities map[identity]int
+	// Logged in browsers by the hash of their token
+	sessions map[string]*models.Session
+	// The last ID handed out for each kind of record
+	lastSnippetID, lastUserID, lastTokenID, lastSessionID int
+}
+
+// New returns an empty DB
+func New() *DB {
+	return &DB{
+		snippets: map[int]*snippet{},
+		users:    map[int]*user{},
+		tokens:   map[int]*token{},
+
+		loginFailures: map[string]*loginFailures{},
+		resets:        map[string]*passwordReset{},
+		recoveryCodes: map[int]map[string]bool{},
+		identities:    map[identity]int{},
+		sessions:      map[string]*models.Session{},
+	}
+}
+
+// Everything is stored in UTC without the monotonic clock reading, the same
+// as it comes back from a database.
+func now() time.Time {
+	return time.Now().UTC().Round(0)
+}
@@ -0,0 +1,22 @@
+package memory
+
+import (
+	"dvhthomas/snippetbox/pkg/models/modeltest"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+	modeltest.Run(t, func(t *testing.T) *modeltest.Store {
+		db := New()
+		return &modeltest.Store{
+			Snippets:      &SnippetModel{DB: db},
+			Users:         &UserModel{DB: db},
+			Tokens:        &TokenModel{DB: db},
+			Audit:         &AuditModel{DB: db},
+			Resets:        &PasswordResetModel{DB: db},
+			RecoveryCodes: &RecoveryCodeModel{DB: db},
+			Identities:    &IdentityModel{DB: db},
+			Sessions:      &SessionModel{DB: db},
+		}
+	})
+}
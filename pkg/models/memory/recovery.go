@@ -0,0 +1,60 @@
+package memory
+
+import "dvhthomas/snippetbox/pkg/models"
+
+// RecoveryCodeModel works with the recovery codes in a DB
+type RecoveryCodeModel struct {
+	DB *DB
+}
+
+// Replace throws away the user's recovery codes and gives them a new set of
+// models.RecoveryCodeCount
+func (m *RecoveryCodeModel) Replace(userID int) ([]string, error) {
+	codes := make([]string, models.RecoveryCodeCount)
+	hashes := map[string]bool{}
+	for i := range codes {
+		code, hash, err := models.NewRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		hashes[hash] = true
+	}
+
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	m.DB.recoveryCodes[userID] = hashes
+	return codes, nil
+}
+
+// Consume uses up one of the user's recovery codes, or returns
+// models.ErrInvalidCredentials if they don't have it
+func (m *RecoveryCodeModel) Consume(userID int, code string) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	hash := models.HashRecoveryCode(code)
+	if !m.DB.recoveryCodes[userID][hash] {
+		return models.ErrInvalidCredentials
+	}
+	delete(m.DB.recoveryCodes[userID], hash)
+	return nil
+}
+
+// Count returns how many unused recovery codes the user has left
+func (m *RecoveryCodeModel) Count(userID int) (int, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+
+	return len(m.DB.recoveryCodes[userID]), nil
+}
+
+// Delete all of the user's recovery codes
+func (m *RecoveryCodeModel) Delete(userID int) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	delete(m.DB.recoveryCodes, userID)
+	return nil
+}
@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"dvhthomas/snippetbox/pkg/models"
+	"time"
+)
+
+type passwordReset struct {
+	userID  int
+	expires time.Time
+}
+
+// PasswordResetModel works with the password reset tokens in a DB
+type PasswordResetModel struct {
+	DB *DB
+}
+
+// Insert creates a reset token for the user that lasts for ttl and returns it
+func (m *PasswordResetModel) Insert(userID int, ttl time.Duration) (string, error) {
+	t, hash, err := models.NewToken()
+	if err != nil {
+		return "", err
+	}
+
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	for h, r := range m.DB.resets {
+		if !r.expires.After(now()) {
+			delete(m.DB.resets, h)
+		}
+	}
+	m.DB.resets[hash] = &passwordReset{userID: userID, expires: now().Add(ttl)}
+	return t, nil
+}
+
+// Check returns the ID of the user the token belongs to without using it up
+func (m *PasswordResetModel) Check(token string) (int, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+
+	return m.check(models.HashToken(token))
+}
+
+// Consume checks the token and uses it up, along with any other reset tokens
+// the user has
+func (m *PasswordResetModel) Consume(token string) (int, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	userID, err := m.check(models.HashToken(token))
+	if err != nil {
+		return 0, err
+	}
+	for h, r := range m.DB.resets {
+		if r.userID == userID {
+			delete(m.DB.resets, h)
+		}
+	}
+	return userID, nil
+}
+
+// The caller must hold the lock
+func (m *PasswordResetModel) check(hash string) (int, error) {
+	r, ok := m.DB.resets[hash]
+	if !ok || !r.expires.After(now()) {
+		return 0, models.ErrInvalidCredentials
+	}
+	if u, ok := m.DB.users[r.userID]; !ok || !u.Active {
+		return 0, models.ErrInvalidCredentials
+	}
+	return r.userID, nil
+}
@@ -0,0 +1,114 @@
+package memory
+
+import (
+	"dvhthomas/snippetbox/pkg/models"
+	"sort"
+	"time"
+)
+
+// SessionModel works with the browsers that users have logged in on
+type SessionModel struct {
+	DB *DB
+}
+
+// Insert starts a session for the user that lasts for ttl and returns its
+// token
+func (m *SessionModel) Insert(userID int, ip, userAgent string, ttl time.Duration) (string, error) {
+	token, hash, err := models.NewToken()
+	if err != nil {
+		return "", err
+	}
+
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	created := now()
+	for h, s := range m.DB.sessions {
+		if !s.Expires.After(created) {
+			delete(m.DB.sessions, h)
+		}
+	}
+
+	m.DB.lastSessionID++
+	m.DB.sessions[hash] = &models.Session{
+		ID:        m.DB.lastSessionID,
+		UserID:    userID,
+		IP:        ip,
+		UserAgent: userAgent,
+		Created:   created,
+		LastSeen:  created,
+		Expires:   created.Add(ttl),
+	}
+	return token, nil
+}
+
+// Authenticate returns the session that the token picks out, and records
+// that it was seen from ip. Unknown and expired tokens, and tokens belonging
+// to users that have been deactivated, are invalid credentials.
+func (m *SessionModel) Authenticate(token, ip string) (*models.Session, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	t := now()
+	s, ok := m.DB.sessions[models.HashToken(token)]
+	if !ok || !s.Expires.After(t) {
+		return nil, models.ErrInvalidCredentials
+	}
+	if u, ok := m.DB.users[s.UserID]; !ok || !u.Active {
+		return nil, models.ErrInvalidCredentials
+	}
+
+	if t.Sub(s.LastSeen) >= models.SessionSeenInterval {
+		s.LastSeen, s.IP = t, ip
+	}
+	c := *s
+	return &c, nil
+}
+
+// List returns the user's live sessions, most recently seen first
+func (m *SessionModel) List(userID int) ([]*models.Session, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+
+	t := now()
+	sessions := []*models.Session{}
+	for _, s := range m.DB.sessions {
+		if s.UserID == userID && s.Expires.After(t) {
+			c := *s
+			sessions = append(sessions, &c)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		a, b := sessions[i], sessions[j]
+		return a.LastSeen.After(b.LastSeen) || (a.LastSeen.Equal(b.LastSeen) && a.ID > b.ID)
+	})
+	return sessions, nil
+}
+
+// Delete ends one of the user's sessions. Sessions belonging to somebody
+// else are treated as if they don't exist.
+func (m *SessionModel) Delete(userID, id int) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	for h, s := range m.DB.sessions {
+		if s.ID == id && s.UserID == userID {
+			delete(m.DB.sessions, h)
+			return nil
+		}
+	}
+	return models.ErrNoRecord
+}
+
+// DeleteAll ends every one of the user's sessions
+func (m *SessionModel) DeleteAll(userID int) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	for h, s := range m.DB.sessions {
+		if s.UserID == userID {
+			delete(m.DB.sessions, h)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package memory
+
+import (
+	"dvhthomas/snippetbox/pkg/models"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The snippet as stored. The author's name is filled in when it's read so
+// that it's always current.
+type snippet struct {
+	models.Snippet
+}
+
+// SnippetModel works with the snippets in a DB
+type SnippetModel struct {
+	DB *DB
+}
+
+// Insert adds a new snippet on behalf of the user identified by userID
+func (m *SnippetModel) Insert(userID int, title, content, language, visibility, expires string) (int, error) {
+	days, err := strconv.Atoi(expires)
+	if err != nil {
+		return 0, err
+	}
+	slug, err := models.NewSlug()
+	if err != nil {
+		return 0, err
+	}
+
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	m.DB.lastSnippetID++
+	created := now()
+	m.DB.snippets[m.DB.lastSnippetID] = &snippet{models.Snippet{
+		ID:         m.DB.lastSnippetID,
+		Title:      title,
+		Content:    content,
+		Language:   language,
+		Visibility: visibility,
+		Slug:       slug,
+		Created:    created,
+		Expires:    created.AddDate(0, 0, days),
+		AuthorID:   userID,
+	}}
+	return m.DB.lastSnippetID, nil
+}
+
+// Get returns a live snippet based on its ID
+func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+
+	s, ok := m.DB.snippets[id]
+	if !ok || !s.live(now()) {
+		return nil, models.ErrNoRecord
+	}
+	return m.read(s), nil
+}
+
+// GetBySlug returns a live snippet based on its slug
+func (m *SnippetModel) GetBySlug(slug string) (*models.Snippet, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+
+	t := now()
+	for _, s := range m.DB.snippets {
+		if s.Slug == slug && s.live(t) {
+			return m.read(s), nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+// Update replaces the title, content, language and visibility of a snippet.
+// Checking that the caller is allowed to change it is up to the handler.
+func (m *SnippetModel) Update(id int, title, content, language, visibility string) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	// Like the SQL backends, this doesn't care whether the snippet has expired
+	s, ok := m.DB.snippets[id]
+	if !ok {
+		return nil
+	}
+	s.Title, s.Content, s.Language, s.Visibility = title, content, language, visibility
+	return nil
+}
+
+// Delete removes a snippet
+func (m *SnippetModel) Delete(id int) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	if _, ok := m.DB.snippets[id]; !ok {
+		return models.ErrNoRecord
+	}
+	delete(m.DB.snippets, id)
+	return nil
+}
+
+// DeleteExpired removes up to limit expired snippets and reports how many went
+func (m *SnippetModel) DeleteExpired(limit int) (int, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	n := 0
+	t := now()
+	for id, s := range m.DB.snippets {
+		if n == limit {
+			break
+		}
+		if !s.live(t) {
+			delete(m.DB.snippets, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Latest returns the 10 most recently created public snippets
+func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
+	return m.List(nil, 10)
+}
+
+// List returns up to limit live public snippets from the position marked by
+// cursor, newest first. A nil cursor starts at the newest snippet.
+func (m *SnippetModel) List(cursor *models.Cursor, limit int) ([]*models.Snippet, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+
+	snippets := []*models.Snippet{}
+	for _, s := range m.public() {
+		switch {
+		case cursor == nil:
+		case cursor.Before:
+			if !newer(s, cursor.Created, cursor.ID) {
+				continue
+			}
+		default:
+			if !newer(&models.Snippet{Created: cursor.Created, ID: cursor.ID}, s.Created, s.ID) {
+				continue
+			}
+		}
+		snippets = append(snippets, s)
+	}
+
+	if len(snippets) > limit {
+		// Going backwards we want the ones nearest to the cursor, which are
+		// at the end of the list.
+		if cursor != nil && cursor.Before {
+			snippets = snippets[len(snippets)-limit:]
+		} else {
+			snippets = snippets[:limit]
+		}
+	}
+	return snippets, nil
+}
+
+// Search returns a page of live public snippets whose title or content
+// contain any of the words in the query, ignoring case. Snippets that match
+// more of the words come first.
+func (m *SnippetModel) Search(query string, page int) ([]*models.Snippet, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+
+	terms := strings.Fields(strings.ToLower(query))
+
+	matches := []*models.Snippet{}
+	score := map[int]int{}
+	for _, s := range m.public() {
+		text := strings.ToLower(s.Title + " " + s.Content)
+		for _, term := range terms {
+			if strings.Contains(text, term) {
+				score[s.ID]++
+			}
+		}
+		if score[s.ID] > 0 {
+			matches = append(matches, s)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return score[matches[i].ID] > score[matches[j].ID]
+	})
+
+	start := (page - 1) * models.SearchResultsPerPage
+	if start >= len(matches) {
+		return []*models.Snippet{}, nil
+	}
+	end := start + models.SearchResultsPerPage
+	if end > len(matches) {
+		end = len(matches)
+	}
+	return matches[start:end], nil
+}
+
+// ByAuthor returns all of a user's live snippets, newest first, whatever
+// their visibility. It's for showing users their own snippets, so never show
+// the results to anybody else.
+func (m *SnippetModel) ByAuthor(userID int) ([]*models.Snippet, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+
+	snippets := []*models.Snippet{}
+	t := now()
+	for _, s := range m.DB.snippets {
+		if s.AuthorID == userID && s.live(t) {
+			snippets = append(snippets, m.read(s))
+		}
+	}
+	sort.Slice(snippets, func(i, j int) bool {
+		return newer(snippets[i], snippets[j].Created, snippets[j].ID)
+	})
+	return snippets, nil
+}
+
+// All returns a page of every snippet, newest first, whatever its visibility
+// and whether or not it has expired. It's for the admin console, so never
+// show the results to anybody else. Pages are numbered from 1 and hold
+// models.AdminSnippetsPerPage snippets.
+func (m *SnippetModel) All(page int) ([]*models.Snippet, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+
+	snippets := []*models.Snippet{}
+	for _, s := range m.DB.snippets {
+		snippets = append(snippets, m.read(s))
+	}
+	sort.Slice(snippets, func(i, j int) bool {
+		return newer(snippets[i], snippets[j].Created, snippets[j].ID)
+	})
+
+	start := (page - 1) * models.AdminSnippetsPerPage
+	if start >= len(snippets) {
+		return []*models.Snippet{}, nil
+	}
+	end := start + models.AdminSnippetsPerPage
+	if end > len(snippets) {
+		end = len(snippets)
+	}
+	return snippets[start:end], nil
+}
+
+// All of the live public snippets, newest first. The caller must hold the lock.
+func (m *SnippetModel) public() []*models.Snippet {
+	snippets := []*models.Snippet{}
+	t := now()
+	for _, s := range m.DB.snippets {
+		if s.Visibility == models.VisibilityPublic && s.live(t) {
+			snippets = append(snippets, m.read(s))
+		}
+	}
+	sort.Slice(snippets, func(i, j int) bool {
+		return newer(snippets[i], snippets[j].Created, snippets[j].ID)
+	})
+	return snippets
+}
+
+// A copy of the snippet with its author's name filled in, so that callers
+// can't change what's stored. The caller must hold the lock.
+func (m *SnippetModel) read(s *snippet) *models.Snippet {
+	c := s.Snippet
+	if u, ok := m.DB.users[c.AuthorID]; ok {
+		c.AuthorName = u.Name
+	}
+	return &c
+}
+
+func (s *snippet) live(t time.Time) bool {
+	return s.Expires.After(t)
+}
+
+// Report whether s comes after the snippet created at created with ID id,
+// ordering by creation time and then by ID like the SQL backends.
+func newer(s *models.Snippet, created time.Time, id int) bool {
+	return s.Created.After(created) || (s.Created.Equal(created) && s.ID > id)
+}
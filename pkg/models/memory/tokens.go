@@ -0,0 +1,93 @@
+package memory
+
+import (
+	"dvhthomas/snippetbox/pkg/models"
+	"sort"
+)
+
+// Only the hash of the token is kept, the same as in the database
+type token struct {
+	models.APIToken
+	hash string
+}
+
+// TokenModel works with the API tokens in a DB
+type TokenModel struct {
+	DB *DB
+}
+
+// Insert creates a new named token for the user and returns it
+func (m *TokenModel) Insert(userID int, name string) (string, error) {
+	t, hash, err := models.NewToken()
+	if err != nil {
+		return "", err
+	}
+
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	m.DB.lastTokenID++
+	m.DB.tokens[m.DB.lastTokenID] = &token{
+		APIToken: models.APIToken{
+			ID:      m.DB.lastTokenID,
+			UserID:  userID,
+			Name:    name,
+			Created: now(),
+		},
+		hash: hash,
+	}
+	return t, nil
+}
+
+// List returns all of the user's tokens, newest first
+func (m *TokenModel) List(userID int) ([]*models.APIToken, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+
+	tokens := []*models.APIToken{}
+	for _, t := range m.DB.tokens {
+		if t.UserID == userID {
+			c := t.APIToken
+			tokens = append(tokens, &c)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].ID > tokens[j].ID
+	})
+	return tokens, nil
+}
+
+// Delete revokes one of the user's tokens. Tokens belonging to somebody else
+// are treated as if they don't exist.
+func (m *TokenModel) Delete(userID, id int) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	t, ok := m.DB.tokens[id]
+	if !ok || t.UserID != userID {
+		return models.ErrNoRecord
+	}
+	delete(m.DB.tokens, id)
+	return nil
+}
+
+// Authenticate returns the ID of the active user that owns the token and
+// records that the token was used.
+func (m *TokenModel) Authenticate(tok string) (int, error) {
+	hash := models.HashToken(tok)
+
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	for _, t := range m.DB.tokens {
+		if t.hash != hash {
+			continue
+		}
+		if u, ok := m.DB.users[t.UserID]; !ok || !u.Active {
+			break
+		}
+		t.LastUsed = now()
+		return t.UserID, nil
+	}
+	return 0, models.ErrInvalidCredentials
+}
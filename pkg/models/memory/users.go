@@ -0,0 +1,306 @@
+package memory
+
+import (
+	"dvhthomas/snippetbox/pkg/models"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type user struct {
+	models.User
+	// The last step a two-factor code was accepted for
+	totpLastStep int64
+}
+
+type loginFailures struct {
+	count int
+	last  time.Time
+}
+
+// UserModel works with the users in a DB
+type UserModel struct {
+	DB *DB
+}
+
+// Insert adds a new user. Email addresses have to be unique, without regard
+// to case like the SQL backends.
+func (m *UserModel) Insert(name, email, password string) error {
+	// Hash before taking the lock because bcrypt is slow on purpose
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	for _, u := range m.DB.users {
+		if strings.EqualFold(u.Email, email) {
+			return models.ErrDuplicateEmail
+		}
+	}
+
+	m.DB.lastUserID++
+	m.DB.users[m.DB.lastUserID] = &user{User: models.User{
+		ID:             m.DB.lastUserID,
+		Name:           name,
+		Email:          email,
+		HashedPassword: hashedPassword,
+		Created:        now(),
+		Active:         true,
+		Role:           models.RoleUser,
+	}}
+	return nil
+}
+
+// Authenticate returns the ID of the active user with the email address and
+// password, or models.ErrInvalidCredentials if there isn't one. A user who
+// hasn't verified their email address yet gets models.ErrUnverified.
+func (m *UserModel) Authenticate(email, password string) (int, error) {
+	// Copy what's needed so that bcrypt can run without the lock
+	m.DB.mu.RLock()
+	var found *models.User
+	for _, u := range m.DB.users {
+		if strings.EqualFold(u.Email, email) && u.Active {
+			c := u.User
+			found = &c
+			break
+		}
+	}
+	m.DB.mu.RUnlock()
+
+	if found == nil {
+		return 0, models.ErrInvalidCredentials
+	}
+
+	err := bcrypt.CompareHashAndPassword(found.HashedPassword, []byte(password))
+	if err != nil {
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return 0, models.ErrInvalidCredentials
+		}
+		return 0, err
+	}
+	if !found.Verified {
+		return 0, models.ErrUnverified
+	}
+	return found.ID, nil
+}
+
+// Get a user based on their unique ID
+func (m *UserModel) Get(id int) (*models.User, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+
+	u, ok := m.DB.users[id]
+	if !ok {
+		return nil, models.ErrNoRecord
+	}
+	c := u.User
+	return &c, nil
+}
+
+// GetByEmail finds a user by their email address, whether they're active or not
+func (m *UserModel) GetByEmail(email string) (*models.User, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+
+	for _, u := range m.DB.users {
+		if strings.EqualFold(u.Email, email) {
+			c := u.User
+			return &c, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+// List every user in the order they signed up
+func (m *UserModel) List() ([]*models.User, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+
+	users := []*models.User{}
+	for _, u := range m.DB.users {
+		c := u.User
+		users = append(users, &c)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+	return users, nil
+}
+
+// SetActive lets a user log in again, or stops them. A missing user isn't an
+// error, the same as the SQL backends.
+func (m *UserModel) SetActive(id int, active bool) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	if u, ok := m.DB.users[id]; ok {
+		u.Active = active
+	}
+	return nil
+}
+
+// SetVerified records whether the user has shown that their email address is
+// really theirs
+func (m *UserModel) SetVerified(id int, verified bool) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	if u, ok := m.DB.users[id]; ok {
+		u.Verified = verified
+	}
+	return nil
+}
+
+// SetPassword replaces a user's password
+func (m *UserModel) SetPassword(id int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	if u, ok := m.DB.users[id]; ok {
+		u.HashedPassword = hashedPassword
+	}
+	return nil
+}
+
+// SetName changes the name that a user's snippets are shown with
+func (m *UserModel) SetName(id int, name string) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	if u, ok := m.DB.users[id]; ok {
+		u.Name = name
+	}
+	return nil
+}
+
+// SetEmail changes a user's email address, which then needs verifying again.
+// It returns models.ErrDuplicateEmail if another user already has the
+// address.
+func (m *UserModel) SetEmail(id int, email string) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	for _, u := range m.DB.users {
+		if strings.EqualFold(u.Email, email) && u.ID != id {
+			return models.ErrDuplicateEmail
+		}
+	}
+	if u, ok := m.DB.users[id]; ok {
+		u.Email = email
+		u.Verified = false
+	}
+	return nil
+}
+
+// ChangePassword replaces a user's password, but only if they know the
+// current one. Otherwise it returns models.ErrInvalidCredentials.
+func (m *UserModel) ChangePassword(id int, currentPassword, newPassword string) error {
+	// Copy what's needed so that bcrypt can run without the lock
+	m.DB.mu.RLock()
+	var hashedPassword []byte
+	if u, ok := m.DB.users[id]; ok {
+		hashedPassword = u.HashedPassword
+	}
+	m.DB.mu.RUnlock()
+
+	if hashedPassword == nil {
+		return models.ErrInvalidCredentials
+	}
+	err := bcrypt.CompareHashAndPassword(hashedPassword, []byte(currentPassword))
+	if err != nil {
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return models.ErrInvalidCredentials
+		}
+		return err
+	}
+	return m.SetPassword(id, newPassword)
+}
+
+// SetTOTPSecret turns on two-factor authentication for a user with the
+// already encrypted secret, or turns it off again if the secret is nil. Codes
+// from before are forgotten either way.
+func (m *UserModel) SetTOTPSecret(id int, secret []byte) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	if u, ok := m.DB.users[id]; ok {
+		u.TOTPSecret = secret
+		u.totpLastStep = 0
+	}
+	return nil
+}
+
+// UseTOTPStep records that the user has logged in with the code for a step,
+// or returns models.ErrInvalidCredentials if the step, or a later one, has
+// been used already
+func (m *UserModel) UseTOTPStep(id int, step int64) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	u, ok := m.DB.users[id]
+	if !ok || u.totpLastStep >= step {
+		return models.ErrInvalidCredentials
+	}
+	u.totpLastStep = step
+	return nil
+}
+
+// SetRole changes what a user is allowed to do, to either models.RoleUser or
+// models.RoleAdmin
+func (m *UserModel) SetRole(id int, role string) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	if u, ok := m.DB.users[id]; ok {
+		u.Role = role
+	}
+	return nil
+}
+
+// LoginFailures returns how many times in a row logging in as email has
+// failed, and when it last did. It's zero and the zero time if it hasn't.
+func (m *UserModel) LoginFailures(email string) (int, time.Time, error) {
+	m.DB.mu.RLock()
+	defer m.DB.mu.RUnlock()
+
+	f, ok := m.DB.loginFailures[email]
+	if !ok {
+		return 0, time.Time{}, nil
+	}
+	return f.count, f.last, nil
+}
+
+// RecordLoginFailure counts another failed login as email
+func (m *UserModel) RecordLoginFailure(email string) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	f, ok := m.DB.loginFailures[email]
+	if !ok {
+		f = &loginFailures{}
+		m.DB.loginFailures[email] = f
+	}
+	f.count++
+	f.last = now()
+	return nil
+}
+
+// ResetLoginFailures forgets the failed logins as email, which happens when
+// one succeeds
+func (m *UserModel) ResetLoginFailures(email string) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	delete(m.DB.loginFailures, email)
+	return nil
+}
@@ -0,0 +1,261 @@
+// Package migrate applies versioned schema changes to a database. Each
+// backend ships its migrations inside the binary as pairs of files named like
+// 0001_initial_schema.up.sql and 0001_initial_schema.down.sql. The versions
+// that have been applied are recorded in the schema_migrations table.
+package migrate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migration is one versioned change to the schema
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration and when it was applied, which is the zero time if
+// it hasn't been.
+type Status struct {
+	Migration
+	Applied time.Time
+}
+
+// Pending reports whether the migration still has to be applied
+func (s *Status) Pending() bool {
+	return s.Applied.IsZero()
+}
+
+// Migrator applies the migrations for one database
+type Migrator struct {
+	db         *sql.DB
+	dialect    string
+	migrations []Migration
+}
+
+var fileRX = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// New loads the migrations in the top directory of fsys. The dialect is
+// "mysql", "postgres" or "sqlite", which only matters for placeholders.
+func New(db *sql.DB, dialect string, fsys fs.FS) (*Migrator, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		if e.IsDir() || path.Ext(e.Name()) != ".sql" {
+			continue
+		}
+		matches := fileRX.FindStringSubmatch(e.Name())
+		if matches == nil {
+			return nil, fmt.Errorf("migrate: badly named migration %s", e.Name())
+		}
+
+		version, _ := strconv.Atoi(matches[1])
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = m
+		}
+		if m.Name != matches[2] {
+			return nil, fmt.Errorf("migrate: version %d is both %s and %s", version, m.Name, matches[2])
+		}
+
+		b, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		if matches[3] == "up" {
+			m.Up = string(b)
+		} else {
+			m.Down = string(b)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, m := range byVersion {
+		// Every change has to be reversible
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migrate: version %d needs both an up and a down file", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}, nil
+}
+
+// Status lists every migration, oldest first, along with when it was applied
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []Status{}
+	for _, mg := range m.migrations {
+		statuses = append(statuses, Status{Migration: mg, Applied: applied[mg.Version]})
+	}
+	return statuses, nil
+}
+
+// Up applies every pending migration in order and returns the ones that it
+// applied. It stops at the first one that fails.
+func (m *Migrator) Up() ([]Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	done := []Migration{}
+	for _, mg := range m.migrations {
+		if _, ok := applied[mg.Version]; ok {
+			continue
+		}
+		err = m.run(mg, mg.Up, `INSERT INTO schema_migrations (version, name, applied) VALUES (?, ?, ?)`,
+			mg.Version, mg.Name, time.Now().UTC())
+		if err != nil {
+			return done, err
+		}
+		done = append(done, mg)
+	}
+	return done, nil
+}
+
+// ErrNoChange is returned by Down when no migrations have been applied
+var ErrNoChange = errors.New("migrate: no migrations to undo")
+
+// Down undoes the most recently applied migration and returns it
+func (m *Migrator) Down() (*Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		mg := m.migrations[i]
+		if _, ok := applied[mg.Version]; !ok {
+			continue
+		}
+		err = m.run(mg, mg.Down, `DELETE FROM schema_migrations WHERE version = ?`, mg.Version)
+		if err != nil {
+			return nil, err
+		}
+		return &mg, nil
+	}
+	return nil, ErrNoChange
+}
+
+// Run the statements in script and then record, with the record statement,
+// that it happened. This is all in one transaction, although MySQL commits
+// after every change to a table's structure, so a failure there can leave a
+// migration half done.
+func (m *Migrator) run(mg Migration, script, record string, args ...interface{}) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range statements(script) {
+		if _, err = tx.Exec(stmt); err != nil {
+			return fmt.Errorf("migrate: version %d %s: %w", mg.Version, mg.Name, err)
+		}
+	}
+	if _, err = tx.Exec(m.rebind(record), args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// The versions that have been applied and when, creating the table that
+// keeps track of them if it isn't there yet.
+func (m *Migrator) applied() (map[int]time.Time, error) {
+	_, err := m.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER NOT NULL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.db.Query(`SELECT version, applied FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var t time.Time
+		if err = rows.Scan(&version, &t); err != nil {
+			return nil, err
+		}
+		applied[version] = t
+	}
+	return applied, rows.Err()
+}
+
+// Postgres numbers its placeholders rather than using question marks
+func (m *Migrator) rebind(stmt string) string {
+	if m.dialect != "postgres" {
+		return stmt
+	}
+	n := 0
+	var b strings.Builder
+	for _, r := range stmt {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Split a script into statements. Not every driver will run several
+// statements at once, so each one has to end with a semicolon at the end of
+// a line. That keeps this simple, at the cost of not being able to put a
+// semicolon at the end of a line inside a statement.
+func statements(script string) []string {
+	stmts := []string{}
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(strings.TrimSpace(line), ";") {
+			if stmt := strings.TrimSpace(current.String()); !isComment(stmt) {
+				stmts = append(stmts, stmt)
+			}
+			current.Reset()
+		}
+	}
+	if stmt := strings.TrimSpace(current.String()); stmt != "" && !isComment(stmt) {
+		stmts = append(stmts, stmt)
+	}
+	return stmts
+}
+
+// Report whether stmt is nothing but a comment
+func isComment(stmt string) bool {
+	return strings.HasPrefix(stmt, "/*") && strings.HasSuffix(stmt, "*/") ||
+		strings.HasPrefix(stmt, "--") && !strings.Contains(stmt, "\n")
+}
@@ -0,0 +1,189 @@
+package migrate
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Two migrations, the second of which depends on the first
+var testMigrations = fstest.MapFS{
+	"0001_create_things.up.sql": {Data: []byte(`/* Things */
+CREATE TABLE things (id INTEGER PRIMARY KEY);
+CREATE INDEX idx_things ON things(id);
+`)},
+	"0001_create_things.down.sql": {Data: []byte("DROP TABLE things;\n")},
+	"0002_add_name.up.sql":        {Data: []byte("ALTER TABLE things ADD COLUMN name TEXT;\n")},
+	"0002_add_name.down.sql":      {Data: []byte("ALTER TABLE things DROP COLUMN name;\n")},
+	"README.md":                   {Data: []byte("Not a migration")},
+}
+
+func newTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func versions(migrations []Migration) []int {
+	v := []int{}
+	for _, m := range migrations {
+		v = append(v, m.Version)
+	}
+	return v
+}
+
+func TestUpAndDown(t *testing.T) {
+	db := newTestDB(t)
+	m, err := New(db, "sqlite", testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 || !statuses[0].Pending() || !statuses[1].Pending() {
+		t.Fatalf("want two pending migrations; got %+v", statuses)
+	}
+
+	done, err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(done); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("want versions [1 2] applied; got %v", got)
+	}
+	if _, err = db.Exec(`INSERT INTO things (id, name) VALUES (1, 'one')`); err != nil {
+		t.Errorf("want the schema to be there; got %v", err)
+	}
+
+	// Running it again does nothing
+	done, err = m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 0 {
+		t.Errorf("want nothing applied; got %v", versions(done))
+	}
+
+	undone, err := m.Down()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if undone.Version != 2 {
+		t.Errorf("want version 2 undone; got %d", undone.Version)
+	}
+	statuses, err = m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statuses[0].Pending() || !statuses[1].Pending() {
+		t.Errorf("want only version 1 applied; got %+v", statuses)
+	}
+
+	if _, err = m.Down(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Down(); !errors.Is(err, ErrNoChange) {
+		t.Errorf("want %v; got %v", ErrNoChange, err)
+	}
+}
+
+func TestFailedMigrationIsNotRecorded(t *testing.T) {
+	db := newTestDB(t)
+	m, err := New(db, "sqlite", fstest.MapFS{
+		"0001_broken.up.sql":   {Data: []byte("CREATE TABLE ok (id INTEGER);\nNOT SQL AT ALL;\n")},
+		"0001_broken.down.sql": {Data: []byte("DROP TABLE ok;\n")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = m.Up(); err == nil {
+		t.Fatal("want an error; got nil")
+	}
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !statuses[0].Pending() {
+		t.Error("want the broken migration to still be pending")
+	}
+	// And the half that worked was rolled back
+	if _, err = db.Exec(`SELECT * FROM ok`); err == nil {
+		t.Error("want table ok to have been rolled back")
+	}
+}
+
+func TestNewRejectsBadMigrations(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{"Missing down", fstest.MapFS{
+			"0001_a.up.sql": {Data: []byte("SELECT 1;")},
+		}},
+		{"Bad name", fstest.MapFS{
+			"first.up.sql": {Data: []byte("SELECT 1;")},
+		}},
+		{"Clashing versions", fstest.MapFS{
+			"0001_a.up.sql":   {Data: []byte("SELECT 1;")},
+			"0001_a.down.sql": {Data: []byte("SELECT 1;")},
+			"0001_b.up.sql":   {Data: []byte("SELECT 1;")},
+			"0001_b.down.sql": {Data: []byte("SELECT 1;")},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(nil, "sqlite", tt.fsys); err == nil {
+				t.Error("want an error; got nil")
+			}
+		})
+	}
+}
+
+func TestStatements(t *testing.T) {
+	script := `/* A comment before the first statement */
+CREATE TABLE a (id INTEGER);
+
+/* A comment before a statement */
+CREATE TABLE b (
+    id INTEGER
+);
+INSERT INTO a VALUES (1);
+/* A comment on its own at the end */`
+
+	want := []string{
+		"/* A comment before the first statement */\nCREATE TABLE a (id INTEGER);",
+		"/* A comment before a statement */\nCREATE TABLE b (\n    id INTEGER\n);",
+		"INSERT INTO a VALUES (1);",
+	}
+	if got := statements(script); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %q; got %q", want, got)
+	}
+
+	// A migration that has nothing to do for one driver is just a comment
+	if got := statements("/* Nothing to do */\n"); len(got) != 0 {
+		t.Errorf("want no statements; got %q", got)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	stmt := `INSERT INTO t VALUES (?, ?)`
+	if got := (&Migrator{dialect: "postgres"}).rebind(stmt); got != `INSERT INTO t VALUES ($1, $2)` {
+		t.Errorf("want numbered placeholders; got %q", got)
+	}
+	if got := (&Migrator{dialect: "mysql"}).rebind(stmt); got != stmt {
+		t.Errorf("want %q unchanged; got %q", stmt, got)
+	}
+}
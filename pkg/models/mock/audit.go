@@ -0,0 +1,47 @@
+package mock
+
+import (
+	"dvhthomas/snippetbox/pkg/models"
+	"time"
+)
+
+// AuditModel keeps the events in Events, oldest first, so that tests can
+// check what was recorded
+type AuditModel struct {
+	Events []*models.AuditEvent
+}
+
+// Insert records an event
+func (m *AuditModel) Insert(userID int, action, target, ip, userAgent string) error {
+	m.Events = append(m.Events, &models.AuditEvent{
+		ID:        len(m.Events) + 1,
+		UserID:    userID,
+		Action:    action,
+		Target:    target,
+		IP:        ip,
+		UserAgent: userAgent,
+		Created:   time.Now(),
+	})
+	return nil
+}
+
+// List the recorded events, newest first
+func (m *AuditModel) List(page int) ([]*models.AuditEvent, error) {
+	events := []*models.AuditEvent{}
+	start := len(m.Events) - 1 - (page-1)*models.AuditEventsPerPage
+	for i := start; i >= 0 && len(events) < models.AuditEventsPerPage; i-- {
+		events = append(events, m.Events[i])
+	}
+	return events, nil
+}
+
+// Before lists the recorded events with IDs below id, newest first
+func (m *AuditModel) Before(id int) ([]*models.AuditEvent, error) {
+	events := []*models.AuditEvent{}
+	for i := len(m.Events) - 1; i >= 0 && len(events) < models.AuditEventsPerPage; i-- {
+		if id == 0 || m.Events[i].ID < id {
+			events = append(events, m.Events[i])
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,35 @@
+package mock
+
+import (
+	"dvhthomas/snippetbox/pkg/models"
+	"errors"
+)
+
+// IdentityModel for non-existent database. Users holds the user linked to
+// each issuer and subject, so that tests can see who was linked.
+type IdentityModel struct {
+	Users map[[2]string]int
+}
+
+// Insert links the user in Users, and fails like the database would if the
+// subject is already linked
+func (m *IdentityModel) Insert(userID int, issuer, subject string) error {
+	if m.Users == nil {
+		m.Users = map[[2]string]int{}
+	}
+	key := [2]string{issuer, subject}
+	if _, ok := m.Users[key]; ok {
+		return errors.New("mock: identity is already linked to a user")
+	}
+	m.Users[key] = userID
+	return nil
+}
+
+// Get looks the user up in Users
+func (m *IdentityModel) Get(issuer, subject string) (int, error) {
+	userID, ok := m.Users[[2]string{issuer, subject}]
+	if !ok {
+		return 0, models.ErrNoRecord
+	}
+	return userID, nil
+}
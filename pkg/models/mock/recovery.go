@@ -0,0 +1,50 @@
+package mock
+
+import "dvhthomas/snippetbox/pkg/models"
+
+// RecoveryCodeModel for non-existent database. Codes holds each user's unused
+// recovery codes, so that tests can use them.
+type RecoveryCodeModel struct {
+	Codes map[int][]string
+}
+
+// Replace gives the user a new set of real codes
+func (m *RecoveryCodeModel) Replace(userID int) ([]string, error) {
+	codes := make([]string, models.RecoveryCodeCount)
+	for i := range codes {
+		code, _, err := models.NewRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+	}
+
+	if m.Codes == nil {
+		m.Codes = map[int][]string{}
+	}
+	m.Codes[userID] = codes
+	return append([]string(nil), codes...), nil
+}
+
+// Consume removes the code from Codes, however it was typed
+func (m *RecoveryCodeModel) Consume(userID int, code string) error {
+	codes := m.Codes[userID]
+	for i, c := range codes {
+		if models.HashRecoveryCode(c) == models.HashRecoveryCode(code) {
+			m.Codes[userID] = append(codes[:i:i], codes[i+1:]...)
+			return nil
+		}
+	}
+	return models.ErrInvalidCredentials
+}
+
+// Count returns how many codes the user has left in Codes
+func (m *RecoveryCodeModel) Count(userID int) (int, error) {
+	return len(m.Codes[userID]), nil
+}
+
+// Delete removes the user's codes from Codes
+func (m *RecoveryCodeModel) Delete(userID int) error {
+	delete(m.Codes, userID)
+	return nil
+}
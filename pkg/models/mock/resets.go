@@ -0,0 +1,29 @@
+package mock
+
+import (
+	"dvhthomas/snippetbox/pkg/models"
+	"time"
+)
+
+// PasswordResetModel for non-existent database
+type PasswordResetModel struct{}
+
+// Insert always hands out the same known token
+func (m *PasswordResetModel) Insert(userID int, ttl time.Duration) (string, error) {
+	return "valid-reset-token", nil
+}
+
+// Check the known token, which belongs to the mock user
+func (m *PasswordResetModel) Check(token string) (int, error) {
+	switch token {
+	case "valid-reset-token":
+		return 1, nil
+	default:
+		return 0, models.ErrInvalidCredentials
+	}
+}
+
+// Consume pretends to use up the known token
+func (m *PasswordResetModel) Consume(token string) (int, error) {
+	return m.Check(token)
+}
@@ -0,0 +1,78 @@
+package mock
+
+import (
+	"dvhthomas/snippetbox/pkg/models"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// SessionModel for non-existent database. Logging in has to work across
+// requests, so unlike most of the mocks it keeps what it's given. Sessions
+// holds each one by its token, so that tests can see who is logged in where.
+type SessionModel struct {
+	Sessions map[string]*models.Session
+	lastID   int
+}
+
+// Insert starts a session with a token made from its ID
+func (m *SessionModel) Insert(userID int, ip, userAgent string, ttl time.Duration) (string, error) {
+	if m.Sessions == nil {
+		m.Sessions = map[string]*models.Session{}
+	}
+	m.lastID++
+	now := time.Now()
+	token := fmt.Sprintf("session-token-%d", m.lastID)
+	m.Sessions[token] = &models.Session{
+		ID:        m.lastID,
+		UserID:    userID,
+		IP:        ip,
+		UserAgent: userAgent,
+		Created:   now,
+		LastSeen:  now,
+		Expires:   now.Add(ttl),
+	}
+	return token, nil
+}
+
+// Authenticate looks the token up in Sessions
+func (m *SessionModel) Authenticate(token, ip string) (*models.Session, error) {
+	s, ok := m.Sessions[token]
+	if !ok || !s.Expires.After(time.Now()) {
+		return nil, models.ErrInvalidCredentials
+	}
+	return s, nil
+}
+
+// List returns the user's sessions, the newest first
+func (m *SessionModel) List(userID int) ([]*models.Session, error) {
+	sessions := []*models.Session{}
+	for _, s := range m.Sessions {
+		if s.UserID == userID {
+			sessions = append(sessions, s)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID > sessions[j].ID })
+	return sessions, nil
+}
+
+// Delete removes one of the user's sessions from Sessions
+func (m *SessionModel) Delete(userID, id int) error {
+	for token, s := range m.Sessions {
+		if s.ID == id && s.UserID == userID {
+			delete(m.Sessions, token)
+			return nil
+		}
+	}
+	return models.ErrNoRecord
+}
+
+// DeleteAll removes all of the user's sessions from Sessions
+func (m *SessionModel) DeleteAll(userID int) error {
+	for token, s := range m.Sessions {
+		if s.UserID == userID {
+			delete(m.Sessions, token)
+		}
+	}
+	return nil
+}
@@ -2,36 +2,253 @@ package mock
 
 import (
 	"dvhthomas/snippetbox/pkg/models"
+	"strings"
 	"time"
 )
 
 var mockSnippet = &models.Snippet{
-	ID:      1,
-	Title:   "An old silent pond",
-	Content: "And old silent pond...",
-	Created: time.Now(),
-	Expires: time.Now(),
+	ID:         1,
+	Title:      "An old silent pond",
+	Content:    "And old silent pond...",
+	Visibility: models.VisibilityPublic,
+	Slug:       "s1LentP0ndAa",
+	Created:    time.Now(),
+	Expires:    time.Now(),
+	AuthorID:   1,
+	AuthorName: "Alice",
 }
 
-// SnippetModel for non-existent database
-type SnippetModel struct{}
+// Owned by somebody other than the mock user so that we can check that
+// only authors can change their snippets.
+var mockOtherSnippet = &models.Snippet{
+	ID:         3,
+	Title:      "Over the wintry forest",
+	Content:    "Over the wintry forest...",
+	Language:   "go",
+	Visibility: models.VisibilityPublic,
+	Slug:       "w1ntryF0rest",
+	Created:    time.Now().Add(-time.Hour),
+	Expires:    time.Now(),
+	AuthorID:   2,
+	AuthorName: "Bob",
+}
+
+// Hidden snippets that should never turn up in any list
+var mockUnlistedSnippet = &models.Snippet{
+	ID:         4,
+	Title:      "First autumn morning",
+	Content:    "First autumn morning...",
+	Visibility: models.VisibilityUnlisted,
+	Slug:       "uNl1sTeDsLuG",
+	Created:    time.Now(),
+	Expires:    time.Now(),
+	AuthorID:   2,
+	AuthorName: "Bob",
+}
+
+var mockPrivateSnippet = &models.Snippet{
+	ID:         5,
+	Title:      "Bob's secret",
+	Content:    "Nobody else can see this",
+	Visibility: models.VisibilityPrivate,
+	Slug:       "b0bsS3cretXx",
+	Created:    time.Now(),
+	Expires:    time.Now(),
+	AuthorID:   2,
+	AuthorName: "Bob",
+}
+
+var mockOwnPrivateSnippet = &models.Snippet{
+	ID:         6,
+	Title:      "Alice's secret",
+	Content:    "Only Alice can see this",
+	Visibility: models.VisibilityPrivate,
+	Slug:       "al1cesS3cret",
+	Created:    time.Now(),
+	Expires:    time.Now(),
+	AuthorID:   1,
+	AuthorName: "Alice",
+}
+
+// All of the known records, and the public ones which are newest first
+var mockSnippets = []*models.Snippet{
+	mockSnippet, mockOtherSnippet, mockUnlistedSnippet, mockPrivateSnippet, mockOwnPrivateSnippet,
+}
+var mockPublicSnippets = []*models.Snippet{mockSnippet, mockOtherSnippet}
+
+// SnippetModel for non-existent database. Inserted and updated snippets are
+// kept so that they can be read back, which is what the handlers do after
+// changing one.
+type SnippetModel struct {
+	changed map[int]*models.Snippet
+	// Expired is the number of expired snippets waiting to be deleted
+	Expired int
+}
+
+func (m *SnippetModel) save(s *models.Snippet) {
+	if m.changed == nil {
+		m.changed = map[int]*models.Snippet{}
+	}
+	m.changed[s.ID] = s
+}
 
 // Insert a fake record
-func (m *SnippetModel) Insert(title, content, expires string) (int, error) {
+func (m *SnippetModel) Insert(userID int, title, content, language, visibility, expires string) (int, error) {
+	s := &models.Snippet{
+		ID:         2,
+		Title:      title,
+		Content:    content,
+		Language:   language,
+		Visibility: visibility,
+		Slug:       "n3wSn1pp3tSl",
+		Created:    time.Now(),
+		Expires:    time.Now(),
+		AuthorID:   userID,
+	}
+	m.save(s)
 	return 2, nil
 }
 
-// Get a predictable value
+// Get a predictable value. Callers get a copy so that they can't change the
+// known records for everyone else.
 func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
-	switch id {
-	case 1:
-		return mockSnippet, nil
-	default:
-		return nil, models.ErrNoRecord
+	if s, ok := m.changed[id]; ok {
+		c := *s
+		return &c, nil
+	}
+	for _, s := range mockSnippets {
+		if s.ID == id {
+			c := *s
+			return &c, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+// GetBySlug finds a known record the same way as Get
+func (m *SnippetModel) GetBySlug(slug string) (*models.Snippet, error) {
+	for _, s := range m.changed {
+		if s.Slug == slug {
+			c := *s
+			return &c, nil
+		}
+	}
+	for _, s := range mockSnippets {
+		if s.Slug == slug {
+			c := *s
+			return &c, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+// Update changes a copy of a known record
+func (m *SnippetModel) Update(id int, title, content, language, visibility string) error {
+	s, err := m.Get(id)
+	if err != nil {
+		return err
+	}
+	s.Title, s.Content, s.Language, s.Visibility = title, content, language, visibility
+	m.save(s)
+	return nil
+}
+
+// Delete pretends to remove a known record
+func (m *SnippetModel) Delete(id int) error {
+	_, err := m.Get(id)
+	return err
+}
+
+// DeleteExpired pretends to delete up to limit of the expired snippets
+func (m *SnippetModel) DeleteExpired(limit int) (int, error) {
+	n := m.Expired
+	if n > limit {
+		n = limit
+	}
+	m.Expired -= n
+	return n, nil
+}
+
+// ByAuthor has the known records by the user, whatever their visibility
+func (m *SnippetModel) ByAuthor(userID int) ([]*models.Snippet, error) {
+	snippets := []*models.Snippet{}
+	for _, s := range mockSnippets {
+		if s.AuthorID == userID {
+			snippets = append(snippets, s)
+		}
 	}
+	return snippets, nil
 }
 
-// Latest containing known records
+// Latest containing known public records
 func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
-	return []*models.Snippet{mockSnippet}, nil
+	return mockPublicSnippets, nil
+}
+
+// List pages through the known public records
+func (m *SnippetModel) List(cursor *models.Cursor, limit int) ([]*models.Snippet, error) {
+	all := mockPublicSnippets
+	if cursor == nil {
+		if len(all) > limit {
+			all = all[:limit]
+		}
+		return all, nil
+	}
+
+	snippets := []*models.Snippet{}
+	for _, s := range all {
+		newer := s.Created.After(cursor.Created) ||
+			(s.Created.Equal(cursor.Created) && s.ID > cursor.ID)
+		older := s.Created.Before(cursor.Created) ||
+			(s.Created.Equal(cursor.Created) && s.ID < cursor.ID)
+		if (cursor.Before && newer) || (!cursor.Before && older) {
+			snippets = append(snippets, s)
+		}
+	}
+
+	if len(snippets) > limit {
+		// Going backwards we want the ones nearest to the cursor, which are
+		// at the end of the list.
+		if cursor.Before {
+			snippets = snippets[len(snippets)-limit:]
+		} else {
+			snippets = snippets[:limit]
+		}
+	}
+	return snippets, nil
+}
+
+// Search the known public records in memory. A snippet matches if its title or
+// content contains any of the words in the query, ignoring case.
+func (m *SnippetModel) Search(query string, page int) ([]*models.Snippet, error) {
+	terms := strings.Fields(strings.ToLower(query))
+
+	matches := []*models.Snippet{}
+	for _, s := range mockPublicSnippets {
+		text := strings.ToLower(s.Title + " " + s.Content)
+		for _, term := range terms {
+			if strings.Contains(text, term) {
+				matches = append(matches, s)
+				break
+			}
+		}
+	}
+
+	start := (page - 1) * models.SearchResultsPerPage
+	if start >= len(matches) {
+		return []*models.Snippet{}, nil
+	}
+	end := start + models.SearchResultsPerPage
+	if end > len(matches) {
+		end = len(matches)
+	}
+	return matches[start:end], nil
+}
+
+// All of the known records, whatever their visibility
+func (m *SnippetModel) All(page int) ([]*models.Snippet, error) {
+	if page > 1 {
+		return []*models.Snippet{}, nil
+	}
+	return mockSnippets, nil
 }
@@ -0,0 +1,48 @@
+package mock
+
+import (
+	"dvhthomas/snippetbox/pkg/models"
+	"time"
+)
+
+var mockAPIToken = &models.APIToken{
+	ID:       1,
+	UserID:   1,
+	Name:     "Deploy script",
+	Created:  time.Now(),
+	LastUsed: time.Now(),
+}
+
+// TokenModel for non-existent database
+type TokenModel struct{}
+
+// Insert always hands out the same known token
+func (m *TokenModel) Insert(userID int, name string) (string, error) {
+	return "new-token", nil
+}
+
+// List the known token for the mock user
+func (m *TokenModel) List(userID int) ([]*models.APIToken, error) {
+	if userID != mockAPIToken.UserID {
+		return []*models.APIToken{}, nil
+	}
+	return []*models.APIToken{mockAPIToken}, nil
+}
+
+// Delete pretends to revoke the known token
+func (m *TokenModel) Delete(userID, id int) error {
+	if userID != mockAPIToken.UserID || id != mockAPIToken.ID {
+		return models.ErrNoRecord
+	}
+	return nil
+}
+
+// Authenticate the known token as the mock user
+func (m *TokenModel) Authenticate(token string) (int, error) {
+	switch token {
+	case "valid-token":
+		return mockAPIToken.UserID, nil
+	default:
+		return 0, models.ErrInvalidCredentials
+	}
+}
@@ -6,15 +6,61 @@ import (
 )
 
 var mockUser = &models.User{
-	ID:      1,
-	Name:    "Alice",
-	Email:   "alice@example.com",
+	ID:       1,
+	Name:     "Alice",
+	Email:    "alice@example.com",
+	Created:  time.Now(),
+	Active:   true,
+	Role:     models.RoleUser,
+	Verified: true,
+}
+
+// An admin, for the admin console
+var mockAdmin = &models.User{
+	ID:       3,
+	Name:     "Carol",
+	Email:    "carol@example.com",
+	Created:  time.Now(),
+	Active:   true,
+	Role:     models.RoleAdmin,
+	Verified: true,
+}
+
+// Somebody who has signed up but not followed the link in their email yet
+var mockUnverified = &models.User{
+	ID:      4,
+	Name:    "Dave",
+	Email:   "dave@example.com",
 	Created: time.Now(),
 	Active:  true,
+	Role:    models.RoleUser,
 }
 
-// UserModel for non-existent database
-type UserModel struct{}
+// UserModel for non-existent database. Failed logins are counted in
+// Failures, by email address, so that tests can check and change them.
+// SignedUp holds the users that Insert has added, which can be found by
+// email address afterwards, and VerifiedIDs lists the users that SetVerified
+// has verified. TOTPSecrets holds the encrypted secrets of the users who have
+// turned on two-factor authentication, and TOTPSteps the last step each one
+// used a code for. Names, Emails and Passwords hold what users have changed
+// theirs to, since the known users can't be changed.
+type UserModel struct {
+	Failures    map[string]*LoginFailures
+	SignedUp    []*models.User
+	VerifiedIDs []int
+	TOTPSecrets map[int][]byte
+	TOTPSteps   map[int]int64
+	Names       map[int]string
+	Emails      map[int]string
+	Passwords   map[int]string
+}
+
+// LoginFailures is how many times logging in as an email address has failed
+// and when it last did
+type LoginFailures struct {
+	Count int
+	Last  time.Time
+}
 
 // Insert a known model
 func (m *UserModel) Insert(name, email, password string) error {
@@ -22,26 +68,224 @@ func (m *UserModel) Insert(name, email, password string) error {
 	case "dupe@example.com":
 		return models.ErrDuplicateEmail
 	default:
+		m.SignedUp = append(m.SignedUp, &models.User{
+			ID:      100 + len(m.SignedUp),
+			Name:    name,
+			Email:   email,
+			Created: time.Now(),
+			Active:  true,
+			Role:    models.RoleUser,
+		})
 		return nil
 	}
 }
 
-// Authenticate a known user
+// Authenticate a known user. Their password is "validPa$$word" unless it's
+// been changed in Passwords.
 func (m *UserModel) Authenticate(email, password string) (int, error) {
-	switch email {
-	case "alice@example.com":
-		return 1, nil
-	default:
+	ids := map[string]int{"alice@example.com": 1, "carol@example.com": 3, "dave@example.com": 4}
+	id, ok := ids[email]
+	want, changed := m.Passwords[id]
+	if !changed {
+		want = "validPa$$word"
+	}
+	if !ok || password != want {
 		return 0, models.ErrInvalidCredentials
 	}
+	if id == 4 {
+		return 0, models.ErrUnverified
+	}
+	return id, nil
 }
 
 // Get a known user and known failure case
 func (m *UserModel) Get(id int) (*models.User, error) {
 	switch id {
 	case 1:
-		return mockUser, nil
-	default:
-		return nil, models.ErrNoRecord
+		return m.current(mockUser), nil
+	case 3:
+		return m.current(mockAdmin), nil
+	case 4:
+		return m.current(mockUnverified), nil
+	}
+	for _, u := range m.SignedUp {
+		if u.ID == id {
+			return m.current(u), nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+// GetByEmail finds the known user
+func (m *UserModel) GetByEmail(email string) (*models.User, error) {
+	switch email {
+	case "alice@example.com":
+		return m.current(mockUser), nil
+	case "carol@example.com":
+		return m.current(mockAdmin), nil
+	case "dave@example.com":
+		return m.current(mockUnverified), nil
+	}
+	for _, u := range m.SignedUp {
+		if u.Email == email {
+			return m.current(u), nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+// Fill in the user's secret from TOTPSecrets, and anything they've changed
+// from Names and Emails. The known users are shared by every test, so the
+// ones with any of those are copies.
+func (m *UserModel) current(u *models.User) *models.User {
+	secret, hasSecret := m.TOTPSecrets[u.ID]
+	name, hasName := m.Names[u.ID]
+	email, hasEmail := m.Emails[u.ID]
+	if !hasSecret && !hasName && !hasEmail {
+		return u
+	}
+	c := *u
+	if hasSecret {
+		c.TOTPSecret = secret
+	}
+	if hasName {
+		c.Name = name
+	}
+	if hasEmail {
+		// A new address needs verifying again
+		c.Email, c.Verified = email, false
+	}
+	return &c
+}
+
+// List has the known users
+func (m *UserModel) List() ([]*models.User, error) {
+	return []*models.User{mockUser, mockAdmin, mockUnverified}, nil
+}
+
+// SetActive changes the users in SignedUp, and pretends to work for the
+// others since they're shared by every test
+func (m *UserModel) SetActive(id int, active bool) error {
+	for _, u := range m.SignedUp {
+		if u.ID == id {
+			u.Active = active
+		}
+	}
+	return nil
+}
+
+// SetVerified records the users it verifies in VerifiedIDs. Only the users in
+// SignedUp really change, since the others are shared by every test.
+func (m *UserModel) SetVerified(id int, verified bool) error {
+	if verified {
+		m.VerifiedIDs = append(m.VerifiedIDs, id)
+	}
+	for _, u := range m.SignedUp {
+		if u.ID == id {
+			u.Verified = verified
+		}
 	}
+	return nil
+}
+
+// SetPassword pretends to work
+func (m *UserModel) SetPassword(id int, password string) error {
+	return nil
+}
+
+// SetName records the new name in Names
+func (m *UserModel) SetName(id int, name string) error {
+	if m.Names == nil {
+		m.Names = map[int]string{}
+	}
+	m.Names[id] = name
+	return nil
+}
+
+// SetEmail records the new address in Emails, unless it's one that's taken.
+// SetVerified doesn't change it back, so the user stays unverified.
+func (m *UserModel) SetEmail(id int, email string) error {
+	switch email {
+	case "dupe@example.com", "alice@example.com", "carol@example.com", "dave@example.com":
+		return models.ErrDuplicateEmail
+	}
+	if m.Emails == nil {
+		m.Emails = map[int]string{}
+	}
+	m.Emails[id] = email
+	return nil
+}
+
+// ChangePassword records the new password in Passwords if the current one is
+// the password every known user has
+func (m *UserModel) ChangePassword(id int, currentPassword, newPassword string) error {
+	if currentPassword != "validPa$$word" {
+		return models.ErrInvalidCredentials
+	}
+	if m.Passwords == nil {
+		m.Passwords = map[int]string{}
+	}
+	m.Passwords[id] = newPassword
+	return nil
+}
+
+// SetTOTPSecret records the secret in TOTPSecrets, or removes it if it's nil
+func (m *UserModel) SetTOTPSecret(id int, secret []byte) error {
+	if m.TOTPSecrets == nil {
+		m.TOTPSecrets = map[int][]byte{}
+	}
+	if secret == nil {
+		delete(m.TOTPSecrets, id)
+	} else {
+		m.TOTPSecrets[id] = secret
+	}
+	delete(m.TOTPSteps, id)
+	return nil
+}
+
+// UseTOTPStep records the step in TOTPSteps, unless it's been used already
+func (m *UserModel) UseTOTPStep(id int, step int64) error {
+	if m.TOTPSteps == nil {
+		m.TOTPSteps = map[int]int64{}
+	}
+	if m.TOTPSteps[id] >= step {
+		return models.ErrInvalidCredentials
+	}
+	m.TOTPSteps[id] = step
+	return nil
+}
+
+// SetRole pretends to work
+func (m *UserModel) SetRole(id int, role string) error {
+	return nil
+}
+
+// LoginFailures returns what's in Failures
+func (m *UserModel) LoginFailures(email string) (int, time.Time, error) {
+	f, ok := m.Failures[email]
+	if !ok {
+		return 0, time.Time{}, nil
+	}
+	return f.Count, f.Last, nil
+}
+
+// RecordLoginFailure counts another failure in Failures
+func (m *UserModel) RecordLoginFailure(email string) error {
+	if m.Failures == nil {
+		m.Failures = map[string]*LoginFailures{}
+	}
+	f, ok := m.Failures[email]
+	if !ok {
+		f = &LoginFailures{}
+		m.Failures[email] = f
+	}
+	f.Count++
+	f.Last = time.Now()
+	return nil
+}
+
+// ResetLoginFailures removes the email address from Failures
+func (m *UserModel) ResetLoginFailures(email string) error {
+	delete(m.Failures, email)
+	return nil
 }
@@ -14,16 +14,64 @@ var ErrDuplicateEmail = errors.New("models: existing user with that email")
 // ErrInvalidCredentials when the user does not exist in a login or the password is invalid
 var ErrInvalidCredentials = errors.New("models: invalid user credentials")
 
-// Snippet represents a single snippet in the app
+// ErrUnverified when the password is right but the user hasn't verified their
+// email address yet
+var ErrUnverified = errors.New("models: email address not verified")
+
+// Who gets to see a snippet. Public snippets are listed everywhere and can be
+// read by ID. Unlisted snippets can only be reached through their unguessable
+// slug, and private snippets are only ever shown to their author.
+const (
+	VisibilityPublic   = "public"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPrivate  = "private"
+)
+
+// Snippet represents a single snippet in the app. AuthorName is looked up
+// from the users table so it's read-only as far as the models go. Language
+// is the name of a syntax highlighter, or empty for plain text. Slug is made
+// up when the snippet is created and never changes.
 type Snippet struct {
-	ID      int
-	Title   string
-	Content string
+	ID         int
+	Title      string
+	Content    string
+	Language   string
+	Visibility string
+	Slug       string
+	Created    time.Time
+	Expires    time.Time
+	AuthorID   int
+	AuthorName string
+}
+
+// SearchResultsPerPage is how many matches a single page of search results holds
+const SearchResultsPerPage = 10
+
+// AdminSnippetsPerPage is how many snippets a page of the admin console holds
+const AdminSnippetsPerPage = 50
+
+// Cursor marks a position in the snippet archive, which is ordered newest
+// first by creation time and then by ID. Snippets are normally listed from
+// *after* the cursor (older ones), but setting Before lists the ones that
+// come before it (newer ones) so we can page backwards too.
+type Cursor struct {
 	Created time.Time
-	Expires time.Time
+	ID      int
+	Before  bool
 }
 
-// User that owns snippets and can log in
+// What a user is allowed to do. Everybody starts out as a plain user and
+// admins are promoted with `web admin promote`.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// User that owns snippets and can log in. Users that aren't Active can't log
+// in or use their API tokens, and new users aren't Verified until they've
+// followed the link emailed to them. TOTPSecret is only set for users who
+// have turned on two-factor authentication, and it's encrypted by the
+// application before it gets here.
 type User struct {
 	ID             int
 	Name           string
@@ -31,4 +79,59 @@ type User struct {
 	HashedPassword []byte
 	Created        time.Time
 	Active         bool
+	Role           string
+	Verified       bool
+	TOTPSecret     []byte
+}
+
+// Session is a browser that a user has logged in on. The token that picks it
+// out lives in the session cookie, and only its hash is stored, like API
+// tokens. IP is where it was last seen from, and UserAgent is what it said it
+// was when the user logged in.
+type Session struct {
+	ID        int
+	UserID    int
+	IP        string
+	UserAgent string
+	Created   time.Time
+	LastSeen  time.Time
+	Expires   time.Time
+}
+
+// SessionSeenInterval is how often a session's last seen time and IP address
+// are brought up to date. Writing them on every request would be a lot of
+// writes for not much.
+const SessionSeenInterval = time.Minute
+
+// RecoveryCodeCount is how many recovery codes a user gets at a time. Each
+// one logs them in once in place of a code from their authenticator app.
+const RecoveryCodeCount = 10
+
+// APIToken lets scripts and other non-browser clients act on behalf of a
+// user. Only a hash of the token itself is stored, so it can't be shown again
+// after it's created. LastUsed is zero if the token has never been used.
+type APIToken struct {
+	ID       int
+	UserID   int
+	Name     string
+	Created  time.Time
+	LastUsed time.Time
+}
+
+// AuditEventsPerPage is how many events a page of the audit log holds
+const AuditEventsPerPage = 50
+
+// AuditEvent records something security-relevant that somebody did, like
+// logging in or deactivating an account. UserID is who did it, or zero if
+// nobody was logged in, such as for a failed login. Target is what it was
+// done to, like "user:7" or "snippet:42". Events are never changed or
+// deleted once they've been added.
+type AuditEvent struct {
+	ID        int
+	UserID    int
+	Action    string
+	Target    string
+	IP        string
+	UserAgent string
+	Created   time.Time
 }
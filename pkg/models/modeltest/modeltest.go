@@ -0,0 +1,1165 @@
+// Package modeltest is a conformance suite for the storage backends. Every
+// backend has to behave the same way as far as the application can tell, so
+// each one runs these tests against a fresh, empty store of its own.
+package modeltest
+
+import (
+	"dvhthomas/snippetbox/pkg/models"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Snippets is what the application needs from a snippet store
+type Snippets interface {
+	Insert(int, string, string, string, string, string) (int, error)
+	Get(int) (*models.Snippet, error)
+	GetBySlug(string) (*models.Snippet, error)
+	Update(int, string, string, string, string) error
+	Delete(int) error
+	Latest() ([]*models.Snippet, error)
+	List(*models.Cursor, int) ([]*models.Snippet, error)
+	Search(string, int) ([]*models.Snippet, error)
+	All(int) ([]*models.Snippet, error)
+	ByAuthor(int) ([]*models.Snippet, error)
+	DeleteExpired(int) (int, error)
+}
+
+// Users is what the application needs from a user store
+type Users interface {
+	Insert(string, string, string) error
+	Authenticate(string, string) (int, error)
+	Get(int) (*models.User, error)
+	GetByEmail(string) (*models.User, error)
+	List() ([]*models.User, error)
+	SetActive(int, bool) error
+	SetVerified(int, bool) error
+	SetPassword(int, string) error
+	SetName(int, string) error
+	SetEmail(int, string) error
+	ChangePassword(int, string, string) error
+	SetRole(int, string) error
+	LoginFailures(string) (int, time.Time, error)
+	RecordLoginFailure(string) error
+	ResetLoginFailures(string) error
+	SetTOTPSecret(int, []byte) error
+	UseTOTPStep(int, int64) error
+}
+
+// Tokens is what the application needs from an API token store
+type Tokens interface {
+	Insert(int, string) (string, error)
+	List(int) ([]*models.APIToken, error)
+	Delete(int, int) error
+	Authenticate(string) (int, error)
+}
+
+// Audit is what the application needs from an audit log
+type Audit interface {
+	Insert(int, string, string, string, string) error
+	List(int) ([]*models.AuditEvent, error)
+	Before(int) ([]*models.AuditEvent, error)
+}
+
+// PasswordResets is what the application needs from a password reset store
+type PasswordResets interface {
+	Insert(int, time.Duration) (string, error)
+	Check(string) (int, error)
+	Consume(string) (int, error)
+}
+
+// RecoveryCodes is what the application needs from a recovery code store
+type RecoveryCodes interface {
+	Replace(int) ([]string, error)
+	Consume(int, string) error
+	Count(int) (int, error)
+	Delete(int) error
+}
+
+// Identities is what the application needs from an identity provider
+// account store
+type Identities interface {
+	Insert(int, string, string) error
+	Get(string, string) (int, error)
+}
+
+// Sessions is what the application needs from a login session store
+type Sessions interface {
+	Insert(int, string, string, time.Duration) (string, error)
+	Authenticate(string, string) (*models.Session, error)
+	List(int) ([]*models.Session, error)
+	Delete(int, int) error
+	DeleteAll(int) error
+}
+
+// Store is one backend's set of models, all sharing the same data
+type Store struct {
+	Snippets      Snippets
+	Users         Users
+	Tokens        Tokens
+	Audit         Audit
+	Resets        PasswordResets
+	RecoveryCodes RecoveryCodes
+	Identities    Identities
+	Sessions      Sessions
+}
+
+// Run the whole suite. newStore is called for every test and must return a
+// store with nothing in it.
+func Run(t *testing.T, newStore func(t *testing.T) *Store) {
+	tests := []struct {
+		name string
+		test func(*testing.T, *Store)
+	}{
+		{"Users", testUsers},
+		{"ManageUsers", testManageUsers},
+		{"Verification", testVerification},
+		{"Profile", testProfile},
+		{"LoginFailures", testLoginFailures},
+		{"Snippets", testSnippets},
+		{"Visibility", testVisibility},
+		{"Expiry", testExpiry},
+		{"List", testList},
+		{"Search", testSearch},
+		{"All", testAll},
+		{"ByAuthor", testByAuthor},
+		{"Tokens", testTokens},
+		{"Audit", testAudit},
+		{"AuditBefore", testAuditBefore},
+		{"PasswordResets", testPasswordResets},
+		{"TwoFactor", testTwoFactor},
+		{"RecoveryCodes", testRecoveryCodes},
+		{"Identities", testIdentities},
+		{"Sessions", testSessions},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore(t))
+		})
+	}
+}
+
+// Sign up a user who has verified their email address and return their ID
+func addUser(t *testing.T, s *Store, name, email string) int {
+	t.Helper()
+	if err := s.Users.Insert(name, email, "validPa$$word"); err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.Users.GetByEmail(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Users.SetVerified(u.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	id, err := s.Users.Authenticate(email, "validPa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// Insert a snippet that lasts a week and return its ID
+func addSnippet(t *testing.T, s *Store, userID int, title, content, visibility string) int {
+	t.Helper()
+	id, err := s.Snippets.Insert(userID, title, content, "", visibility, "7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func titles(snippets []*models.Snippet) []string {
+	t := []string{}
+	for _, s := range snippets {
+		t = append(t, s.Title)
+	}
+	return t
+}
+
+func wantTitles(t *testing.T, got []*models.Snippet, want ...string) {
+	t.Helper()
+	g := titles(got)
+	if len(g) != len(want) {
+		t.Fatalf("want %q; got %q", want, g)
+	}
+	for i := range want {
+		if g[i] != want[i] {
+			t.Fatalf("want %q; got %q", want, g)
+		}
+	}
+}
+
+func testUsers(t *testing.T, s *Store) {
+	id := addUser(t, s, "Alice", "alice@example.com")
+
+	u, err := s.Users.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Name != "Alice" || u.Email != "alice@example.com" || !u.Active || u.Role != models.RoleUser {
+		t.Errorf("want an active Alice who is a plain user; got %+v", u)
+	}
+	if time.Since(u.Created) > time.Minute {
+		t.Errorf("want created just now; got %s", u.Created)
+	}
+
+	err = s.Users.Insert("Alice again", "alice@example.com", "validPa$$word")
+	if !errors.Is(err, models.ErrDuplicateEmail) {
+		t.Errorf("want %v; got %v", models.ErrDuplicateEmail, err)
+	}
+
+	// Email addresses are the same whatever their case, so the handlers can
+	// take them as they're typed
+	err = s.Users.Insert("Alice again", "ALICE@example.com", "validPa$$word")
+	if !errors.Is(err, models.ErrDuplicateEmail) {
+		t.Errorf("want %v for a different case; got %v", models.ErrDuplicateEmail, err)
+	}
+	if got, err := s.Users.Authenticate("Alice@Example.com", "validPa$$word"); err != nil || got != id {
+		t.Errorf("want user %d whatever the case; got %d and %v", id, got, err)
+	}
+	if u, err = s.Users.GetByEmail("ALICE@EXAMPLE.COM"); err != nil || u.ID != id {
+		t.Errorf("want user %d whatever the case; got %+v and %v", id, u, err)
+	}
+
+	_, err = s.Users.Authenticate("alice@example.com", "wrongPa$$word")
+	if !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("want %v; got %v", models.ErrInvalidCredentials, err)
+	}
+	_, err = s.Users.Authenticate("nobody@example.com", "validPa$$word")
+	if !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("want %v; got %v", models.ErrInvalidCredentials, err)
+	}
+
+	_, err = s.Users.Get(id + 100)
+	if !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("want %v; got %v", models.ErrNoRecord, err)
+	}
+}
+
+func testManageUsers(t *testing.T, s *Store) {
+	aliceID := addUser(t, s, "Alice", "alice@example.com")
+	bobID := addUser(t, s, "Bob", "bob@example.com")
+
+	users, err := s.Users.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].ID != aliceID || users[1].ID != bobID {
+		t.Fatalf("want Alice then Bob; got %+v", users)
+	}
+
+	u, err := s.Users.GetByEmail("bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != bobID {
+		t.Errorf("want Bob's ID %d; got %d", bobID, u.ID)
+	}
+	_, err = s.Users.GetByEmail("nobody@example.com")
+	if !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("want %v; got %v", models.ErrNoRecord, err)
+	}
+
+	// Deactivated users can't log in but can still be found
+	if err = s.Users.SetActive(bobID, false); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Users.Authenticate("bob@example.com", "validPa$$word")
+	if !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("want %v; got %v", models.ErrInvalidCredentials, err)
+	}
+	u, err = s.Users.GetByEmail("bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Active {
+		t.Error("want Bob to be inactive")
+	}
+	if err = s.Users.SetActive(bobID, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Users.Authenticate("bob@example.com", "validPa$$word"); err != nil {
+		t.Errorf("want Bob to be able to log in again; got %v", err)
+	}
+
+	// Only the new password works after it's reset
+	if err = s.Users.SetPassword(aliceID, "newPa$$word123"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Users.Authenticate("alice@example.com", "validPa$$word")
+	if !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("want %v; got %v", models.ErrInvalidCredentials, err)
+	}
+	if _, err = s.Users.Authenticate("alice@example.com", "newPa$$word123"); err != nil {
+		t.Errorf("want the new password to work; got %v", err)
+	}
+
+	if err = s.Users.SetRole(aliceID, models.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	u, err = s.Users.Get(aliceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Role != models.RoleAdmin {
+		t.Errorf("want Alice to be an admin; got %q", u.Role)
+	}
+}
+
+func testVerification(t *testing.T, s *Store) {
+	if err := s.Users.Insert("Alice", "alice@example.com", "validPa$$word"); err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.Users.GetByEmail("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Verified {
+		t.Error("want a new user to be unverified")
+	}
+
+	// Only somebody with the right password finds out
+	_, err = s.Users.Authenticate("alice@example.com", "wrongPa$$word")
+	if !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("want %v; got %v", models.ErrInvalidCredentials, err)
+	}
+	_, err = s.Users.Authenticate("alice@example.com", "validPa$$word")
+	if !errors.Is(err, models.ErrUnverified) {
+		t.Errorf("want %v; got %v", models.ErrUnverified, err)
+	}
+
+	if err = s.Users.SetVerified(u.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := s.Users.Authenticate("alice@example.com", "validPa$$word"); err != nil || id != u.ID {
+		t.Errorf("want user %d; got %d and %v", u.ID, id, err)
+	}
+	if u, err = s.Users.Get(u.ID); err != nil || !u.Verified {
+		t.Errorf("want Alice verified; got %+v and %v", u, err)
+	}
+}
+
+func testProfile(t *testing.T, s *Store) {
+	id := addUser(t, s, "Alice", "alice@example.com")
+	addUser(t, s, "Bob", "bob@example.com")
+
+	if err := s.Users.SetName(id, "Alice Liddell"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new address has to be verified all over again
+	if err := s.Users.SetEmail(id, "bob@example.com"); !errors.Is(err, models.ErrDuplicateEmail) {
+		t.Errorf("want %v; got %v", models.ErrDuplicateEmail, err)
+	}
+	if err := s.Users.SetEmail(id, "Bob@Example.com"); !errors.Is(err, models.ErrDuplicateEmail) {
+		t.Errorf("want %v for a different case; got %v", models.ErrDuplicateEmail, err)
+	}
+	if err := s.Users.SetEmail(id, "alice@wonderland.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.Users.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Name != "Alice Liddell" || u.Email != "alice@wonderland.example.com" || u.Verified {
+		t.Errorf("want an unverified Alice Liddell at the new address; got %+v", u)
+	}
+	if _, err = s.Users.GetByEmail("alice@example.com"); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("want the old address gone; got %v", err)
+	}
+	if err = s.Users.SetVerified(id, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only somebody who knows the password can change it
+	err = s.Users.ChangePassword(id, "wrongPa$$word", "newPa$$word123")
+	if !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("want %v; got %v", models.ErrInvalidCredentials, err)
+	}
+	err = s.Users.ChangePassword(id+100, "validPa$$word", "newPa$$word123")
+	if !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("want %v for a missing user; got %v", models.ErrInvalidCredentials, err)
+	}
+	if err = s.Users.ChangePassword(id, "validPa$$word", "newPa$$word123"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Users.Authenticate("alice@wonderland.example.com", "validPa$$word"); !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("want the old password to stop working; got %v", err)
+	}
+	if got, err := s.Users.Authenticate("alice@wonderland.example.com", "newPa$$word123"); err != nil || got != id {
+		t.Errorf("want user %d with the new password; got %d and %v", id, got, err)
+	}
+}
+
+func testLoginFailures(t *testing.T, s *Store) {
+	// Failures are counted for any address, whether there's a user or not
+	for i := 0; i < 3; i++ {
+		if err := s.Users.RecordLoginFailure("nobody@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Users.RecordLoginFailure("alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	n, last, err := s.Users.LoginFailures("nobody@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("want 3 failures; got %d", n)
+	}
+	if time.Since(last) > time.Minute || time.Until(last) > time.Minute {
+		t.Errorf("want the last failure just now; got %s", last)
+	}
+
+	if err = s.Users.ResetLoginFailures("nobody@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	n, last, err = s.Users.LoginFailures("nobody@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 || !last.IsZero() {
+		t.Errorf("want no failures; got %d at %s", n, last)
+	}
+
+	// Resetting one address leaves the others alone
+	n, _, err = s.Users.LoginFailures("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("want 1 failure; got %d", n)
+	}
+}
+
+func testSnippets(t *testing.T, s *Store) {
+	userID := addUser(t, s, "Alice", "alice@example.com")
+
+	id, err := s.Snippets.Insert(userID, "An old silent pond", "A frog jumps", "go", models.VisibilityPublic, "7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Snippets.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "An old silent pond" || got.Content != "A frog jumps" || got.Language != "go" {
+		t.Errorf("want the snippet that was inserted; got %+v", got)
+	}
+	if got.AuthorID != userID || got.AuthorName != "Alice" {
+		t.Errorf("want author %d Alice; got %d %s", userID, got.AuthorID, got.AuthorName)
+	}
+	if len(got.Slug) != models.SlugLength {
+		t.Errorf("want a slug of length %d; got %q", models.SlugLength, got.Slug)
+	}
+	if d := got.Expires.Sub(got.Created); d < 7*24*time.Hour-time.Minute || d > 7*24*time.Hour+time.Minute {
+		t.Errorf("want it to expire in 7 days; got %s", d)
+	}
+
+	bySlug, err := s.Snippets.GetBySlug(got.Slug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bySlug.ID != id {
+		t.Errorf("want ID %d; got %d", id, bySlug.ID)
+	}
+
+	other := addSnippet(t, s, userID, "Another", "Content", models.VisibilityPublic)
+	if o, _ := s.Snippets.Get(other); o == nil || o.Slug == got.Slug {
+		t.Errorf("want every snippet to have its own slug")
+	}
+
+	err = s.Snippets.Update(id, "New title", "New content", "python", models.VisibilityUnlisted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := s.Snippets.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Title != "New title" || updated.Content != "New content" ||
+		updated.Language != "python" || updated.Visibility != models.VisibilityUnlisted {
+		t.Errorf("want the updated snippet; got %+v", updated)
+	}
+	if updated.Slug != got.Slug {
+		t.Errorf("want the slug to stay %q; got %q", got.Slug, updated.Slug)
+	}
+
+	if err = s.Snippets.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Snippets.Get(id); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("want %v; got %v", models.ErrNoRecord, err)
+	}
+	if err = s.Snippets.Delete(id); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("want %v; got %v", models.ErrNoRecord, err)
+	}
+	if _, err = s.Snippets.GetBySlug("nOtArEaLsLuG"); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("want %v; got %v", models.ErrNoRecord, err)
+	}
+}
+
+func testVisibility(t *testing.T, s *Store) {
+	userID := addUser(t, s, "Alice", "alice@example.com")
+	addSnippet(t, s, userID, "Public", "Pond", models.VisibilityPublic)
+	unlisted := addSnippet(t, s, userID, "Unlisted", "Pond", models.VisibilityUnlisted)
+	private := addSnippet(t, s, userID, "Private", "Pond", models.VisibilityPrivate)
+
+	// Hidden snippets can still be fetched, it's up to the handlers to decide
+	// who gets to see them.
+	for _, id := range []int{unlisted, private} {
+		if _, err := s.Snippets.Get(id); err != nil {
+			t.Errorf("want snippet %d; got %v", id, err)
+		}
+	}
+
+	latest, err := s.Snippets.Latest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTitles(t, latest, "Public")
+
+	list, err := s.Snippets.List(nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTitles(t, list, "Public")
+
+	found, err := s.Snippets.Search("pond", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTitles(t, found, "Public")
+}
+
+func testExpiry(t *testing.T, s *Store) {
+	userID := addUser(t, s, "Alice", "alice@example.com")
+	live := addSnippet(t, s, userID, "Live", "Content", models.VisibilityPublic)
+
+	// A negative number of days is in the past, which is the only way to make
+	// an expired snippet from outside
+	expired := []int{}
+	for i := 0; i < 3; i++ {
+		id, err := s.Snippets.Insert(userID, "Expired", "Content", "", models.VisibilityUnlisted, "-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expired = append(expired, id)
+	}
+
+	if _, err := s.Snippets.Get(expired[0]); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("want %v; got %v", models.ErrNoRecord, err)
+	}
+	latest, err := s.Snippets.Latest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTitles(t, latest, "Live")
+
+	n, err := s.Snippets.DeleteExpired(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("want %d deleted; got %d", 2, n)
+	}
+	n, err = s.Snippets.DeleteExpired(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("want %d deleted; got %d", 1, n)
+	}
+
+	if _, err = s.Snippets.Get(live); err != nil {
+		t.Errorf("want the live snippet to survive; got %v", err)
+	}
+}
+
+func testList(t *testing.T, s *Store) {
+	userID := addUser(t, s, "Alice", "alice@example.com")
+
+	// Inserted oldest first, so they come back in the opposite order
+	for _, title := range []string{"One", "Two", "Three", "Four", "Five"} {
+		addSnippet(t, s, userID, title, "Content", models.VisibilityPublic)
+	}
+
+	first, err := s.Snippets.List(nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTitles(t, first, "Five", "Four")
+
+	edge := first[1]
+	second, err := s.Snippets.List(&models.Cursor{Created: edge.Created, ID: edge.ID}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTitles(t, second, "Three", "Two")
+
+	edge = second[1]
+	last, err := s.Snippets.List(&models.Cursor{Created: edge.Created, ID: edge.ID}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTitles(t, last, "One")
+
+	// And back again from the last page
+	edge = last[0]
+	back, err := s.Snippets.List(&models.Cursor{Created: edge.Created, ID: edge.ID, Before: true}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTitles(t, back, "Three", "Two")
+}
+
+func testSearch(t *testing.T, s *Store) {
+	userID := addUser(t, s, "Alice", "alice@example.com")
+	addSnippet(t, s, userID, "An old silent pond", "A frog jumps into the pond", models.VisibilityPublic)
+	addSnippet(t, s, userID, "Over the wintry forest", "Winds howl in rage", models.VisibilityPublic)
+
+	found, err := s.Snippets.Search("frog", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTitles(t, found, "An old silent pond")
+
+	found, err = s.Snippets.Search("forest", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTitles(t, found, "Over the wintry forest")
+
+	found, err = s.Snippets.Search("frog", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTitles(t, found)
+
+	found, err = s.Snippets.Search("giraffe", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTitles(t, found)
+}
+
+func testAll(t *testing.T, s *Store) {
+	userID := addUser(t, s, "Alice", "alice@example.com")
+	addSnippet(t, s, userID, "Public", "Content", models.VisibilityPublic)
+	addSnippet(t, s, userID, "Private", "Content", models.VisibilityPrivate)
+	expired, err := s.Snippets.Insert(userID, "Expired", "Content", "", models.VisibilityUnlisted, "-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Everything turns up, newest first
+	all, err := s.Snippets.All(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTitles(t, all, "Expired", "Private", "Public")
+	if all[0].AuthorName != "Alice" {
+		t.Errorf("want the author's name; got %q", all[0].AuthorName)
+	}
+
+	all, err = s.Snippets.All(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTitles(t, all)
+
+	// Expired snippets can still be deleted before the reaper gets to them
+	if err = s.Snippets.Delete(expired); err != nil {
+		t.Fatal(err)
+	}
+	all, err = s.Snippets.All(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTitles(t, all, "Private", "Public")
+}
+
+func testTokens(t *testing.T, s *Store) {
+	alice := addUser(t, s, "Alice", "alice@example.com")
+	bob := addUser(t, s, "Bob", "bob@example.com")
+
+	token, err := s.Tokens.Insert(alice, "Deploy script")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := s.Tokens.Authenticate(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != alice {
+		t.Errorf("want user %d; got %d", alice, id)
+	}
+	if _, err = s.Tokens.Authenticate("wrong-token"); !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("want %v; got %v", models.ErrInvalidCredentials, err)
+	}
+
+	list, err := s.Tokens.List(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Name != "Deploy script" || list[0].LastUsed.IsZero() {
+		t.Fatalf("want one used token called Deploy script; got %+v", list)
+	}
+	if others, _ := s.Tokens.List(bob); len(others) != 0 {
+		t.Errorf("want no tokens for Bob; got %d", len(others))
+	}
+
+	if err = s.Tokens.Delete(bob, list[0].ID); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("want %v; got %v", models.ErrNoRecord, err)
+	}
+	if err = s.Tokens.Delete(alice, list[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Tokens.Authenticate(token); !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("want %v; got %v", models.ErrInvalidCredentials, err)
+	}
+}
+
+func testAudit(t *testing.T, s *Store) {
+	events, err := s.Audit.List(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("want no events; got %d", len(events))
+	}
+
+	err = s.Audit.Insert(0, "user.login_failed", "email:alice@example.com", "192.0.2.1", "curl/8.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Audit.Insert(7, "user.login", "user:7", "192.0.2.1", "Firefox"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Newest first
+	events, err = s.Audit.List(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("want 2 events; got %d", len(events))
+	}
+	e := events[1]
+	if e.UserID != 0 || e.Action != "user.login_failed" || e.Target != "email:alice@example.com" ||
+		e.IP != "192.0.2.1" || e.UserAgent != "curl/8.0" {
+		t.Errorf("want the failed login; got %+v", e)
+	}
+	if time.Since(e.Created) > time.Minute {
+		t.Errorf("want created just now; got %s", e.Created)
+	}
+	if events[0].Action != "user.login" || events[0].UserID != 7 {
+		t.Errorf("want the login; got %+v", events[0])
+	}
+
+	events, err = s.Audit.List(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Errorf("want no events on page 2; got %d", len(events))
+	}
+}
+
+func testAuditBefore(t *testing.T, s *Store) {
+	for i := 0; i < models.AuditEventsPerPage+5; i++ {
+		if err := s.Audit.Insert(0, "user.login_failed", fmt.Sprintf("email:%d@example.com", i), "192.0.2.1", "curl/8.0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first, err := s.Audit.Before(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != models.AuditEventsPerPage {
+		t.Fatalf("want a full page; got %d", len(first))
+	}
+	last := first[len(first)-1].ID
+
+	// Events added in the meantime don't push the rest along
+	if err = s.Audit.Insert(0, "user.login", "user:1", "192.0.2.1", "Firefox"); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := s.Audit.Before(last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 5 {
+		t.Fatalf("want the other 5; got %d", len(rest))
+	}
+	seen := map[int]bool{}
+	for _, e := range append(first, rest...) {
+		if seen[e.ID] {
+			t.Errorf("want each event once; got %d again", e.ID)
+		}
+		seen[e.ID] = true
+	}
+	for i := 1; i < len(rest); i++ {
+		if rest[i].ID >= rest[i-1].ID {
+			t.Errorf("want highest ID first; got %d then %d", rest[i-1].ID, rest[i].ID)
+		}
+	}
+	if rest[0].ID >= last {
+		t.Errorf("want IDs below %d; got %d", last, rest[0].ID)
+	}
+}
+
+func testPasswordResets(t *testing.T, s *Store) {
+	alice := addUser(t, s, "Alice", "alice@example.com")
+	bob := addUser(t, s, "Bob", "bob@example.com")
+
+	first, err := s.Resets.Insert(alice, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.Resets.Insert(alice, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobs, err := s.Resets.Insert(bob, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := s.Resets.Insert(bob, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Checking doesn't use the token up
+	for i := 0; i < 2; i++ {
+		id, err := s.Resets.Check(first)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != alice {
+			t.Errorf("want user %d; got %d", alice, id)
+		}
+	}
+	for _, token := range []string{"wrong-token", expired} {
+		if _, err = s.Resets.Check(token); !errors.Is(err, models.ErrInvalidCredentials) {
+			t.Errorf("want %v; got %v", models.ErrInvalidCredentials, err)
+		}
+	}
+
+	// Consuming does, along with the user's other tokens but nobody else's
+	id, err := s.Resets.Consume(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != alice {
+		t.Errorf("want user %d; got %d", alice, id)
+	}
+	for _, token := range []string{first, second} {
+		if _, err = s.Resets.Consume(token); !errors.Is(err, models.ErrInvalidCredentials) {
+			t.Errorf("want %v; got %v", models.ErrInvalidCredentials, err)
+		}
+	}
+	if _, err = s.Resets.Consume(expired); !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("want %v for an expired token; got %v", models.ErrInvalidCredentials, err)
+	}
+
+	// Deactivated users can't reset their password
+	if err = s.Users.SetActive(bob, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Resets.Check(bobs); !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("want %v; got %v", models.ErrInvalidCredentials, err)
+	}
+	if err = s.Users.SetActive(bob, true); err != nil {
+		t.Fatal(err)
+	}
+	if id, err = s.Resets.Consume(bobs); err != nil || id != bob {
+		t.Errorf("want user %d; got %d and %v", bob, id, err)
+	}
+}
+
+func testTwoFactor(t *testing.T, s *Store) {
+	alice := addUser(t, s, "Alice", "alice@example.com")
+	bob := addUser(t, s, "Bob", "bob@example.com")
+
+	u, err := s.Users.Get(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.TOTPSecret != nil {
+		t.Errorf("want no secret to start with; got %x", u.TOTPSecret)
+	}
+
+	secret := []byte{0, 1, 2, 0xfe, 0xff}
+	if err = s.Users.SetTOTPSecret(alice, secret); err != nil {
+		t.Fatal(err)
+	}
+	for _, get := range []func() (*models.User, error){
+		func() (*models.User, error) { return s.Users.Get(alice) },
+		func() (*models.User, error) { return s.Users.GetByEmail("alice@example.com") },
+	} {
+		u, err = get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(u.TOTPSecret) != string(secret) {
+			t.Errorf("want secret %x; got %x", secret, u.TOTPSecret)
+		}
+	}
+
+	// Each step can be used once, and never once a later one has been
+	if err = s.Users.UseTOTPStep(alice, 100); err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []int64{100, 99} {
+		if err = s.Users.UseTOTPStep(alice, step); !errors.Is(err, models.ErrInvalidCredentials) {
+			t.Errorf("step %d: want %v; got %v", step, models.ErrInvalidCredentials, err)
+		}
+	}
+	if err = s.Users.UseTOTPStep(alice, 101); err != nil {
+		t.Errorf("want a later step allowed; got %v", err)
+	}
+	// Everybody counts their own steps
+	if err = s.Users.UseTOTPStep(bob, 100); err != nil {
+		t.Errorf("want Bob's step allowed; got %v", err)
+	}
+
+	// Turning it off forgets the secret and the steps
+	if err = s.Users.SetTOTPSecret(alice, nil); err != nil {
+		t.Fatal(err)
+	}
+	u, err = s.Users.Get(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.TOTPSecret != nil {
+		t.Errorf("want the secret gone; got %x", u.TOTPSecret)
+	}
+	if err = s.Users.UseTOTPStep(alice, 100); err != nil {
+		t.Errorf("want the steps forgotten; got %v", err)
+	}
+}
+
+func testRecoveryCodes(t *testing.T, s *Store) {
+	alice := addUser(t, s, "Alice", "alice@example.com")
+	bob := addUser(t, s, "Bob", "bob@example.com")
+
+	old, err := s.RecoveryCodes.Replace(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := s.RecoveryCodes.Replace(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != models.RecoveryCodeCount {
+		t.Fatalf("want %d codes; got %d", models.RecoveryCodeCount, len(codes))
+	}
+	if n, err := s.RecoveryCodes.Count(alice); err != nil || n != models.RecoveryCodeCount {
+		t.Errorf("want %d codes left; got %d and %v", models.RecoveryCodeCount, n, err)
+	}
+
+	// The new codes replace the old ones, and only work for their owner
+	if err = s.RecoveryCodes.Consume(alice, old[0]); !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("want %v for an old code; got %v", models.ErrInvalidCredentials, err)
+	}
+	if err = s.RecoveryCodes.Consume(bob, codes[0]); !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("want %v for somebody else's code; got %v", models.ErrInvalidCredentials, err)
+	}
+
+	// Each code works once, however it's typed
+	if err = s.RecoveryCodes.Consume(alice, " "+strings.ToUpper(codes[0])); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.RecoveryCodes.Consume(alice, codes[0]); !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("want %v for a used code; got %v", models.ErrInvalidCredentials, err)
+	}
+	if err = s.RecoveryCodes.Consume(alice, strings.Replace(codes[1], "-", "", 1)); err != nil {
+		t.Errorf("want a code without the dash to work; got %v", err)
+	}
+	if n, err := s.RecoveryCodes.Count(alice); err != nil || n != models.RecoveryCodeCount-2 {
+		t.Errorf("want %d codes left; got %d and %v", models.RecoveryCodeCount-2, n, err)
+	}
+
+	if err = s.RecoveryCodes.Delete(alice); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.RecoveryCodes.Count(alice); err != nil || n != 0 {
+		t.Errorf("want no codes left; got %d and %v", n, err)
+	}
+	if err = s.RecoveryCodes.Consume(alice, codes[2]); !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("want %v after deleting; got %v", models.ErrInvalidCredentials, err)
+	}
+}
+
+func testIdentities(t *testing.T, s *Store) {
+	alice := addUser(t, s, "Alice", "alice@example.com")
+	bob := addUser(t, s, "Bob", "bob@example.com")
+	const issuer = "https://accounts.example.com"
+
+	if _, err := s.Identities.Get(issuer, "alice"); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("want %v before linking; got %v", models.ErrNoRecord, err)
+	}
+	if err := s.Identities.Insert(alice, issuer, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := s.Identities.Get(issuer, "alice"); err != nil || id != alice {
+		t.Errorf("want user %d; got %d and %v", alice, id, err)
+	}
+
+	// Subjects are only unique within their issuer
+	if _, err := s.Identities.Get("https://other.example.com", "alice"); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("want %v for another issuer; got %v", models.ErrNoRecord, err)
+	}
+	if err := s.Identities.Insert(bob, "https://other.example.com", "alice"); err != nil {
+		t.Errorf("want the same subject at another issuer linked; got %v", err)
+	}
+
+	// But within one they only ever belong to one user
+	if err := s.Identities.Insert(bob, issuer, "alice"); err == nil {
+		t.Error("want an error linking a subject twice")
+	}
+	if id, err := s.Identities.Get(issuer, "alice"); err != nil || id != alice {
+		t.Errorf("want user %d still; got %d and %v", alice, id, err)
+	}
+}
+
+func testByAuthor(t *testing.T, s *Store) {
+	alice := addUser(t, s, "Alice", "alice@example.com")
+	bob := addUser(t, s, "Bob", "bob@example.com")
+	addSnippet(t, s, alice, "Public", "Pond", models.VisibilityPublic)
+	addSnippet(t, s, bob, "Bob's", "Pond", models.VisibilityPublic)
+	addSnippet(t, s, alice, "Unlisted", "Pond", models.VisibilityUnlisted)
+	addSnippet(t, s, alice, "Private", "Pond", models.VisibilityPrivate)
+	if _, err := s.Snippets.Insert(alice, "Expired", "Pond", "", models.VisibilityPublic, "-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Everything of theirs that's live, whoever it's visible to
+	snippets, err := s.Snippets.ByAuthor(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTitles(t, snippets, "Private", "Unlisted", "Public")
+	if snippets[0].AuthorName != "Alice" {
+		t.Errorf("want the author's name; got %q", snippets[0].AuthorName)
+	}
+
+	snippets, err = s.Snippets.ByAuthor(alice + bob + 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTitles(t, snippets)
+}
+
+func testSessions(t *testing.T, s *Store) {
+	alice := addUser(t, s, "Alice", "alice@example.com")
+	bob := addUser(t, s, "Bob", "bob@example.com")
+
+	laptop, err := s.Sessions.Insert(alice, "192.0.2.1", "Firefox on Linux", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	phone, err := s.Sessions.Insert(alice, "192.0.2.2", "Safari on iPhone", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobs, err := s.Sessions.Insert(bob, "198.51.100.1", "Chrome on Windows", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := s.Sessions.Insert(bob, "198.51.100.1", "Chrome on Windows", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := s.Sessions.Authenticate(laptop, "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.UserID != alice || session.IP != "192.0.2.1" || session.UserAgent != "Firefox on Linux" {
+		t.Errorf("want Alice's laptop; got %+v", session)
+	}
+	if session.Expires.Before(session.Created.Add(59 * time.Minute)) {
+		t.Errorf("want it to last an hour; got %v to %v", session.Created, session.Expires)
+	}
+	for _, token := range []string{"wrong-token", expired} {
+		if _, err = s.Sessions.Authenticate(token, "192.0.2.1"); !errors.Is(err, models.ErrInvalidCredentials) {
+			t.Errorf("want %v; got %v", models.ErrInvalidCredentials, err)
+		}
+	}
+
+	// Users only see their own live sessions
+	sessions, err := s.Sessions.List(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("want 2 sessions; got %d", len(sessions))
+	}
+	agents := []string{sessions[0].UserAgent, sessions[1].UserAgent}
+	if !(agents[0] == "Safari on iPhone" && agents[1] == "Firefox on Linux") &&
+		!(agents[0] == "Firefox on Linux" && agents[1] == "Safari on iPhone") {
+		t.Errorf("want both of Alice's sessions; got %q", agents)
+	}
+	if sessions, err = s.Sessions.List(bob); err != nil || len(sessions) != 1 {
+		t.Fatalf("want Bob's one live session; got %d and %v", len(sessions), err)
+	}
+	bobsID := sessions[0].ID
+
+	// Nor can they end anybody else's
+	if err = s.Sessions.Delete(alice, bobsID); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("want %v; got %v", models.ErrNoRecord, err)
+	}
+	if _, err = s.Sessions.Authenticate(bobs, "198.51.100.1"); err != nil {
+		t.Errorf("want Bob still logged in; got %v", err)
+	}
+
+	// Ending one session leaves the others
+	if err = s.Sessions.Delete(alice, session.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Sessions.Authenticate(laptop, "192.0.2.1"); !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("want %v once ended; got %v", models.ErrInvalidCredentials, err)
+	}
+	if err = s.Sessions.Delete(alice, session.ID); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("want %v ending it again; got %v", models.ErrNoRecord, err)
+	}
+	if _, err = s.Sessions.Authenticate(phone, "192.0.2.2"); err != nil {
+		t.Errorf("want the phone still logged in; got %v", err)
+	}
+
+	// Deactivated users' sessions stop working
+	if err = s.Users.SetActive(bob, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Sessions.Authenticate(bobs, "198.51.100.1"); !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("want %v; got %v", models.ErrInvalidCredentials, err)
+	}
+	if err = s.Users.SetActive(bob, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ending them all only ends the user's own
+	if err = s.Sessions.DeleteAll(alice); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Sessions.Authenticate(phone, "192.0.2.2"); !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("want %v; got %v", models.ErrInvalidCredentials, err)
+	}
+	if sessions, err = s.Sessions.List(alice); err != nil || len(sessions) != 0 {
+		t.Errorf("want no sessions; got %d and %v", len(sessions), err)
+	}
+	if _, err = s.Sessions.Authenticate(bobs, "198.51.100.1"); err != nil {
+		t.Errorf("want Bob still logged in; got %v", err)
+	}
+}
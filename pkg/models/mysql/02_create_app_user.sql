@@ -1,7 +1,9 @@
 USE snippetbox;
 
 CREATE USER 'web'@'localhost';
-GRANT SELECT, INSERT, UPDATE ON snippetbox.* TO 'web'@'localhost';
+/* The schema changes are applied by the app with `web migrate up`, so it
+   needs to be able to change tables as well as the rows in them. */
+GRANT SELECT, INSERT, UPDATE, DELETE, CREATE, ALTER, INDEX, DROP, REFERENCES ON snippetbox.* TO 'web'@'localhost';
 ALTER USER 'web'@'localhost' IDENTIFIED BY 'insecure';
 update mysql.user set host = '%' where user='web';
 commit;
\ No newline at end of file
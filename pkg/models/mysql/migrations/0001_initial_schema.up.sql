@@ -0,0 +1,77 @@
+/* Everything up to the point that migrations were introduced. Databases
+   that were set up by hand started out with schema.sql and may have had any
+   of the numbered upgrade scripts run on them since, so after creating the
+   tables that aren't there, this carries on from schema.sql with the same
+   changes those scripts made. Every step checks whether it's already been
+   done, so it works whichever of them were run. */
+
+/* Users in the system */
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    hashed_password CHAR(60) NOT NULL,
+    created DATETIME NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    CONSTRAINT users_uc_email UNIQUE (email)
+);
+
+/* Snippets are owned by the user that created them. user_id is nullable
+   because snippets created before authors were recorded don't have one.
+   Snippets are linked to by their random slug rather than the id, so that
+   nobody can walk through them all by counting. */
+CREATE TABLE IF NOT EXISTS snippets (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER NULL,
+    title VARCHAR(100) NOT NULL,
+    content TEXT NOT NULL,
+    language VARCHAR(50) NOT NULL DEFAULT '',
+    visibility ENUM('public', 'unlisted', 'private') NOT NULL DEFAULT 'public',
+    slug VARCHAR(12) NOT NULL,
+    created DATETIME NOT NULL,
+    expires DATETIME NOT NULL,
+    CONSTRAINT snippets_uc_slug UNIQUE (slug),
+    CONSTRAINT fk_snippets_user FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+/* The authoring user. Snippets from before this keep a NULL user_id since
+   there's no way of knowing who created them. */
+ALTER TABLE snippets ADD COLUMN IF NOT EXISTS user_id INTEGER NULL AFTER id;
+ALTER TABLE snippets ADD CONSTRAINT fk_snippets_user FOREIGN KEY IF NOT EXISTS (user_id) REFERENCES users(id);
+
+/* The syntax highlighter for each snippet. Empty means plain text, which is
+   what all the older snippets are. */
+ALTER TABLE snippets ADD COLUMN IF NOT EXISTS language VARCHAR(50) NOT NULL DEFAULT '' AFTER content;
+
+/* Everything from before visibility was a thing was public. Snippets that
+   don't have a slug yet get one, in hex rather than the full alphabet that
+   the application uses, which is still plenty. */
+ALTER TABLE snippets ADD COLUMN IF NOT EXISTS visibility ENUM('public', 'unlisted', 'private') NOT NULL DEFAULT 'public' AFTER language;
+ALTER TABLE snippets ADD COLUMN IF NOT EXISTS slug VARCHAR(12) NULL AFTER visibility;
+UPDATE snippets SET slug = LEFT(SHA2(CONCAT(UUID(), RAND()), 256), 12) WHERE slug IS NULL;
+ALTER TABLE snippets MODIFY slug VARCHAR(12) NOT NULL;
+ALTER TABLE snippets ADD UNIQUE INDEX IF NOT EXISTS snippets_uc_slug (slug);
+
+/* Covers both the latest snippets and paging through the archive. It's
+   dropped first because schema.sql only indexed created. */
+DROP INDEX IF EXISTS idx_snippets_created ON snippets;
+CREATE INDEX idx_snippets_created ON snippets(created, id);
+
+/* Lets the reaper find expired snippets without scanning the table */
+CREATE INDEX IF NOT EXISTS idx_snippets_expires ON snippets(expires);
+
+/* Used for searching snippets */
+CREATE FULLTEXT INDEX IF NOT EXISTS idx_snippets_fulltext ON snippets(title, content);
+
+/* Named tokens that let scripts use the API on behalf of a user. Only the
+   SHA-256 hash of each token is stored. */
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    created DATETIME NOT NULL,
+    last_used DATETIME NULL,
+    CONSTRAINT api_tokens_uc_token_hash UNIQUE (token_hash),
+    CONSTRAINT fk_api_tokens_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
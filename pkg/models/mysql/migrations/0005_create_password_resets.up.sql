@@ -0,0 +1,11 @@
+/* Links for resetting a forgotten password. Like API tokens only the hash is
+   kept, and each one can only be used once before it expires. */
+CREATE TABLE password_resets (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    created DATETIME NOT NULL,
+    expires DATETIME NOT NULL,
+    CONSTRAINT password_resets_uc_token_hash UNIQUE (token_hash),
+    CONSTRAINT fk_password_resets_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
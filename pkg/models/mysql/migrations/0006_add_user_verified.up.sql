@@ -0,0 +1,4 @@
+/* New users have to follow the link emailed to them before they can log in.
+   Everybody who signed up before that was a thing is trusted as they are. */
+ALTER TABLE users ADD COLUMN verified BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE users SET verified = TRUE;
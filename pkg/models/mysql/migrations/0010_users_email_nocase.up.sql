@@ -0,0 +1,5 @@
+/* Nothing to do. PostgreSQL and SQLite need this version to compare email
+   addresses without regard to case, but MySQL already does: the database is
+   created with the case-insensitive utf8mb4_unicode_ci collation, so
+   users_uc_email and WHERE email = ? ignore case. It's here so the version
+   numbers stay the same for every driver. */
@@ -0,0 +1,54 @@
+package mysql
+
+import (
+	"database/sql"
+	"dvhthomas/snippetbox/pkg/models/migrate"
+	"dvhthomas/snippetbox/pkg/models/modeltest"
+	"os"
+	"testing"
+)
+
+// These need a real database, so they only run when SNIPPETBOX_TEST_DSN is
+// set. Everything in that database is deleted, so don't point it at one you
+// care about. For example:
+//
+//	SNIPPETBOX_TEST_DSN='web:pass@tcp(0.0.0.0)/snippetbox_test?parseTime=true' go test ./pkg/models/mysql
+func TestConformance(t *testing.T) {
+	dsn := os.Getenv("SNIPPETBOX_TEST_DSN")
+	if dsn == "" {
+		t.Skip("SNIPPETBOX_TEST_DSN is not set")
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	m, err := migrate.New(db, "mysql", Migrations())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	modeltest.Run(t, func(t *testing.T) *modeltest.Store {
+		// Children first because of the foreign keys
+		for _, table := range []string{"api_tokens", "audit_events", "login_failures", "password_resets", "recovery_codes", "sessions", "snippets", "user_identities", "users"} {
+			if _, err := db.Exec("DELETE FROM " + table); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return &modeltest.Store{
+			Snippets:      &SnippetModel{DB: db},
+			Users:         &UserModel{DB: db},
+			Tokens:        &TokenModel{DB: db},
+			Audit:         &AuditModel{DB: db},
+			Resets:        &PasswordResetModel{DB: db},
+			RecoveryCodes: &RecoveryCodeModel{DB: db},
+			Identities:    &IdentityModel{DB: db},
+			Sessions:      &SessionModel{DB: db},
+		}
+	})
+}
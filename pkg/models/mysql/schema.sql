@@ -1,24 +0,0 @@
-USE snippetbox;
-
-CREATE TABLE IF NOT EXISTS snippets (
-    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
-    title VARCHAR(100) NOT NULL,
-    content TEXT NOT NULL,
-    created DATETIME NOT NULL,
-    expires DATETIME NOT NULL
-);
-
-CREATE INDEX IF NOT EXISTS idx_snippets_created ON snippets(created);
-
-
-/* Users in the system */
-CREATE TABLE IF NOT EXISTS users (
-    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
-    name VARCHAR(255) NOT NULL,
-    email VARCHAR(255) NOT NULL,
-    hashed_password CHAR(60) NOT NULL,
-    created DATETIME NOT NULL,
-    active BOOLEAN NOT NULL DEFAULT TRUE
-);
-
-ALTER TABLE users ADD CONSTRAINT users_uc_email UNIQUE (email);
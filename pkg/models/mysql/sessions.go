@@ -0,0 +1,124 @@
+package mysql
+
+import (
+	"database/sql"
+	"dvhthomas/snippetbox/pkg/models"
+	"errors"
+	"time"
+)
+
+// SessionModel works with the browsers that users have logged in on
+type SessionModel struct {
+	DB *sql.DB
+}
+
+// Insert starts a session for the user that lasts for ttl and returns its
+// token. This is the only time the token is available since we only store
+// its hash.
+func (m *SessionModel) Insert(userID int, ip, userAgent string, ttl time.Duration) (string, error) {
+	token, hash, err := models.NewToken()
+	if err != nil {
+		return "", err
+	}
+
+	// Tidy up the ones that have run out while we're here
+	_, err = m.DB.Exec(`DELETE FROM sessions WHERE expires <= UTC_TIMESTAMP()`)
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `INSERT INTO sessions (user_id, token_hash, ip, user_agent, created, last_seen, expires)
+	VALUES(?, ?, ?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+
+	_, err = m.DB.Exec(stmt, userID, hash, ip, userAgent, int(ttl.Seconds()))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// The columns that fill in a models.Session, in the order scanSession expects
+const sessionColumns = `s.id, s.user_id, s.ip, s.user_agent, s.created, s.last_seen, s.expires`
+
+// Scan the columns in sessionColumns into a new session
+func scanSession(row rowScanner) (*models.Session, error) {
+	s := &models.Session{}
+	err := row.Scan(&s.ID, &s.UserID, &s.IP, &s.UserAgent, &s.Created, &s.LastSeen, &s.Expires)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Authenticate returns the session that the token picks out, and records
+// that it was seen from ip. Unknown and expired tokens, and tokens belonging
+// to users that have been deactivated, are invalid credentials.
+func (m *SessionModel) Authenticate(token, ip string) (*models.Session, error) {
+	stmt := `SELECT ` + sessionColumns + ` FROM sessions s
+	INNER JOIN users u ON u.id = s.user_id
+	WHERE s.token_hash = ? AND s.expires > UTC_TIMESTAMP() AND u.active = TRUE`
+	s, err := scanSession(m.DB.QueryRow(stmt, models.HashToken(token)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	if time.Since(s.LastSeen) >= models.SessionSeenInterval {
+		_, err = m.DB.Exec(`UPDATE sessions SET last_seen = UTC_TIMESTAMP(), ip = ? WHERE id = ?`, ip, s.ID)
+		if err != nil {
+			return nil, err
+		}
+		s.LastSeen, s.IP = time.Now().UTC(), ip
+	}
+	return s, nil
+}
+
+// List returns the user's live sessions, most recently seen first
+func (m *SessionModel) List(userID int) ([]*models.Session, error) {
+	stmt := `SELECT ` + sessionColumns + ` FROM sessions s
+	WHERE s.user_id = ? AND s.expires > UTC_TIMESTAMP() ORDER BY s.last_seen DESC, s.id DESC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*models.Session{}
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// Delete ends one of the user's sessions. Sessions belonging to somebody
+// else are treated as if they don't exist.
+func (m *SessionModel) Delete(userID, id int) error {
+	stmt := `DELETE FROM sessions WHERE id = ? AND user_id = ?`
+
+	result, err := m.DB.Exec(stmt, id, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+	return nil
+}
+
+// DeleteAll ends every one of the user's sessions
+func (m *SessionModel) DeleteAll(userID int) error {
+	_, err := m.DB.Exec(`DELETE FROM sessions WHERE user_id = ?`, userID)
+	return err
+}
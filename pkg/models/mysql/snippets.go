@@ -11,12 +11,77 @@ type SnippetModel struct {
 	DB *sql.DB
 }
 
-// Insert will insert a new snippet in the database
-func (m *SnippetModel) Insert(title, content, expires string) (int, error) {
-	stmt := `INSERT INTO snippets (title, content, created, expires)
-		VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
+// Every query that returns snippets selects the same columns, joined to the
+// author. Snippets created before authors were recorded have a NULL user_id,
+// hence the LEFT JOIN and the IFNULLs.
+const selectSnippets = `SELECT s.id, s.title, s.content, s.language,
+	s.visibility, s.slug, s.created, s.expires,
+	IFNULL(s.user_id, 0), IFNULL(u.name, '')
+	FROM snippets s LEFT JOIN users u ON u.id = s.user_id`
 
-	result, err := m.DB.Exec(stmt, title, content, expires)
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Scan the columns from selectSnippets into a new snippet
+func scanSnippet(row rowScanner) (*models.Snippet, error) {
+	s := &models.Snippet{}
+	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Language,
+		&s.Visibility, &s.Slug, &s.Created, &s.Expires, &s.AuthorID, &s.AuthorName)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Run a query built on selectSnippets and collect all of the results
+func (m *SnippetModel) querySnippets(stmt string, args ...interface{}) ([]*models.Snippet, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	// We want to make sure the that rows is closed eventually. But make sure that
+	// this comes _after_ the error check on Query. If we don't do it after but query
+	// did return an error, we'll get a panic when trying to close a nil resultset.
+	defer rows.Close()
+
+	snippets := []*models.Snippet{}
+
+	for rows.Next() {
+		s, err := scanSnippet(rows)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+
+	// Just because the loop finished doesn't mean we made it through the whole
+	// list of results. For example, we could have lost the DB connection half way
+	// through.
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// If everything went OK then return the slice of Snippets
+	return snippets, nil
+}
+
+// Insert will insert a new snippet in the database on behalf of the
+// user identified by userID. Every snippet gets a random slug to link to it
+// by. There are 62^12 of them, so we don't bother retrying on the off chance
+// that the unique index rejects one.
+func (m *SnippetModel) Insert(userID int, title, content, language, visibility, expires string) (int, error) {
+	slug, err := models.NewSlug()
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `INSERT INTO snippets (user_id, title, content, language, visibility, slug, created, expires)
+		VALUES(?, ?, ?, ?, ?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
+
+	result, err := m.DB.Exec(stmt, userID, title, content, language, visibility, slug, expires)
 	if err != nil {
 		return 0, err
 	}
@@ -35,13 +100,9 @@ func (m *SnippetModel) Insert(title, content, expires string) (int, error) {
 
 // Get returns a single snippet based on it's ID
 func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
-	stmt := `SELECT id, title, content, created, expires from snippets 
-	WHERE expires > UTC_TIMESTAMP() AND id = ?`
-
-	row := m.DB.QueryRow(stmt, id)
-	s := &models.Snippet{}
-	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	stmt := selectSnippets + ` WHERE s.expires > UTC_TIMESTAMP() AND s.id = ?`
 
+	s, err := scanSnippet(m.DB.QueryRow(stmt, id))
 	if err != nil {
 		// If the query returns no rows then row.Scan() will return
 		// a sql.ErrNoRows error. We use the errors.Is() function to check for that.
@@ -57,38 +118,147 @@ func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
 	return s, nil
 }
 
-// Latest returns the 10 most recently created snippets
-func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
-	stmt := `SELECT id, title, content, created, expires from snippets
-	WHERE expires > UTC_TIMESTAMP() ORDER BY created DESC LIMIT 10`
-	rows, err := m.DB.Query(stmt)
+// Update replaces the title, content, language and visibility of an existing
+// snippet. Checking that the caller is allowed to change it is up to the
+// handler.
+func (m *SnippetModel) Update(id int, title, content, language, visibility string) error {
+	// The slug never changes so that links that have been shared carry on
+	// working.
+	stmt := `UPDATE snippets SET title = ?, content = ?, language = ?,
+	visibility = ? WHERE id = ?`
+
+	// Note that MySQL reports zero affected rows when the values haven't
+	// changed, so we can't use RowsAffected to detect a missing snippet here.
+	_, err := m.DB.Exec(stmt, title, content, language, visibility, id)
+	return err
+}
+
+// Delete removes a snippet permanently
+func (m *SnippetModel) Delete(id int) error {
+	stmt := `DELETE FROM snippets WHERE id = ?`
+
+	result, err := m.DB.Exec(stmt, id)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// We want to make sure the that rows is closed eventually. But make sure that
-	// this comes _after_ the error check on Query. If we don't do it after but query
-	// did return an error, we'll get a panic when trying to close a nil resultset.
-	defer rows.Close()
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+	return nil
+}
 
-	snippets := []*models.Snippet{}
+// DeleteExpired permanently removes up to limit snippets that have expired
+// and reports how many went. Deleting in batches keeps each statement short
+// so that it doesn't hold locks that the web requests are waiting on.
+func (m *SnippetModel) DeleteExpired(limit int) (int, error) {
+	stmt := `DELETE FROM snippets WHERE expires <= UTC_TIMESTAMP() LIMIT ?`
 
-	for rows.Next() {
-		s := &models.Snippet{}
-		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
-		if err != nil {
-			return nil, err
+	result, err := m.DB.Exec(stmt, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// GetBySlug returns a single snippet based on its slug
+func (m *SnippetModel) GetBySlug(slug string) (*models.Snippet, error) {
+	stmt := selectSnippets + ` WHERE s.expires > UTC_TIMESTAMP() AND s.slug = ?`
+
+	s, err := scanSnippet(m.DB.QueryRow(stmt, slug))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNoRecord
 		}
-		snippets = append(snippets, s)
+		return nil, err
 	}
+	return s, nil
+}
 
-	// Just because the loop finished doesn't mean we made it through the whole
-	// list of results. For example, we could have lost the DB connection half way
-	// through.
-	if err = rows.Err(); err != nil {
+// Latest returns the 10 most recently created public snippets
+func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
+	stmt := selectSnippets + ` WHERE s.expires > UTC_TIMESTAMP() AND s.visibility = 'public'
+	ORDER BY s.created DESC LIMIT 10`
+	return m.querySnippets(stmt)
+}
+
+// List returns up to limit live public snippets from the position marked by cursor,
+// newest first. A nil cursor starts at the newest snippet. This is keyset
+// pagination: rather than an OFFSET that gets slower the deeper you go, we
+// use the (created, id) of the snippet at the edge of the last page.
+func (m *SnippetModel) List(cursor *models.Cursor, limit int) ([]*models.Snippet, error) {
+	stmt := selectSnippets + ` WHERE s.expires > UTC_TIMESTAMP() AND s.visibility = 'public'`
+	args := []interface{}{}
+
+	switch {
+	case cursor == nil:
+		stmt += ` ORDER BY s.created DESC, s.id DESC LIMIT ?`
+	case cursor.Before:
+		// Walk towards the newest snippets, which means reading in ascending
+		// order. We flip the results round again below.
+		stmt += ` AND (s.created > ? OR (s.created = ? AND s.id > ?))
+		ORDER BY s.created ASC, s.id ASC LIMIT ?`
+		args = append(args, cursor.Created, cursor.Created, cursor.ID)
+	default:
+		stmt += ` AND (s.created < ? OR (s.created = ? AND s.id < ?))
+		ORDER BY s.created DESC, s.id DESC LIMIT ?`
+		args = append(args, cursor.Created, cursor.Created, cursor.ID)
+	}
+	args = append(args, limit)
+
+	snippets, err := m.querySnippets(stmt, args...)
+	if err != nil {
 		return nil, err
 	}
 
-	// If everything went OK then return the slice of Snippets
+	if cursor != nil && cursor.Before {
+		for i, j := 0, len(snippets)-1; i < j; i, j = i+1, j-1 {
+			snippets[i], snippets[j] = snippets[j], snippets[i]
+		}
+	}
 	return snippets, nil
 }
+
+// ByAuthor returns all of a user's live snippets, newest first, whatever
+// their visibility. It's for showing users their own snippets, so never show
+// the results to anybody else.
+func (m *SnippetModel) ByAuthor(userID int) ([]*models.Snippet, error) {
+	stmt := selectSnippets + ` WHERE s.user_id = ? AND s.expires > UTC_TIMESTAMP()
+	ORDER BY s.created DESC, s.id DESC`
+	return m.querySnippets(stmt, userID)
+}
+
+// All returns a page of every snippet, newest first, whatever its visibility
+// and whether or not it has expired. It's for the admin console, so never
+// show the results to anybody else. Pages are numbered from 1 and hold
+// models.AdminSnippetsPerPage snippets.
+func (m *SnippetModel) All(page int) ([]*models.Snippet, error) {
+	stmt := selectSnippets + ` ORDER BY s.created DESC, s.id DESC LIMIT ? OFFSET ?`
+
+	offset := (page - 1) * models.AdminSnippetsPerPage
+	return m.querySnippets(stmt, models.AdminSnippetsPerPage, offset)
+}
+
+// Search returns a page of live public snippets whose title or content match the
+// query, best matches first. Pages are numbered from 1 and hold
+// models.SearchResultsPerPage snippets. This relies on the FULLTEXT index on
+// snippets(title, content), and MySQL ignores very short and very common
+// words in natural language mode.
+func (m *SnippetModel) Search(query string, page int) ([]*models.Snippet, error) {
+	stmt := selectSnippets + ` WHERE s.expires > UTC_TIMESTAMP() AND s.visibility = 'public'
+	AND MATCH(s.title, s.content) AGAINST(? IN NATURAL LANGUAGE MODE)
+	ORDER BY MATCH(s.title, s.content) AGAINST(? IN NATURAL LANGUAGE MODE) DESC, s.id DESC
+	LIMIT ? OFFSET ?`
+
+	offset := (page - 1) * models.SearchResultsPerPage
+	return m.querySnippets(stmt, query, query, models.SearchResultsPerPage, offset)
+}
@@ -1,19 +1,22 @@
-INSERT INTO snippets (title, content, created, expires) VALUES (
+INSERT INTO snippets (slug, title, content, created, expires) VALUES (
+    's1LentP0ndAa',
     'An old silent pond',
     'An old silent pond...\nA frog jumps into the pond,\nsplash! Silence again.\n\n– Matsuo Bashō',
     UTC_TIMESTAMP(),
     DATE_ADD(UTC_TIMESTAMP(), INTERVAL 365 DAY)
 );
 
-INSERT INTO snippets (title, content, created, expires) VALUES (
+INSERT INTO snippets (slug, title, content, created, expires) VALUES (
+    'w1ntryF0rest',
     'Over the wintry forest',
     'Over the wintry\nforest, winds howl in rage\nwith no leaves to blow.\n\n– Natsume Soseki',
     UTC_TIMESTAMP(),
     DATE_ADD(UTC_TIMESTAMP(), INTERVAL 365 DAY)
 );
 
-INSERT INTO snippets (title, content, created, expires)
+INSERT INTO snippets (slug, title, content, created, expires)
 VALUES (
+    'aUtumnM0rn1n',
     'First autumn morning',
     'First autumn morning\nthe mirror I stare into\nshows my father''s face.\n\n– Murakami Kijo',
     UTC_TIMESTAMP(),
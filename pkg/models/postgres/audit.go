@@ -0,0 +1,67 @@
+package postgres
+
+import (
+	"database/sql"
+	"dvhthomas/snippetbox/pkg/models"
+)
+
+// AuditModel works with the audit_events table, which is only ever added to
+type AuditModel struct {
+	DB *sql.DB
+}
+
+// Insert records an event. userID is zero if nobody was logged in.
+func (m *AuditModel) Insert(userID int, action, target, ip, userAgent string) error {
+	stmt := `INSERT INTO audit_events (user_id, action, target, ip, user_agent, created)
+	VALUES($1, $2, $3, $4, $5, NOW())`
+
+	_, err := m.DB.Exec(stmt, userID, action, target, ip, userAgent)
+	return err
+}
+
+// List returns a page of events, newest first. Pages are numbered from 1 and
+// hold models.AuditEventsPerPage events.
+func (m *AuditModel) List(page int) ([]*models.AuditEvent, error) {
+	stmt := `SELECT id, user_id, action, target, ip, user_agent, created FROM audit_events
+	ORDER BY created DESC, id DESC LIMIT $1 OFFSET $2`
+
+	offset := (page - 1) * models.AuditEventsPerPage
+	rows, err := m.DB.Query(stmt, models.AuditEventsPerPage, offset)
+	if err != nil {
+		return nil, err
+	}
+	return scanAuditEvents(rows)
+}
+
+// Before returns up to models.AuditEventsPerPage events with IDs below id,
+// highest first, or the newest events if id is zero. Unlike pages, these
+// don't shift when events are added, so this is what the export walks.
+func (m *AuditModel) Before(id int) ([]*models.AuditEvent, error) {
+	stmt := `SELECT id, user_id, action, target, ip, user_agent, created FROM audit_events
+	WHERE $1 = 0 OR id < $1 ORDER BY id DESC LIMIT $2`
+
+	rows, err := m.DB.Query(stmt, id, models.AuditEventsPerPage)
+	if err != nil {
+		return nil, err
+	}
+	return scanAuditEvents(rows)
+}
+
+// Read every event in rows, closing them when done
+func scanAuditEvents(rows *sql.Rows) ([]*models.AuditEvent, error) {
+	defer rows.Close()
+
+	events := []*models.AuditEvent{}
+	for rows.Next() {
+		e := &models.AuditEvent{}
+		err := rows.Scan(&e.ID, &e.UserID, &e.Action, &e.Target, &e.IP, &e.UserAgent, &e.Created)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
@@ -0,0 +1,4 @@
+/* Children first because of the foreign keys */
+DROP TABLE IF EXISTS api_tokens;
+DROP TABLE IF EXISTS snippets;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,14 @@
+/* An append-only record of security-relevant events. user_id is 0 when
+   nobody was logged in, and there's no foreign key because the record has to
+   outlive whatever it mentions. */
+CREATE TABLE audit_events (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    target VARCHAR(255) NOT NULL,
+    ip VARCHAR(45) NOT NULL,
+    user_agent VARCHAR(255) NOT NULL,
+    created TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_audit_events_created ON audit_events(created, id);
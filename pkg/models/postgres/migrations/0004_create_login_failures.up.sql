@@ -0,0 +1,9 @@
+/* Failed logins, counted by the email address that was tried whether or not
+   there's a user with it. Keeping them apart from the users table means that
+   an address that doesn't exist gets locked out just the same, so lockouts
+   don't give away who has an account. */
+CREATE TABLE login_failures (
+    email VARCHAR(255) NOT NULL PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,10 @@
+/* Links for resetting a forgotten password. Like API tokens only the hash is
+   kept, and each one can only be used once before it expires. */
+CREATE TABLE password_resets (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash CHAR(64) NOT NULL,
+    created TIMESTAMPTZ NOT NULL,
+    expires TIMESTAMPTZ NOT NULL,
+    CONSTRAINT password_resets_uc_token_hash UNIQUE (token_hash)
+);
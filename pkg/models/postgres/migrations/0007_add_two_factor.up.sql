@@ -0,0 +1,15 @@
+/* Two-factor authentication. The secret shared with the user's authenticator
+   app is encrypted by the application, and is NULL until they turn it on.
+   The last step that a code was accepted for stops a code being used twice. */
+ALTER TABLE users ADD COLUMN totp_secret BYTEA NULL;
+ALTER TABLE users ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+/* One-time codes for logging in without the authenticator app. Like API
+   tokens only the hash is kept, and they're deleted as they're used. */
+CREATE TABLE recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash CHAR(64) NOT NULL,
+    created TIMESTAMPTZ NOT NULL,
+    CONSTRAINT recovery_codes_uc_user_code UNIQUE (user_id, code_hash)
+);
@@ -0,0 +1,2 @@
+DROP INDEX users_uc_email;
+ALTER TABLE users ADD CONSTRAINT users_uc_email UNIQUE (email);
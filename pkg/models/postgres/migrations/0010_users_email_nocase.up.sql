@@ -0,0 +1,6 @@
+/* Email addresses are compared without regard to case, the same as MySQL
+   does, so that ALICE@example.com can't sign up alongside alice@example.com.
+   This fails if there already are two such users, who need sorting out by
+   hand first. */
+ALTER TABLE users DROP CONSTRAINT users_uc_email;
+CREATE UNIQUE INDEX users_uc_email ON users (lower(email));
@@ -0,0 +1,81 @@
+package postgres
+
+import (
+	"database/sql"
+	"dvhthomas/snippetbox/pkg/models"
+)
+
+// RecoveryCodeModel works with the recovery_codes table
+type RecoveryCodeModel struct {
+	DB *sql.DB
+}
+
+// Replace throws away the user's recovery codes and gives them a new set of
+// models.RecoveryCodeCount. This is the only time the codes are available
+// since we only store their hashes.
+func (m *RecoveryCodeModel) Replace(userID int) ([]string, error) {
+	codes := make([]string, models.RecoveryCodeCount)
+	hashes := make([]string, models.RecoveryCodeCount)
+	for i := range codes {
+		var err error
+		codes[i], hashes[i], err = models.NewRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// All or nothing, so that a failure can't leave the user with half a set
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := `INSERT INTO recovery_codes (user_id, code_hash, created) VALUES($1, $2, NOW())`
+	for _, hash := range hashes {
+		if _, err = tx.Exec(stmt, userID, hash); err != nil {
+			return nil, err
+		}
+	}
+	return codes, tx.Commit()
+}
+
+// Consume uses up one of the user's recovery codes. It returns
+// models.ErrInvalidCredentials if they don't have that code, or it's been
+// used already.
+func (m *RecoveryCodeModel) Consume(userID int, code string) error {
+	// Deleting the code is what uses it up, so if two requests race to use
+	// the same one, only the first gets to delete it
+	stmt := `DELETE FROM recovery_codes WHERE user_id = $1 AND code_hash = $2`
+	result, err := m.DB.Exec(stmt, userID, models.HashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrInvalidCredentials
+	}
+	return nil
+}
+
+// Count returns how many unused recovery codes the user has left
+func (m *RecoveryCodeModel) Count(userID int) (int, error) {
+	var n int
+	err := m.DB.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1`, userID).Scan(&n)
+	return n, err
+}
+
+// Delete all of the user's recovery codes, which happens when they turn off
+// two-factor authentication
+func (m *RecoveryCodeModel) Delete(userID int) error {
+	_, err := m.DB.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	return err
+}
@@ -0,0 +1,85 @@
+package postgres
+
+import (
+	"database/sql"
+	"dvhthomas/snippetbox/pkg/models"
+	"errors"
+	"time"
+)
+
+// PasswordResetModel works with the links that are emailed to users who have
+// forgotten their password
+type PasswordResetModel struct {
+	DB *sql.DB
+}
+
+// Insert creates a reset token for the user that lasts for ttl and returns
+// it. Like API tokens, only its hash is stored.
+func (m *PasswordResetModel) Insert(userID int, ttl time.Duration) (string, error) {
+	token, hash, err := models.NewToken()
+	if err != nil {
+		return "", err
+	}
+
+	// Tidy up the ones that nobody used while we're here
+	_, err = m.DB.Exec(`DELETE FROM password_resets WHERE expires <= NOW()`)
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `INSERT INTO password_resets (user_id, token_hash, created, expires)
+	VALUES($1, $2, NOW(), NOW() + $3 * INTERVAL '1 second')`
+
+	_, err = m.DB.Exec(stmt, userID, hash, int(ttl.Seconds()))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Check returns the ID of the user the token belongs to without using it up.
+// Unknown and expired tokens, and tokens belonging to users that have been
+// deactivated, are invalid credentials.
+func (m *PasswordResetModel) Check(token string) (int, error) {
+	var userID int
+	stmt := `SELECT r.user_id FROM password_resets r
+	INNER JOIN users u ON u.id = r.user_id
+	WHERE r.token_hash = $1 AND r.expires > NOW() AND u.active = TRUE`
+	err := m.DB.QueryRow(stmt, models.HashToken(token)).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, models.ErrInvalidCredentials
+		}
+		return 0, err
+	}
+	return userID, nil
+}
+
+// Consume checks the token and uses it up, returning the ID of the user it
+// belongs to. Any other reset tokens the user has are thrown away too.
+func (m *PasswordResetModel) Consume(token string) (int, error) {
+	userID, err := m.Check(token)
+	if err != nil {
+		return 0, err
+	}
+
+	// Deleting the token is what uses it up. If two requests race to use
+	// the same one, only the first gets to delete it.
+	result, err := m.DB.Exec(`DELETE FROM password_resets WHERE token_hash = $1`, models.HashToken(token))
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, models.ErrInvalidCredentials
+	}
+
+	_, err = m.DB.Exec(`DELETE FROM password_resets WHERE user_id = $1`, userID)
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
@@ -0,0 +1,207 @@
+package postgres
+
+import (
+	"database/sql"
+	"dvhthomas/snippetbox/pkg/models"
+	"errors"
+)
+
+// SnippetModel works with the snippets table
+type SnippetModel struct {
+	DB *sql.DB
+}
+
+// The same columns as the MySQL backend, so that scanning works the same way
+const selectSnippets = `SELECT s.id, s.title, s.content, s.language,
+	s.visibility, s.slug, s.created, s.expires,
+	COALESCE(s.user_id, 0), COALESCE(u.name, '')
+	FROM snippets s LEFT JOIN users u ON u.id = s.user_id`
+
+func scanSnippet(row interface{ Scan(...interface{}) error }) (*models.Snippet, error) {
+	s := &models.Snippet{}
+	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Language,
+		&s.Visibility, &s.Slug, &s.Created, &s.Expires, &s.AuthorID, &s.AuthorName)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (m *SnippetModel) querySnippets(stmt string, args ...interface{}) ([]*models.Snippet, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snippets := []*models.Snippet{}
+	for rows.Next() {
+		s, err := scanSnippet(rows)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return snippets, nil
+}
+
+// Insert adds a new snippet on behalf of the user identified by userID
+func (m *SnippetModel) Insert(userID int, title, content, language, visibility, expires string) (int, error) {
+	slug, err := models.NewSlug()
+	if err != nil {
+		return 0, err
+	}
+
+	// Postgres doesn't support LastInsertId, so ask for the new ID back
+	// instead.
+	stmt := `INSERT INTO snippets (user_id, title, content, language, visibility, slug, created, expires)
+	VALUES($1, $2, $3, $4, $5, $6, NOW(), NOW() + $7::integer * INTERVAL '1 day')
+	RETURNING id`
+
+	var id int
+	err = m.DB.QueryRow(stmt, userID, title, content, language, visibility, slug, expires).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// Get returns a live snippet based on its ID
+func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
+	return m.getSnippet(selectSnippets+` WHERE s.expires > NOW() AND s.id = $1`, id)
+}
+
+// GetBySlug returns a live snippet based on its slug
+func (m *SnippetModel) GetBySlug(slug string) (*models.Snippet, error) {
+	return m.getSnippet(selectSnippets+` WHERE s.expires > NOW() AND s.slug = $1`, slug)
+}
+
+func (m *SnippetModel) getSnippet(stmt string, args ...interface{}) (*models.Snippet, error) {
+	s, err := scanSnippet(m.DB.QueryRow(stmt, args...))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+// Update replaces the title, content, language and visibility of a snippet.
+// Checking that the caller is allowed to change it is up to the handler.
+func (m *SnippetModel) Update(id int, title, content, language, visibility string) error {
+	stmt := `UPDATE snippets SET title = $1, content = $2, language = $3, visibility = $4 WHERE id = $5`
+	_, err := m.DB.Exec(stmt, title, content, language, visibility, id)
+	return err
+}
+
+// Delete removes a snippet permanently
+func (m *SnippetModel) Delete(id int) error {
+	result, err := m.DB.Exec(`DELETE FROM snippets WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+	return nil
+}
+
+// DeleteExpired removes up to limit expired snippets and reports how many
+// went. Postgres has no LIMIT on DELETE, hence the subquery.
+func (m *SnippetModel) DeleteExpired(limit int) (int, error) {
+	stmt := `DELETE FROM snippets WHERE id IN
+	(SELECT id FROM snippets WHERE expires <= NOW() LIMIT $1)`
+
+	result, err := m.DB.Exec(stmt, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// Latest returns the 10 most recently created public snippets
+func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
+	return m.List(nil, 10)
+}
+
+// List returns up to limit live public snippets from the position marked by
+// cursor, newest first. A nil cursor starts at the newest snippet. It's the
+// same keyset pagination as the MySQL backend.
+func (m *SnippetModel) List(cursor *models.Cursor, limit int) ([]*models.Snippet, error) {
+	stmt := selectSnippets + ` WHERE s.expires > NOW() AND s.visibility = 'public'`
+
+	var snippets []*models.Snippet
+	var err error
+	switch {
+	case cursor == nil:
+		stmt += ` ORDER BY s.created DESC, s.id DESC LIMIT $1`
+		snippets, err = m.querySnippets(stmt, limit)
+	case cursor.Before:
+		// Walk towards the newest snippets, then flip them round below
+		stmt += ` AND (s.created, s.id) > ($1, $2) ORDER BY s.created ASC, s.id ASC LIMIT $3`
+		snippets, err = m.querySnippets(stmt, cursor.Created, cursor.ID, limit)
+	default:
+		stmt += ` AND (s.created, s.id) < ($1, $2) ORDER BY s.created DESC, s.id DESC LIMIT $3`
+		snippets, err = m.querySnippets(stmt, cursor.Created, cursor.ID, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cursor != nil && cursor.Before {
+		for i, j := 0, len(snippets)-1; i < j; i, j = i+1, j-1 {
+			snippets[i], snippets[j] = snippets[j], snippets[i]
+		}
+	}
+	return snippets, nil
+}
+
+// ByAuthor returns all of a user's live snippets, newest first, whatever
+// their visibility. It's for showing users their own snippets, so never show
+// the results to anybody else.
+func (m *SnippetModel) ByAuthor(userID int) ([]*models.Snippet, error) {
+	stmt := selectSnippets + ` WHERE s.user_id = $1 AND s.expires > NOW()
+	ORDER BY s.created DESC, s.id DESC`
+	return m.querySnippets(stmt, userID)
+}
+
+// All returns a page of every snippet, newest first, whatever its visibility
+// and whether or not it has expired. It's for the admin console, so never
+// show the results to anybody else. Pages are numbered from 1 and hold
+// models.AdminSnippetsPerPage snippets.
+func (m *SnippetModel) All(page int) ([]*models.Snippet, error) {
+	stmt := selectSnippets + ` ORDER BY s.created DESC, s.id DESC LIMIT $1 OFFSET $2`
+
+	offset := (page - 1) * models.AdminSnippetsPerPage
+	return m.querySnippets(stmt, models.AdminSnippetsPerPage, offset)
+}
+
+// Search returns a page of live public snippets whose title or content match
+// any of the words in the query, best matches first. plainto_tsquery wants
+// every word to match, so its &s are swapped for |s to behave like MySQL's
+// natural language mode. The expression matches the GIN index.
+func (m *SnippetModel) Search(query string, page int) ([]*models.Snippet, error) {
+	stmt := selectSnippets + `,
+	(SELECT replace(plainto_tsquery('english', $1)::text, '&', '|')::tsquery AS q) search
+	WHERE s.expires > NOW() AND s.visibility = 'public'
+	AND to_tsvector('english', s.title || ' ' || s.content) @@ search.q
+	ORDER BY ts_rank(to_tsvector('english', s.title || ' ' || s.content), search.q) DESC, s.id DESC
+	LIMIT $2 OFFSET $3`
+
+	offset := (page - 1) * models.SearchResultsPerPage
+	return m.querySnippets(stmt, query, models.SearchResultsPerPage, offset)
+}
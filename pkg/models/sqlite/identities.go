@@ -0,0 +1,34 @@
+package sqlite
+
+import (
+	"database/sql"
+	"dvhthomas/snippetbox/pkg/models"
+	"errors"
+)
+
+// IdentityModel works with the user_identities table
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+// Insert links the user to their subject at an identity provider
+func (m *IdentityModel) Insert(userID int, issuer, subject string) error {
+	stmt := `INSERT INTO user_identities (user_id, issuer, subject, created) VALUES(?, ?, ?, ?)`
+	_, err := m.DB.Exec(stmt, userID, issuer, subject, now())
+	return err
+}
+
+// Get returns the ID of the user linked to the subject at an identity
+// provider, or models.ErrNoRecord if nobody is
+func (m *IdentityModel) Get(issuer, subject string) (int, error) {
+	var userID int
+	stmt := `SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?`
+	err := m.DB.QueryRow(stmt, issuer, subject).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, models.ErrNoRecord
+		}
+		return 0, err
+	}
+	return userID, nil
+}
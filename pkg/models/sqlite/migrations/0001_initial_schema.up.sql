@@ -0,0 +1,50 @@
+/* The same tables as the MySQL backend. SQLite is relaxed about types, so
+   the important parts are the constraints. Times are stored in UTC as text
+   in a format that sorts the same way as the times do. These are IF NOT
+   EXISTS because the tables used to be created when the database was
+   opened. */
+
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    hashed_password CHAR(60) NOT NULL,
+    created DATETIME NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    CONSTRAINT users_uc_email UNIQUE (email)
+);
+
+CREATE TABLE IF NOT EXISTS snippets (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NULL REFERENCES users(id),
+    title VARCHAR(100) NOT NULL,
+    content TEXT NOT NULL,
+    language VARCHAR(50) NOT NULL DEFAULT '',
+    visibility TEXT NOT NULL DEFAULT 'public'
+        CHECK (visibility IN ('public', 'unlisted', 'private')),
+    slug VARCHAR(12) NOT NULL,
+    created DATETIME NOT NULL,
+    expires DATETIME NOT NULL,
+    CONSTRAINT snippets_uc_slug UNIQUE (slug)
+);
+
+CREATE INDEX IF NOT EXISTS idx_snippets_created ON snippets(created, id);
+CREATE INDEX IF NOT EXISTS idx_snippets_expires ON snippets(expires);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    created DATETIME NOT NULL,
+    last_used DATETIME NULL,
+    CONSTRAINT api_tokens_uc_token_hash UNIQUE (token_hash)
+);
+
+/* Before migrations the tables were only ever created by the code above, so
+   any that are already there should match it. In case they don't, like
+   tables by the same names left from something else, these fail rather than
+   recording version 1 for tables the app can't use. */
+SELECT id, name, email, hashed_password, created, active FROM users LIMIT 0;
+SELECT id, user_id, title, content, language, visibility, slug, created, expires FROM snippets LIMIT 0;
+SELECT id, user_id, name, token_hash, created, last_used FROM api_tokens LIMIT 0;
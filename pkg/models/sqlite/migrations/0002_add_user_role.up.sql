@@ -0,0 +1,2 @@
+/* Admins can manage other users. Everybody else is a plain user. */
+ALTER TABLE users ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'user';
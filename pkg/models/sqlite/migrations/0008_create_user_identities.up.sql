@@ -0,0 +1,11 @@
+/* Accounts at identity providers that users can log in with instead of a
+   password. The subject is the provider's ID for the user, which unlike
+   their email address never changes. */
+CREATE TABLE user_identities (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    created DATETIME NOT NULL,
+    CONSTRAINT user_identities_uc_issuer_subject UNIQUE (issuer, subject)
+);
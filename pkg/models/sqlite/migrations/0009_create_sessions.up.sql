@@ -0,0 +1,16 @@
+/* Browsers that users have logged in on. The session cookie holds a token
+   that picks out one of these, and like API tokens only its hash is kept.
+   Deleting the row logs that browser out, whatever its cookie says. */
+CREATE TABLE sessions (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash CHAR(64) NOT NULL,
+    ip VARCHAR(45) NOT NULL,
+    user_agent VARCHAR(255) NOT NULL,
+    created DATETIME NOT NULL,
+    last_seen DATETIME NOT NULL,
+    expires DATETIME NOT NULL,
+    CONSTRAINT sessions_uc_token_hash UNIQUE (token_hash)
+);
+
+CREATE INDEX idx_sessions_expires ON sessions(expires);
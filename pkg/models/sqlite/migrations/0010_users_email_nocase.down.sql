@@ -0,0 +1 @@
+DROP INDEX users_uc_email_nocase;
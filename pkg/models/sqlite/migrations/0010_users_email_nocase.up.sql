@@ -0,0 +1,7 @@
+/* Email addresses are compared without regard to case, the same as MySQL
+   does, so that ALICE@example.com can't sign up alongside alice@example.com.
+   SQLite can't change a column's collation without rebuilding the table, so
+   this index enforces it and the queries ask for COLLATE NOCASE to use it.
+   This fails if there already are two such users, who need sorting out by
+   hand first. */
+CREATE UNIQUE INDEX users_uc_email_nocase ON users (email COLLATE NOCASE);
@@ -0,0 +1,243 @@
+package sqlite
+
+import (
+	"database/sql"
+	"dvhthomas/snippetbox/pkg/models"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// SnippetModel works with the snippets table
+type SnippetModel struct {
+	DB *sql.DB
+}
+
+// The same columns as the MySQL backend, so that scanning works the same way
+const selectSnippets = `SELECT s.id, s.title, s.content, s.language,
+	s.visibility, s.slug, s.created, s.expires,
+	IFNULL(s.user_id, 0), IFNULL(u.name, '')
+	FROM snippets s LEFT JOIN users u ON u.id = s.user_id`
+
+func scanSnippet(row interface{ Scan(...interface{}) error }) (*models.Snippet, error) {
+	s := &models.Snippet{}
+	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Language,
+		&s.Visibility, &s.Slug, &s.Created, &s.Expires, &s.AuthorID, &s.AuthorName)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (m *SnippetModel) querySnippets(stmt string, args ...interface{}) ([]*models.Snippet, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snippets := []*models.Snippet{}
+	for rows.Next() {
+		s, err := scanSnippet(rows)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return snippets, nil
+}
+
+// Insert adds a new snippet on behalf of the user identified by userID. The
+// expiry is worked out here rather than in SQL since SQLite's date functions
+// don't keep fractions of a second.
+func (m *SnippetModel) Insert(userID int, title, content, language, visibility, expires string) (int, error) {
+	days, err := strconv.Atoi(expires)
+	if err != nil {
+		return 0, err
+	}
+	slug, err := models.NewSlug()
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `INSERT INTO snippets (user_id, title, content, language, visibility, slug, created, expires)
+	VALUES(?, ?, ?, ?, ?, ?, ?, ?)`
+
+	created := now()
+	result, err := m.DB.Exec(stmt, userID, title, content, language, visibility, slug,
+		created, created.AddDate(0, 0, days))
+	if err != nil {
+		return 0, err
+	}
+
+	// Unlike Postgres, SQLite does support LastInsertId
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// Get returns a live snippet based on its ID
+func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
+	stmt := selectSnippets + ` WHERE s.expires > ? AND s.id = ?`
+	return m.getSnippet(stmt, now(), id)
+}
+
+// GetBySlug returns a live snippet based on its slug
+func (m *SnippetModel) GetBySlug(slug string) (*models.Snippet, error) {
+	stmt := selectSnippets + ` WHERE s.expires > ? AND s.slug = ?`
+	return m.getSnippet(stmt, now(), slug)
+}
+
+func (m *SnippetModel) getSnippet(stmt string, args ...interface{}) (*models.Snippet, error) {
+	s, err := scanSnippet(m.DB.QueryRow(stmt, args...))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+// Update replaces the title, content, language and visibility of a snippet.
+// Checking that the caller is allowed to change it is up to the handler.
+func (m *SnippetModel) Update(id int, title, content, language, visibility string) error {
+	stmt := `UPDATE snippets SET title = ?, content = ?, language = ?, visibility = ? WHERE id = ?`
+	_, err := m.DB.Exec(stmt, title, content, language, visibility, id)
+	return err
+}
+
+// Delete removes a snippet permanently
+func (m *SnippetModel) Delete(id int) error {
+	result, err := m.DB.Exec(`DELETE FROM snippets WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+	return nil
+}
+
+// DeleteExpired removes up to limit expired snippets and reports how many
+// went. SQLite only allows a LIMIT on DELETE if it was compiled that way,
+// hence the subquery.
+func (m *SnippetModel) DeleteExpired(limit int) (int, error) {
+	stmt := `DELETE FROM snippets WHERE id IN
+	(SELECT id FROM snippets WHERE expires <= ? LIMIT ?)`
+
+	result, err := m.DB.Exec(stmt, now(), limit)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// Latest returns the 10 most recently created public snippets
+func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
+	return m.List(nil, 10)
+}
+
+// List returns up to limit live public snippets from the position marked by
+// cursor, newest first. A nil cursor starts at the newest snippet. It's the
+// same keyset pagination as the MySQL backend.
+func (m *SnippetModel) List(cursor *models.Cursor, limit int) ([]*models.Snippet, error) {
+	stmt := selectSnippets + ` WHERE s.expires > ? AND s.visibility = 'public'`
+	args := []interface{}{now()}
+
+	switch {
+	case cursor == nil:
+		stmt += ` ORDER BY s.created DESC, s.id DESC LIMIT ?`
+	case cursor.Before:
+		stmt += ` AND (s.created > ? OR (s.created = ? AND s.id > ?))
+		ORDER BY s.created ASC, s.id ASC LIMIT ?`
+		created := cursor.Created.UTC()
+		args = append(args, created, created, cursor.ID)
+	default:
+		stmt += ` AND (s.created < ? OR (s.created = ? AND s.id < ?))
+		ORDER BY s.created DESC, s.id DESC LIMIT ?`
+		created := cursor.Created.UTC()
+		args = append(args, created, created, cursor.ID)
+	}
+	args = append(args, limit)
+
+	snippets, err := m.querySnippets(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if cursor != nil && cursor.Before {
+		for i, j := 0, len(snippets)-1; i < j; i, j = i+1, j-1 {
+			snippets[i], snippets[j] = snippets[j], snippets[i]
+		}
+	}
+	return snippets, nil
+}
+
+// ByAuthor returns all of a user's live snippets, newest first, whatever
+// their visibility. It's for showing users their own snippets, so never show
+// the results to anybody else.
+func (m *SnippetModel) ByAuthor(userID int) ([]*models.Snippet, error) {
+	stmt := selectSnippets + ` WHERE s.user_id = ? AND s.expires > ?
+	ORDER BY s.created DESC, s.id DESC`
+	return m.querySnippets(stmt, userID, now())
+}
+
+// All returns a page of every snippet, newest first, whatever its visibility
+// and whether or not it has expired. It's for the admin console, so never
+// show the results to anybody else. Pages are numbered from 1 and hold
+// models.AdminSnippetsPerPage snippets.
+func (m *SnippetModel) All(page int) ([]*models.Snippet, error) {
+	stmt := selectSnippets + ` ORDER BY s.created DESC, s.id DESC LIMIT ? OFFSET ?`
+
+	offset := (page - 1) * models.AdminSnippetsPerPage
+	return m.querySnippets(stmt, models.AdminSnippetsPerPage, offset)
+}
+
+// Search returns a page of live public snippets whose title or content
+// contain any of the words in the query, ignoring case, newest first. It's
+// not as clever as MySQL's full-text search but there's no index to set up.
+func (m *SnippetModel) Search(query string, page int) ([]*models.Snippet, error) {
+	terms := strings.Fields(query)
+	if len(terms) == 0 {
+		return []*models.Snippet{}, nil
+	}
+
+	stmt := selectSnippets + ` WHERE s.expires > ? AND s.visibility = 'public' AND (`
+	args := []interface{}{now()}
+	for i, term := range terms {
+		if i > 0 {
+			stmt += ` OR `
+		}
+		stmt += `s.title LIKE ? ESCAPE '\' OR s.content LIKE ? ESCAPE '\'`
+		pattern := "%" + escapeLike(term) + "%"
+		args = append(args, pattern, pattern)
+	}
+	stmt += `) ORDER BY s.created DESC, s.id DESC LIMIT ? OFFSET ?`
+
+	offset := (page - 1) * models.SearchResultsPerPage
+	args = append(args, models.SearchResultsPerPage, offset)
+	return m.querySnippets(stmt, args...)
+}
+
+// Stop the LIKE wildcards in a search term from acting as wildcards
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
@@ -0,0 +1,51 @@
+// Package sqlite stores everything in a single SQLite file, which means the
+// application can run without a database server.
+package sqlite
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Migrations returns the changes to the schema, which are applied with
+// pkg/models/migrate.
+func Migrations() fs.FS {
+	sub, err := fs.Sub(migrations, "migrations")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// Open the database file at path, creating it if need be. The tables are
+// created by the migrations.
+func Open(path string) (*sql.DB, error) {
+	// Foreign keys are off unless you ask, and the busy timeout stops
+	// concurrent writers from failing straight away with "database is locked".
+	dsn := fmt.Sprintf("file:%s?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL", path)
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// sql.Open doesn't touch the file, so check now that it can rather than
+	// on the first request
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// The time now, to the same precision as the other backends store it. Every
+// time is stored in UTC so that comparing the text compares the times.
+func now() time.Time {
+	return time.Now().UTC().Round(0)
+}
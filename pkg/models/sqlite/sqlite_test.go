@@ -0,0 +1,73 @@
+package sqlite
+
+import (
+	"dvhthomas/snippetbox/pkg/models/migrate"
+	"dvhthomas/snippetbox/pkg/models/modeltest"
+	"path/filepath"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+	modeltest.Run(t, func(t *testing.T) *modeltest.Store {
+		db, err := Open(filepath.Join(t.TempDir(), "snippetbox.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		m, err := migrate.New(db, "sqlite", Migrations())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = m.Up(); err != nil {
+			t.Fatal(err)
+		}
+
+		return &modeltest.Store{
+			Snippets:      &SnippetModel{DB: db},
+			Users:         &UserModel{DB: db},
+			Tokens:        &TokenModel{DB: db},
+			Audit:         &AuditModel{DB: db},
+			Resets:        &PasswordResetModel{DB: db},
+			RecoveryCodes: &RecoveryCodeModel{DB: db},
+			Identities:    &IdentityModel{DB: db},
+			Sessions:      &SessionModel{DB: db},
+		}
+	})
+}
+
+// A table that was already there but isn't what the app needs stops the
+// first migration, rather than it being recorded as done
+func TestMigrateMismatchedTables(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "snippetbox.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TABLE snippets (
+		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		title VARCHAR(100) NOT NULL,
+		content TEXT NOT NULL,
+		created DATETIME NOT NULL,
+		expires DATETIME NOT NULL
+	)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := migrate.New(db, "sqlite", Migrations())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Up(); err == nil {
+		t.Fatal("want an error; got nil")
+	}
+	status, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status[0].Pending() {
+		t.Errorf("want version 1 still pending; got applied at %v", status[0].Applied)
+	}
+}
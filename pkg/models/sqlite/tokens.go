@@ -0,0 +1,99 @@
+package sqlite
+
+import (
+	"database/sql"
+	"dvhthomas/snippetbox/pkg/models"
+	"errors"
+)
+
+// TokenModel works with the api_tokens table
+type TokenModel struct {
+	DB *sql.DB
+}
+
+// Insert creates a new named token for the user and returns it. This is the
+// only time the token is available since we only store its hash.
+func (m *TokenModel) Insert(userID int, name string) (string, error) {
+	token, hash, err := models.NewToken()
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `INSERT INTO api_tokens (user_id, name, token_hash, created) VALUES(?, ?, ?, ?)`
+
+	_, err = m.DB.Exec(stmt, userID, name, hash, now())
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// List returns all of the user's tokens, newest first
+func (m *TokenModel) List(userID int) ([]*models.APIToken, error) {
+	stmt := `SELECT id, user_id, name, created, last_used FROM api_tokens
+	WHERE user_id = ? ORDER BY created DESC, id DESC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*models.APIToken{}
+	for rows.Next() {
+		t := &models.APIToken{}
+		var lastUsed sql.NullTime
+		err = rows.Scan(&t.ID, &t.UserID, &t.Name, &t.Created, &lastUsed)
+		if err != nil {
+			return nil, err
+		}
+		t.LastUsed = lastUsed.Time
+		tokens = append(tokens, t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Delete revokes one of the user's tokens. Tokens belonging to somebody else
+// are treated as if they don't exist.
+func (m *TokenModel) Delete(userID, id int) error {
+	result, err := m.DB.Exec(`DELETE FROM api_tokens WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+	return nil
+}
+
+// Authenticate returns the ID of the active user that owns the token and
+// records that the token was used.
+func (m *TokenModel) Authenticate(token string) (int, error) {
+	hash := models.HashToken(token)
+
+	var id, userID int
+	stmt := `SELECT t.id, t.user_id FROM api_tokens t
+	INNER JOIN users u ON u.id = t.user_id
+	WHERE t.token_hash = ? AND u.active = TRUE`
+	err := m.DB.QueryRow(stmt, hash).Scan(&id, &userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, models.ErrInvalidCredentials
+		}
+		return 0, err
+	}
+
+	_, err = m.DB.Exec(`UPDATE api_tokens SET last_used = ? WHERE id = ?`, now(), id)
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
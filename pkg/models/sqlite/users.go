@@ -0,0 +1,260 @@
+package sqlite
+
+import (
+	"database/sql"
+	"dvhthomas/snippetbox/pkg/models"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// UserModel works with the users table
+type UserModel struct {
+	DB *sql.DB
+}
+
+// Insert adds a new user. Email addresses have to be unique.
+func (m *UserModel) Insert(name, email, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO users (name, email, hashed_password, created) VALUES(?, ?, ?, ?)`
+
+	_, err = m.DB.Exec(stmt, name, email, string(hashedPassword), now())
+	if isDuplicateEmail(err) {
+		return models.ErrDuplicateEmail
+	}
+	return err
+}
+
+// Report whether err is from another user already having the email address
+func isDuplicateEmail(err error) bool {
+	var sqliteError sqlite3.Error
+	return errors.As(err, &sqliteError) &&
+		sqliteError.ExtendedCode == sqlite3.ErrConstraintUnique &&
+		strings.Contains(sqliteError.Error(), "users.email")
+}
+
+// Authenticate returns the ID of the active user with the email address and
+// password, or models.ErrInvalidCredentials if there isn't one. A user who
+// hasn't verified their email address yet gets models.ErrUnverified.
+func (m *UserModel) Authenticate(email, password string) (int, error) {
+	var id int
+	var hashedPassword []byte
+	var verified bool
+	stmt := `SELECT id, hashed_password, verified FROM users WHERE email = ? COLLATE NOCASE AND active = TRUE`
+	err := m.DB.QueryRow(stmt, email).Scan(&id, &hashedPassword, &verified)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, models.ErrInvalidCredentials
+		}
+		return 0, err
+	}
+
+	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return 0, models.ErrInvalidCredentials
+		}
+		return 0, err
+	}
+
+	// Only tell them the address isn't verified once they've shown that they
+	// know the password
+	if !verified {
+		return 0, models.ErrUnverified
+	}
+
+	return id, nil
+}
+
+// The columns that fill in a models.User, in the order scanUser expects
+const userColumns = `id, name, email, created, active, role, verified, totp_secret`
+
+// Scan the columns in userColumns into a new user
+func scanUser(row interface{ Scan(...interface{}) error }) (*models.User, error) {
+	u := &models.User{}
+	err := row.Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Active, &u.Role, &u.Verified, &u.TOTPSecret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		}
+		return nil, err
+	}
+	return u, nil
+}
+
+// Get a user based on their unique ID
+func (m *UserModel) Get(id int) (*models.User, error) {
+	stmt := `SELECT ` + userColumns + ` FROM users WHERE id = ?`
+	return scanUser(m.DB.QueryRow(stmt, id))
+}
+
+// GetByEmail finds a user by their email address, whether they're active or not
+func (m *UserModel) GetByEmail(email string) (*models.User, error) {
+	stmt := `SELECT ` + userColumns + ` FROM users WHERE email = ? COLLATE NOCASE`
+	return scanUser(m.DB.QueryRow(stmt, email))
+}
+
+// List every user in the order they signed up
+func (m *UserModel) List() ([]*models.User, error) {
+	stmt := `SELECT ` + userColumns + ` FROM users ORDER BY id`
+	rows, err := m.DB.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*models.User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// SetActive lets a user log in again, or stops them. Like Update on
+// snippets, a missing user isn't an error.
+func (m *UserModel) SetActive(id int, active bool) error {
+	stmt := `UPDATE users SET active = ? WHERE id = ?`
+	_, err := m.DB.Exec(stmt, active, id)
+	return err
+}
+
+// SetVerified records whether the user has shown that their email address is
+// really theirs. Like SetActive, a missing user isn't an error.
+func (m *UserModel) SetVerified(id int, verified bool) error {
+	stmt := `UPDATE users SET verified = ? WHERE id = ?`
+	_, err := m.DB.Exec(stmt, verified, id)
+	return err
+}
+
+// SetPassword replaces a user's password
+func (m *UserModel) SetPassword(id int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+
+	stmt := `UPDATE users SET hashed_password = ? WHERE id = ?`
+	_, err = m.DB.Exec(stmt, string(hashedPassword), id)
+	return err
+}
+
+// SetName changes the name that a user's snippets are shown with
+func (m *UserModel) SetName(id int, name string) error {
+	stmt := `UPDATE users SET name = ? WHERE id = ?`
+	_, err := m.DB.Exec(stmt, name, id)
+	return err
+}
+
+// SetEmail changes a user's email address. Nobody has shown that the new
+// address is theirs yet, so it needs verifying again. It returns
+// models.ErrDuplicateEmail if another user already has the address.
+func (m *UserModel) SetEmail(id int, email string) error {
+	stmt := `UPDATE users SET email = ?, verified = ? WHERE id = ?`
+	_, err := m.DB.Exec(stmt, email, false, id)
+	if isDuplicateEmail(err) {
+		return models.ErrDuplicateEmail
+	}
+	return err
+}
+
+// ChangePassword replaces a user's password, but only if they know the
+// current one. Otherwise it returns models.ErrInvalidCredentials.
+func (m *UserModel) ChangePassword(id int, currentPassword, newPassword string) error {
+	var hashedPassword []byte
+	stmt := `SELECT hashed_password FROM users WHERE id = ?`
+	err := m.DB.QueryRow(stmt, id).Scan(&hashedPassword)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ErrInvalidCredentials
+		}
+		return err
+	}
+
+	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(currentPassword))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return models.ErrInvalidCredentials
+		}
+		return err
+	}
+	return m.SetPassword(id, newPassword)
+}
+
+// SetTOTPSecret turns on two-factor authentication for a user with the
+// already encrypted secret, or turns it off again if the secret is nil. Codes
+// from before are forgotten either way.
+func (m *UserModel) SetTOTPSecret(id int, secret []byte) error {
+	stmt := `UPDATE users SET totp_secret = ?, totp_last_step = 0 WHERE id = ?`
+	_, err := m.DB.Exec(stmt, secret, id)
+	return err
+}
+
+// UseTOTPStep records that the user has logged in with the code for a step.
+// Codes last for a while, so that stops anybody who sees one being used
+// from using it again. It returns models.ErrInvalidCredentials if the step,
+// or a later one, has been used already.
+func (m *UserModel) UseTOTPStep(id int, step int64) error {
+	stmt := `UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?`
+	result, err := m.DB.Exec(stmt, step, id, step)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrInvalidCredentials
+	}
+	return nil
+}
+
+// SetRole changes what a user is allowed to do, to either models.RoleUser or
+// models.RoleAdmin
+func (m *UserModel) SetRole(id int, role string) error {
+	stmt := `UPDATE users SET role = ? WHERE id = ?`
+	_, err := m.DB.Exec(stmt, role, id)
+	return err
+}
+
+// LoginFailures returns how many times in a row logging in as email has
+// failed, and when it last did. It's zero and the zero time if it hasn't.
+func (m *UserModel) LoginFailures(email string) (int, time.Time, error) {
+	var failures int
+	var last time.Time
+	stmt := `SELECT failures, last_failure FROM login_failures WHERE email = ?`
+	err := m.DB.QueryRow(stmt, email).Scan(&failures, &last)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, time.Time{}, nil
+		}
+		return 0, time.Time{}, err
+	}
+	return failures, last, nil
+}
+
+// RecordLoginFailure counts another failed login as email
+func (m *UserModel) RecordLoginFailure(email string) error {
+	stmt := `INSERT INTO login_failures (email, failures, last_failure) VALUES(?, 1, ?)
+	ON CONFLICT (email) DO UPDATE SET failures = failures + 1, last_failure = excluded.last_failure`
+	_, err := m.DB.Exec(stmt, email, now())
+	return err
+}
+
+// ResetLoginFailures forgets the failed logins as email, which happens when
+// one succeeds
+func (m *UserModel) ResetLoginFailures(email string) error {
+	_, err := m.DB.Exec(`DELETE FROM login_failures WHERE email = ?`, email)
+	return err
+}
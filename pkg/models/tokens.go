@@ -0,0 +1,85 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// NewToken generates a random, URL-safe token along with the hash that should
+// be stored in its place. The token itself is only ever given to the user.
+func NewToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the hash of a token for storing and looking up. Tokens
+// are long and random, so unlike passwords a fast hash is fine here.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// The characters that make up a slug. Letters and digits are URL-safe and
+// easy to copy and paste.
+const slugAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// SlugLength is long enough that slugs can't be guessed. 62^12 is over 10^21.
+const SlugLength = 12
+
+// NewSlug generates a random short ID for a snippet
+func NewSlug() (string, error) {
+	b := make([]byte, SlugLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	// 256 isn't a multiple of 62 so taking the modulus very slightly favours
+	// the first few characters. Re-draw those bytes rather than have any bias.
+	for i := range b {
+		for b[i] >= 248 {
+			if _, err := rand.Read(b[i : i+1]); err != nil {
+				return "", err
+			}
+		}
+		b[i] = slugAlphabet[int(b[i])%len(slugAlphabet)]
+	}
+	return string(b), nil
+}
+
+// Recovery codes get typed in by hand, so they leave out the letters and
+// digits that are easy to mix up. There are 32 of them, which means every
+// random byte maps onto one without any bias.
+const recoveryCodeAlphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+
+// NewRecoveryCode generates a random one-time code like "abcde-23456", along
+// with the hash that should be stored in its place. Ten characters from 32
+// is 50 bits, which is plenty when guesses go through the login lockout.
+func NewRecoveryCode() (code, hash string, err error) {
+	b := make([]byte, 10)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+	for i := range b {
+		b[i] = recoveryCodeAlphabet[int(b[i])%len(recoveryCodeAlphabet)]
+	}
+
+	code = string(b[:5]) + "-" + string(b[5:])
+	return code, HashRecoveryCode(code), nil
+}
+
+// HashRecoveryCode returns the hash of a recovery code for storing and looking
+// up. It doesn't matter how the code was typed in, with or without the dash
+// and in upper or lower case.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return HashToken(code)
+}
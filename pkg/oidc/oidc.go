@@ -0,0 +1,399 @@
+// Package oidc logs users in with an OpenID Connect identity provider. It
+// only does the authorization code flow with PKCE, which is what a server
+// side web application needs, and only checks ID tokens signed with RS256,
+// which every provider supports.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidToken is returned for an ID token that isn't signed by the
+// provider, isn't meant for us, has expired or is from a different login
+var ErrInvalidToken = errors.New("oidc: invalid ID token")
+
+// How far the provider's clock is allowed to be from ours
+const leeway = time.Minute
+
+// The provider's keys are fetched again when a token is signed with one we
+// haven't seen, but no more often than this so that made up key IDs can't be
+// used to make us hammer the provider
+const keyRefreshInterval = time.Minute
+
+// Provider is an identity provider that users can log in with. It finds out
+// where everything is from the issuer's discovery document the first time
+// it's needed, so it can be made before the provider is reachable.
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// Where the provider sends users back to, which has to be registered
+	// with it
+	RedirectURL string
+	// Used for everything sent to the provider. Defaults to a client with a
+	// ten second timeout.
+	Client *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      map[string]*rsa.PublicKey
+	fetched   time.Time
+	// The time now, which the tests can change
+	now func() time.Time
+}
+
+// The parts of the discovery document that we use
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// New returns a provider for the issuer, which is a URL like
+// https://login.example.com
+func New(issuer, clientID, clientSecret, redirectURL string) *Provider {
+	return &Provider{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+		now:          time.Now,
+	}
+}
+
+// AuthRequest is a login that has been started. The user is sent to URL, and
+// the rest has to be kept until they come back, without them being able to
+// change it.
+type AuthRequest struct {
+	URL string
+	// Sent back with the user so we know it's the login we started, and not
+	// one that somebody else started and tricked them into finishing
+	State string
+	// Put in the ID token so that it can't be replayed in another login
+	Nonce string
+	// The PKCE secret. Only its hash goes in URL, and the token endpoint
+	// wants the secret itself, so a stolen code is no use on its own.
+	Verifier string
+}
+
+// Claims are what the provider tells us about the user. Subject identifies
+// them for good, whereas their email address might change.
+type Claims struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// AuthCodeURL starts a login
+func (p *Provider) AuthCodeURL(ctx context.Context) (*AuthRequest, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ar := &AuthRequest{}
+	for _, s := range []*string{&ar.State, &ar.Nonce, &ar.Verifier} {
+		if *s, err = randomString(); err != nil {
+			return nil, err
+		}
+	}
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", p.RedirectURL)
+	q.Set("scope", "openid email profile")
+	q.Set("state", ar.State)
+	q.Set("nonce", ar.Nonce)
+	q.Set("code_challenge", Challenge(ar.Verifier))
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	ar.URL = d.AuthorizationEndpoint + sep + q.Encode()
+	return ar, nil
+}
+
+// Challenge is the S256 PKCE challenge for a verifier
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Exchange the code that the user came back with for their claims. The
+// verifier and nonce come from the AuthRequest that started the login.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("code_verifier", verifier)
+	// Public clients don't have a secret and just say who they are
+	if p.ClientSecret == "" {
+		form.Set("client_id", p.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	rs, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Body.Close()
+	if err = json.NewDecoder(io.LimitReader(rs.Body, 1<<20)).Decode(&token); err != nil {
+		return nil, fmt.Errorf("oidc: token response: %w", err)
+	}
+	if rs.StatusCode != http.StatusOK || token.Error != "" {
+		return nil, fmt.Errorf("oidc: token endpoint said %d %s %s", rs.StatusCode, token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("oidc: no ID token in the token response")
+	}
+
+	return p.Verify(ctx, token.IDToken, nonce)
+}
+
+// Verify checks that an ID token was signed by the provider for us, hasn't
+// expired and has the nonce from the login, and returns its claims
+func (p *Provider) Verify(ctx context.Context, rawToken, nonce string) (*Claims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+	// Anything else, "none" above all, is refused
+	if header.Alg != "RS256" {
+		return nil, ErrInvalidToken
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err = rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	// Only now that we know the provider wrote it are the claims worth
+	// looking at
+	var claims struct {
+		Claims
+		Issuer   string   `json:"iss"`
+		Audience audience `json:"aud"`
+		AZP      string   `json:"azp"`
+		Expires  int64    `json:"exp"`
+		IssuedAt int64    `json:"iat"`
+		Nonce    string   `json:"nonce"`
+	}
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	now := p.now()
+	switch {
+	case claims.Issuer != p.Issuer:
+	case !claims.Audience.contains(p.ClientID):
+	// When there's more than one audience, the one it was issued to has to
+	// be us
+	case len(claims.Audience) > 1 && claims.AZP != p.ClientID:
+	case !now.Add(-leeway).Before(time.Unix(claims.Expires, 0)):
+	case now.Add(leeway).Before(time.Unix(claims.IssuedAt, 0)):
+	case subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+	case claims.Subject == "":
+	default:
+		return &claims.Claims, nil
+	}
+	return nil, ErrInvalidToken
+}
+
+// The aud claim is either a string or a list of them
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+func (a audience) contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Fetch the discovery document, or return it if we already have. A failure
+// isn't kept, so the next login tries again.
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	d := &discovery{}
+	if err := p.getJSON(ctx, p.Issuer+"/.well-known/openid-configuration", d); err != nil {
+		return nil, err
+	}
+	// Otherwise somebody who could change the document could send us
+	// somewhere else for keys and have their tokens believed
+	if d.Issuer != p.Issuer {
+		return nil, fmt.Errorf("oidc: discovery document is for issuer %q, not %q", d.Issuer, p.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery document is missing an endpoint")
+	}
+	p.discovery = d
+	return d, nil
+}
+
+// Return the provider's public key with the ID kid
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	// Providers rotate their keys, so one we don't know might be new
+	if p.now().Sub(p.fetched) < keyRefreshInterval {
+		return nil, ErrInvalidToken
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err = p.getJSON(ctx, d.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	p.fetched = p.now()
+
+	p.keys = map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		p.keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrInvalidToken
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	rs, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rs.Body.Close()
+	if rs.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: %s said %d", url, rs.StatusCode)
+	}
+	if err = json.NewDecoder(io.LimitReader(rs.Body, 1<<20)).Decode(v); err != nil {
+		return fmt.Errorf("oidc: %s: %w", url, err)
+	}
+	return nil
+}
+
+func decodeSegment(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// 32 random bytes, which is what RFC 7636 recommends for the PKCE verifier
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
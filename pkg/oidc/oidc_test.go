@@ -0,0 +1,197 @@
+package oidc
+
+import (
+	"context"
+	"dvhthomas/snippetbox/pkg/oidc/oidctest"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+var alice = oidctest.Identity{
+	Subject:       "alice-subject",
+	Email:         "alice@example.com",
+	EmailVerified: true,
+	Name:          "Alice",
+}
+
+const redirectURL = "https://snippetbox.example.com/user/login/oidc/callback"
+
+// Go through the whole login the way a browser would, and return the code
+// and state the user comes back with
+func authorize(t *testing.T, p *Provider) (*AuthRequest, string) {
+	t.Helper()
+	ar, err := p.AuthCodeURL(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	rs, err := client.Get(ar.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+	loc, err := url.Parse(rs.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loc.Scheme + "://" + loc.Host + loc.Path; got != redirectURL {
+		t.Fatalf("want to be sent back to %s; got %s", redirectURL, got)
+	}
+	if got := loc.Query().Get("state"); got != ar.State {
+		t.Fatalf("want state %q; got %q", ar.State, got)
+	}
+	return ar, loc.Query().Get("code")
+}
+
+func TestLogin(t *testing.T) {
+	issuer := oidctest.NewIssuer(t, alice)
+	p := New(issuer.URL+"/", oidctest.ClientID, oidctest.ClientSecret, redirectURL)
+
+	ar, code := authorize(t, p)
+	claims, err := p.Exchange(context.Background(), code, ar.Verifier, ar.Nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Claims{Subject: alice.Subject, Email: alice.Email, EmailVerified: true, Name: alice.Name}
+	if *claims != want {
+		t.Errorf("want %+v; got %+v", want, *claims)
+	}
+
+	// The code has been used
+	if _, err = p.Exchange(context.Background(), code, ar.Verifier, ar.Nonce); err == nil {
+		t.Error("want an error for a used code")
+	}
+
+	// A stolen code is no use without the verifier
+	ar, code = authorize(t, p)
+	if _, err = p.Exchange(context.Background(), code, "the-wrong-verifier", ar.Nonce); err == nil {
+		t.Error("want an error for the wrong verifier")
+	}
+
+	// Or with the nonce from a different login
+	ar, code = authorize(t, p)
+	if _, err = p.Exchange(context.Background(), code, ar.Verifier, "another-nonce"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("want %v for the wrong nonce; got %v", ErrInvalidToken, err)
+	}
+
+	// Every login is different
+	other, err := p.AuthCodeURL(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.State == ar.State || other.Nonce == ar.Nonce || other.Verifier == ar.Verifier {
+		t.Error("want new random values for every login")
+	}
+}
+
+func TestWrongClientSecret(t *testing.T) {
+	issuer := oidctest.NewIssuer(t, alice)
+	p := New(issuer.URL, oidctest.ClientID, "wrong-secret", redirectURL)
+
+	ar, code := authorize(t, p)
+	if _, err := p.Exchange(context.Background(), code, ar.Verifier, ar.Nonce); err == nil {
+		t.Error("want an error")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	issuer := oidctest.NewIssuer(t, alice)
+	p := New(issuer.URL, oidctest.ClientID, oidctest.ClientSecret, redirectURL)
+
+	// Make the claims of a good token and change them
+	claims := func(change func(map[string]interface{})) map[string]interface{} {
+		c := issuer.Claims(alice, "nonce")
+		change(c)
+		return c
+	}
+	good := issuer.Sign(claims(func(map[string]interface{}) {}))
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"Good", good, true},
+		{"Several audiences", issuer.Sign(claims(func(c map[string]interface{}) {
+			c["aud"] = []string{"someone-else", oidctest.ClientID}
+			c["azp"] = oidctest.ClientID
+		})), true},
+		{"Several audiences for someone else", issuer.Sign(claims(func(c map[string]interface{}) {
+			c["aud"] = []string{"someone-else", oidctest.ClientID}
+			c["azp"] = "someone-else"
+		})), false},
+		{"Wrong issuer", issuer.Sign(claims(func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" })), false},
+		{"Wrong audience", issuer.Sign(claims(func(c map[string]interface{}) { c["aud"] = "someone-else" })), false},
+		{"Expired", issuer.Sign(claims(func(c map[string]interface{}) { c["exp"] = time.Now().Add(-2 * time.Minute).Unix() })), false},
+		{"Issued in the future", issuer.Sign(claims(func(c map[string]interface{}) { c["iat"] = time.Now().Add(time.Hour).Unix() })), false},
+		{"Wrong nonce", issuer.Sign(claims(func(c map[string]interface{}) { c["nonce"] = "another-nonce" })), false},
+		{"No subject", issuer.Sign(claims(func(c map[string]interface{}) { c["sub"] = "" })), false},
+		{"Unknown key", issuer.SignWithHeader(map[string]interface{}{"alg": "RS256", "kid": "unknown"}, claims(func(map[string]interface{}) {})), false},
+		{"No algorithm", issuer.SignWithHeader(map[string]interface{}{"alg": "none", "kid": issuer.KeyID}, claims(func(map[string]interface{}) {})), false},
+		{"Tampered", good[:len(good)-4] + "AAAA", false},
+		{"Garbage", "not.a.token", false},
+		{"Too few parts", "not-a-token", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.Verify(context.Background(), tt.token, "nonce")
+			if tt.ok {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Subject != alice.Subject {
+					t.Errorf("want subject %q; got %q", alice.Subject, got.Subject)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("want %v; got %v", ErrInvalidToken, err)
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	issuer := oidctest.NewIssuer(t, alice)
+	p := New(issuer.URL, oidctest.ClientID, oidctest.ClientSecret, redirectURL)
+
+	if _, err := p.Verify(context.Background(), issuer.Sign(issuer.Claims(alice, "nonce")), "nonce"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys aren't fetched again straight away...
+	issuer.RotateKey(t, "new-key")
+	token := issuer.Sign(issuer.Claims(alice, "nonce"))
+	if _, err := p.Verify(context.Background(), token, "nonce"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("want %v; got %v", ErrInvalidToken, err)
+	}
+
+	// ...but they are a little later
+	p.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if _, err := p.Verify(context.Background(), token, "nonce"); err != nil {
+		t.Errorf("want the new key used; got %v", err)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	issuer := oidctest.NewIssuer(t, alice)
+
+	// An issuer that the server has never heard of has no discovery document
+	p := New(issuer.URL+"/other", oidctest.ClientID, oidctest.ClientSecret, redirectURL)
+	if _, err := p.AuthCodeURL(context.Background()); err == nil {
+		t.Error("want an error for a missing discovery document")
+	}
+
+	issuer.Close()
+	p = New(issuer.URL, oidctest.ClientID, oidctest.ClientSecret, redirectURL)
+	if _, err := p.AuthCodeURL(context.Background()); err == nil {
+		t.Error("want an error when the issuer is down")
+	}
+}
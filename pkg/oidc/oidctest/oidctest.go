@@ -0,0 +1,233 @@
+// Package oidctest is a fake OpenID Connect identity provider for tests. It
+// serves the discovery document, the keys, and authorization and token
+// endpoints that log in whoever Identity says, without asking anything.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The client that the issuer knows about
+const (
+	ClientID     = "snippetbox"
+	ClientSecret = "client-secret"
+)
+
+// Identity is who the issuer says is logging in
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Issuer is a running fake identity provider
+type Issuer struct {
+	*httptest.Server
+	// KeyID is the ID of the key that tokens are signed with
+	KeyID string
+
+	mu       sync.Mutex
+	key      *rsa.PrivateKey
+	identity Identity
+	codes    map[string]*grant
+}
+
+// What an authorization code was issued for
+type grant struct {
+	identity    Identity
+	redirectURI string
+	challenge   string
+	nonce       string
+}
+
+// NewIssuer starts an issuer that logs in identity, and stops it when the
+// test finishes
+func NewIssuer(t *testing.T, identity Identity) *Issuer {
+	// Small keys are quick to make, and it's only a test
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := &Issuer{KeyID: "test-key", key: key, identity: identity, codes: map[string]*grant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", i.discovery)
+	mux.HandleFunc("/authorize", i.authorize)
+	mux.HandleFunc("/token", i.token)
+	mux.HandleFunc("/keys", i.keys)
+	i.Server = httptest.NewServer(mux)
+	t.Cleanup(i.Close)
+	return i
+}
+
+// SetIdentity changes who logs in next
+func (i *Issuer) SetIdentity(identity Identity) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.identity = identity
+}
+
+// RotateKey starts signing with a new key under a new ID, as providers do
+// from time to time
+func (i *Issuer) RotateKey(t *testing.T, kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.key, i.KeyID = key, kid
+}
+
+// Sign claims as an ID token. Tests can use it to make tokens that are
+// wrong in all sorts of ways.
+func (i *Issuer) Sign(claims map[string]interface{}) string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.sign(map[string]interface{}{"alg": "RS256", "kid": i.KeyID}, claims)
+}
+
+// SignWithHeader is like Sign, but with any header
+func (i *Issuer) SignWithHeader(header, claims map[string]interface{}) string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.sign(header, claims)
+}
+
+// Claims returns the claims of a good ID token for identity, which tests
+// can change before signing them
+func (i *Issuer) Claims(identity Identity, nonce string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":            i.URL,
+		"aud":            ClientID,
+		"sub":            identity.Subject,
+		"email":          identity.Email,
+		"email_verified": identity.EmailVerified,
+		"name":           identity.Name,
+		"nonce":          nonce,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+	}
+}
+
+// The caller must hold the lock
+func (i *Issuer) sign(header, claims map[string]interface{}) string {
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, sum[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (i *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 i.URL,
+		"authorization_endpoint": i.URL + "/authorize",
+		"token_endpoint":         i.URL + "/token",
+		"jwks_uri":               i.URL + "/keys",
+	})
+}
+
+func (i *Issuer) keys(w http.ResponseWriter, r *http.Request) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": i.KeyID,
+			"n":   base64.RawURLEncoding.EncodeToString(i.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(i.key.E)).Bytes()),
+		}},
+	})
+}
+
+// Log in the current identity straight away and send them back with a code
+func (i *Issuer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != ClientID || q.Get("response_type") != "code" ||
+		q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+	i.mu.Lock()
+	i.codes[code] = &grant{
+		identity:    i.identity,
+		redirectURI: q.Get("redirect_uri"),
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+	}
+	i.mu.Unlock()
+
+	back := url.Values{}
+	back.Set("code", code)
+	back.Set("state", q.Get("state"))
+	http.Redirect(w, r, q.Get("redirect_uri")+"?"+back.Encode(), http.StatusFound)
+}
+
+func (i *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != ClientID || secret != ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	// Codes only work once
+	g, ok := i.codes[r.PostFormValue("code")]
+	delete(i.codes, r.PostFormValue("code"))
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" ||
+		r.PostFormValue("redirect_uri") != g.redirectURI {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	// This is what PKCE is for: only whoever started the login knows the
+	// verifier that goes with the challenge
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := i.Claims(g.identity, g.nonce)
+	writeJSON(w, http.StatusOK, map[string]string{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"id_token":     i.sign(map[string]interface{}{"alg": "RS256", "kid": i.KeyID}, claims),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
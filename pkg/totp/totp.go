@@ -0,0 +1,106 @@
+// Package totp generates and checks the time-based one-time passwords of RFC
+// 6238, which is what authenticator apps show. It only does what those apps
+// all agree on by default: HMAC-SHA1, six digits and a new code every 30
+// seconds.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Period is how long each code lasts
+const Period = 30 * time.Second
+
+// Digits is how long each code is
+const Digits = 6
+
+// 10^Digits, which cuts the hash down to that many digits
+const modulus = 1000000
+
+// Skew is how many periods either side of now are still accepted, to allow
+// for clocks that are a little out and for people who type slowly
+const Skew = 1
+
+// SecretSize is the length of a new secret in bytes. RFC 4226 recommends 160
+// bits, the size of a SHA-1 hash.
+const SecretSize = 20
+
+// Authenticator apps expect secrets in base32 without any padding
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret generates a random secret to share with an authenticator app
+func NewSecret() ([]byte, error) {
+	secret := make([]byte, SecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// Encode the secret the way people type it into an authenticator app
+func Encode(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// URL returns the otpauth:// URL that QR codes for authenticator apps hold.
+// The issuer and account name are just labels for the app to show.
+func URL(issuer, account string, secret []byte) string {
+	q := url.Values{}
+	q.Set("secret", Encode(secret))
+	q.Set("issuer", issuer)
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: q.Encode(),
+	}
+	return u.String()
+}
+
+// Step returns the number of the period that t falls in
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code returns the code for the period that t falls in
+func Code(secret []byte, t time.Time) string {
+	return code(secret, Step(t))
+}
+
+func code(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// The dynamic truncation of RFC 4226: the last four bits pick which
+	// four bytes of the hash to use
+	offset := sum[len(sum)-1] & 0xf
+	n := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, n%modulus)
+}
+
+// Validate checks a code someone typed in at time t. If it's right, it
+// returns the step the code was for, so that the caller can refuse to take
+// the same code, or an older one, a second time.
+func Validate(secret []byte, input string, t time.Time) (int64, bool) {
+	if len(input) != Digits {
+		return 0, false
+	}
+	now := Step(t)
+	for step := now - Skew; step <= now+Skew; step++ {
+		if subtle.ConstantTimeCompare([]byte(code(secret, step)), []byte(input)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
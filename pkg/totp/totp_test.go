@@ -0,0 +1,83 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// The SHA-1 test vectors from appendix B of RFC 6238. They have eight
+// digits, and the last six of them are the six digit codes.
+func TestCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		if got := Code(secret, time.Unix(tt.unix, 0)); got != tt.want {
+			t.Errorf("%d: want %q; got %q", tt.unix, tt.want, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111111, 0)
+	code := Code(secret, now)
+
+	tests := []struct {
+		name  string
+		input string
+		at    time.Time
+		want  bool
+	}{
+		{"Now", code, now, true},
+		{"Previous period", code, now.Add(Period), true},
+		{"Next period", code, now.Add(-Period), true},
+		{"Too late", code, now.Add(2 * Period), false},
+		{"Too early", code, now.Add(-2 * Period), false},
+		{"Wrong code", "123456", now, false},
+		{"Too short", code[1:], now, false},
+		{"Empty", "", now, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(secret, tt.input, tt.at)
+			if ok != tt.want {
+				t.Fatalf("want %v; got %v", tt.want, ok)
+			}
+			if ok && step != Step(now) {
+				t.Errorf("want step %d; got %d", Step(now), step)
+			}
+		})
+	}
+}
+
+func TestURL(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	u, err := url.Parse(URL("Snippetbox", "alice@example.com", secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Snippetbox:alice@example.com" {
+		t.Errorf("want otpauth://totp/Snippetbox:alice@example.com; got %s", u)
+	}
+	if got := u.Query().Get("secret"); got != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" {
+		t.Errorf("want the secret in base32; got %q", got)
+	}
+	if got := u.Query().Get("issuer"); got != "Snippetbox" {
+		t.Errorf("want issuer Snippetbox; got %q", got)
+	}
+}